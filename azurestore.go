@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// azureAccessTiers maps S3 storage classes to the closest Azure access tier
+var azureAccessTiers = map[string]blob.AccessTier{
+	"STANDARD":            blob.AccessTierHot,
+	"REDUCED_REDUNDANCY":  blob.AccessTierHot,
+	"INTELLIGENT_TIERING": blob.AccessTierHot,
+	"STANDARD_IA":         blob.AccessTierCool,
+	"ONEZONE_IA":          blob.AccessTierCool,
+	"GLACIER_IR":          blob.AccessTierCold,
+	"GLACIER":             blob.AccessTierArchive,
+	"DEEP_ARCHIVE":        blob.AccessTierArchive,
+}
+
+// azureAccessTier returns the access tier for -storage-class, which may be an
+// S3 class or a native tier such as Cool
+func azureAccessTier(class string) (blob.AccessTier, error) {
+	if class == "" {
+		return "", nil
+	}
+	for _, tier := range []blob.AccessTier{blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold, blob.AccessTierArchive} {
+		if strings.EqualFold(class, string(tier)) {
+			return tier, nil
+		}
+	}
+	if tier, ok := azureAccessTiers[strings.ToUpper(class)]; ok {
+		return tier, nil
+	}
+	return "", fmt.Errorf("unsupported storage class for Azure: %s", class)
+}
+
+// Azure metadata names must be C# identifiers, so the "-" in names such as
+// source-host is stored as "_". Names come back with HTTP header casing.
+func encodeAzureMetadata(metadata map[string]string) map[string]*string {
+	encoded := make(map[string]*string, len(metadata))
+	for name, value := range metadata {
+		encoded[strings.ReplaceAll(name, "-", "_")] = to.Ptr(value)
+	}
+	return encoded
+}
+
+func decodeAzureMetadata(metadata map[string]*string) map[string]string {
+	decoded := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		if value != nil {
+			decoded[strings.ReplaceAll(strings.ToLower(name), "_", "-")] = *value
+		}
+	}
+	return decoded
+}
+
+// azureStore is an ObjectStore backed by an Azure Blob Storage container
+type azureStore struct {
+	container *container.Client
+	name      string
+	// blockSize is the upload buffer from -buffer-size, 0 for the default
+	blockSize int64
+}
+
+// newAzureStore creates the container client. Credentials are taken from
+// AZURE_STORAGE_CONNECTION_STRING, then AZURE_STORAGE_KEY for -azure-account,
+// then DefaultAzureCredential (environment, workload identity, managed
+// identity and the Azure CLI). -endpoint-url replaces the account URL, e.g.
+// http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+func newAzureStore(config Config) (*azureStore, error) {
+	name := config.S3Bucket
+	store := &azureStore{name: name, blockSize: azureBlockSize(bufferSize(config))}
+
+	if connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connectionString != "" {
+		client, err := container.NewClientFromConnectionString(connectionString, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_CONNECTION_STRING: %w", err)
+		}
+		store.container = client
+		return store, nil
+	}
+
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net", config.AzureAccount)
+	if config.EndpointURL != "" {
+		serviceURL = strings.TrimSuffix(config.EndpointURL, "/")
+	}
+	containerURL := serviceURL + "/" + url.PathEscape(name)
+
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		cred, err := container.NewSharedKeyCredential(config.AzureAccount, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+		}
+		client, err := container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Azure client: %w", err)
+		}
+		store.container = client
+		return store, nil
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("no Azure credentials: %w", err)
+	}
+	client, err := container.NewClient(containerURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+	store.container = client
+	return store, nil
+}
+
+func (s *azureStore) Check(ctx context.Context) error {
+	if _, err := s.container.GetProperties(ctx, nil); err != nil {
+		return fmt.Errorf("cannot access Azure container %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// Put uploads the object as a block blob. The MD5 of seekable bodies is
+// stored as the blob's Content-MD5 so verify and restore can check it, and
+// returned as the ETag.
+func (s *azureStore) Put(ctx context.Context, req *PutRequest) (string, error) {
+	tier, err := azureAccessTier(req.StorageClass)
+	if err != nil {
+		return "", err
+	}
+	sum, err := contentMD5(req.Body)
+	if err != nil {
+		return "", err
+	}
+
+	opts := &blockblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentMD5: sum},
+		Metadata:    encodeAzureMetadata(req.Metadata),
+		BlockSize:   s.blockSize,
+	}
+	if tier != "" {
+		opts.AccessTier = to.Ptr(tier)
+	}
+	if req.Tagging != "" {
+		values, err := url.ParseQuery(req.Tagging)
+		if err != nil {
+			return "", fmt.Errorf("invalid tags: %w", err)
+		}
+		opts.Tags = make(map[string]string, len(values))
+		for name := range values {
+			opts.Tags[name] = values.Get(name)
+		}
+	}
+
+	if _, err := s.container.NewBlockBlobClient(req.Key).UploadStream(ctx, req.Body, opts); err != nil {
+		return "", fmt.Errorf("failed to upload to Azure: %w", err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// Head returns the blob properties. The Content-MD5 is reported as the ETag,
+// which is what S3 uses for single-part objects; Azure's own ETag is opaque.
+func (s *azureStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	props, err := s.container.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:           key,
+		Size:          derefInt64(props.ContentLength),
+		LastModified:  derefTime(props.LastModified),
+		ETag:          hex.EncodeToString(props.ContentMD5),
+		StorageClass:  derefString(props.AccessTier),
+		Metadata:      decodeAzureMetadata(props.Metadata),
+		ArchiveStatus: derefString(props.ArchiveStatus),
+	}, nil
+}
+
+func (s *azureStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.container.NewBlobClient(key).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, info, nil
+}
+
+func (s *azureStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	pager := s.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: to.Ptr(prefix)})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Segment.BlobItems {
+			info := ObjectInfo{Key: derefString(item.Name)}
+			if props := item.Properties; props != nil {
+				info.Size = derefInt64(props.ContentLength)
+				info.LastModified = derefTime(props.LastModified)
+				info.ETag = hex.EncodeToString(props.ContentMD5)
+				if props.AccessTier != nil {
+					info.StorageClass = string(*props.AccessTier)
+				}
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *azureStore) Delete(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+	for _, key := range keys {
+		_, err := s.container.NewBlobClient(key).Delete(ctx, &blob.DeleteOptions{
+			DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+		})
+		if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+			failed[key] = err
+		}
+	}
+	return failed, nil
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefInt64(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// TestAzureAccessTier tests mapping S3 storage classes to Azure access tiers
+func TestAzureAccessTier(t *testing.T) {
+	tests := []struct {
+		class   string
+		want    blob.AccessTier
+		wantErr bool
+	}{
+		{"", "", false},
+		{"STANDARD", blob.AccessTierHot, false},
+		{"STANDARD_IA", blob.AccessTierCool, false},
+		{"GLACIER_IR", blob.AccessTierCold, false},
+		{"GLACIER", blob.AccessTierArchive, false},
+		{"cool", blob.AccessTierCool, false},
+		{"Premium", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := azureAccessTier(tt.class)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("azureAccessTier(%q) error = %v, wantErr %v", tt.class, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("azureAccessTier(%q) = %s, want %s", tt.class, got, tt.want)
+		}
+	}
+}
+
+// TestAzureMetadata tests that metadata names survive the round trip through
+// Azure's identifier rules and header casing
+func TestAzureMetadata(t *testing.T) {
+	metadata := map[string]string{"source-host": "web01", "original-path": "/var/log/app.log"}
+
+	encoded := encodeAzureMetadata(metadata)
+	if _, ok := encoded["source_host"]; !ok {
+		t.Errorf("encodeAzureMetadata() = %v, want source_host", encoded)
+	}
+
+	// Azure returns names as canonical HTTP headers
+	returned := map[string]*string{
+		"Source_host":   to.Ptr("web01"),
+		"Original_path": to.Ptr("/var/log/app.log"),
+	}
+	if got := decodeAzureMetadata(returned); !reflect.DeepEqual(got, metadata) {
+		t.Errorf("decodeAzureMetadata() = %v, want %v", got, metadata)
+	}
+}
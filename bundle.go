@@ -0,0 +1,538 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Bundle grouping modes
+const (
+	BundleByDate   = "date"
+	BundleByPrefix = "prefix"
+
+	DefaultBundleCompression = "gzip"
+)
+
+// bundleGroup is a set of files that will be written into a single archive
+type bundleGroup struct {
+	Key    string
+	Prefix string // expanded key prefix the bundle is uploaded under
+	Date   time.Time
+	Files  []string
+}
+
+// bundleMember describes one file stored in a bundle
+type bundleMember struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// bundleManifest is uploaded next to each bundle and lists its members
+type bundleManifest struct {
+	Bundle      string         `json:"bundle"`
+	Host        string         `json:"host"`
+	Created     time.Time      `json:"created"`
+	Compression string         `json:"compression"`
+	Size        int64          `json:"size"`
+	SHA256      string         `json:"sha256"`
+	Members     []bundleMember `json:"members"`
+}
+
+// validateBundleOptions checks the bundle mode and compression settings
+func validateBundleOptions(mode, compression string) error {
+	switch mode {
+	case "", BundleByDate, BundleByPrefix:
+	default:
+		return fmt.Errorf("invalid bundle mode: %s (supported: %s, %s)", mode, BundleByDate, BundleByPrefix)
+	}
+	switch compression {
+	case "none", "gzip", "zstd":
+	default:
+		return fmt.Errorf("invalid bundle compression: %s (supported: none, gzip, zstd)", compression)
+	}
+	return nil
+}
+
+// bundleExtension returns the file extension for the given compression
+func bundleExtension(compression string) string {
+	switch compression {
+	case "gzip":
+		return ".tar.gz"
+	case "zstd":
+		return ".tar.zst"
+	default:
+		return ".tar"
+	}
+}
+
+// groupFilesForBundle groups files by their extracted date, or by the
+// processed prefix when grouping by prefix. Files whose prefixes differ (e.g.
+// through glob placeholders) never share a bundle.
+func groupFilesForBundle(files []string, mode string, objectPrefix func(filePath string) (string, error)) ([]*bundleGroup, error) {
+	groups := make(map[string]*bundleGroup)
+	for _, file := range files {
+		fileDate, err := extractDateFromFilename(filepath.Base(file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract date from filename %s for bundling: %w", file, err)
+		}
+		prefix, err := objectPrefix(file)
+		if err != nil {
+			return nil, err
+		}
+
+		key := fileDate.Format("20060102")
+		if mode == BundleByPrefix {
+			key = prefix
+		}
+
+		group, ok := groups[prefix+"\x00"+key]
+		if !ok {
+			group = &bundleGroup{Key: key, Prefix: prefix, Date: fileDate}
+			groups[prefix+"\x00"+key] = group
+		}
+		// Keep the earliest date so the bundle name is stable
+		if fileDate.Before(group.Date) {
+			group.Date = fileDate
+		}
+		group.Files = append(group.Files, file)
+	}
+
+	result := make([]*bundleGroup, 0, len(groups))
+	for _, group := range groups {
+		sort.Strings(group.Files)
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Prefix != result[j].Prefix {
+			return result[i].Prefix < result[j].Prefix
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// bundleMemberName returns the name a file is stored under inside the archive.
+// Absolute paths are used with the leading slash removed, like tar does, so
+// files with the same base name in different directories do not collide.
+func bundleMemberName(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		filePath = abs
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filePath), "/")
+}
+
+// newCompressWriter wraps w with the requested compression. A non-zero
+// bufSize bounds the memory of zstd, see zstdEncoderOptions.
+func newCompressWriter(w io.Writer, compression string, bufSize int64) (io.WriteCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstdEncoderOptions(bufSize)...)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// newDecompressReader wraps r with the requested decompression
+func newDecompressReader(r io.Reader, compression string, bufSize int64) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		dec, err := zstd.NewReader(r, zstdDecoderOptions(bufSize)...)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// writeBundle streams the given files into a tar archive at archivePath and
+// returns the members written with their checksums
+func writeBundle(archivePath string, files []string, compression string, bufSize int64) ([]bundleMember, error) {
+	out, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle %s: %w", archivePath, err)
+	}
+	defer out.Close()
+
+	cw, err := newCompressWriter(out, compression, bufSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %w", err)
+	}
+	tw := tar.NewWriter(cw)
+
+	members := make([]bundleMember, 0, len(files))
+	for _, file := range files {
+		member, err := addBundleMember(tw, file)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize tar archive: %w", err)
+	}
+	if err := cw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize compression: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close bundle %s: %w", archivePath, err)
+	}
+	return members, nil
+}
+
+// addBundleMember appends a single file to the tar writer
+func addBundleMember(tw *tar.Writer, filePath string) (bundleMember, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return bundleMember{}, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return bundleMember{}, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return bundleMember{}, fmt.Errorf("failed to build tar header for %s: %w", filePath, err)
+	}
+	header.Name = bundleMemberName(filePath)
+	if err := tw.WriteHeader(header); err != nil {
+		return bundleMember{}, fmt.Errorf("failed to write tar header for %s: %w", filePath, err)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(tw, io.TeeReader(file, hash))
+	if err != nil {
+		return bundleMember{}, fmt.Errorf("failed to add %s to bundle: %w", filePath, err)
+	}
+	if written != info.Size() {
+		return bundleMember{}, fmt.Errorf("file %s changed size while bundling (%d != %d)", filePath, written, info.Size())
+	}
+
+	return bundleMember{
+		Name:    header.Name,
+		Path:    filePath,
+		Size:    written,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		ModTime: info.ModTime().UTC(),
+	}, nil
+}
+
+// verifyBundle re-reads the archive and checks every member against the
+// checksums recorded while writing it
+func verifyBundle(archivePath string, members []bundleMember, compression string, bufSize int64) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	dr, err := newDecompressReader(file, compression, bufSize)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle %s: %w", archivePath, err)
+	}
+	defer dr.Close()
+
+	expected := make(map[string]bundleMember, len(members))
+	for _, member := range members {
+		expected[member.Name] = member
+	}
+
+	tr := tar.NewReader(dr)
+	seen := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bundle %s: %w", archivePath, err)
+		}
+
+		member, ok := expected[header.Name]
+		if !ok {
+			return fmt.Errorf("unexpected member %s in bundle", header.Name)
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, tr)
+		if err != nil {
+			return fmt.Errorf("failed to read member %s: %w", header.Name, err)
+		}
+		if size != member.Size || hex.EncodeToString(hash.Sum(nil)) != member.SHA256 {
+			return fmt.Errorf("checksum mismatch for member %s", header.Name)
+		}
+		seen++
+	}
+
+	if seen != len(members) {
+		return fmt.Errorf("bundle contains %d members, expected %d", seen, len(members))
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 checksum and size of a file
+func fileSHA256(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// bundleName returns the archive file name for a group. A seq above 1 is
+// appended for later bundles of the same group, see bundleKey.
+func bundleName(group *bundleGroup, hostname, compression string, seq int) string {
+	name := fmt.Sprintf("%s-%s", hostname, group.Date.Format("20060102"))
+	if group.Key != group.Date.Format("20060102") {
+		// Prefix groups can span several days; add a short digest of the
+		// group key so different prefixes never share a name
+		sum := sha256.Sum256([]byte(group.Key))
+		name = fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:4]))
+	}
+	if seq > 1 {
+		name = fmt.Sprintf("%s-%d", name, seq)
+	}
+	return name + bundleExtension(compression)
+}
+
+// maxBundleSeq bounds the search for a free bundle name
+const maxBundleSeq = 1000
+
+// bundleKey returns the name and key of the group's bundle. A later run can
+// find more files for the same group, e.g. files that were skipped or
+// arrived late; their bundle gets the next free sequence number, since
+// overwriting the earlier bundle would lose members already deleted locally.
+func (bt *BackupTool) bundleKey(ctx context.Context, group *bundleGroup, hostname string) (string, string, error) {
+	for seq := 1; seq <= maxBundleSeq; seq++ {
+		name := bundleName(group, hostname, bt.config.BundleCompression, seq)
+		key := fmt.Sprintf("%s/%s", group.Prefix, name)
+		if bt.config.DryRun {
+			return name, key, nil
+		}
+		_, err := bt.store.Head(ctx, key)
+		if errors.Is(err, errObjectNotFound) {
+			return name, key, nil
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to check for an existing bundle %s: %w", key, err)
+		}
+		bt.logger.Debug("Bundle exists, trying the next name", LogFieldKey, bt.objectURL(key))
+	}
+	return "", "", fmt.Errorf("no free bundle name for group %s", group.Key)
+}
+
+// processBundles writes each group of files into a bundle, uploads the bundle
+// and its manifest, and deletes the local files once the bundle is verified
+func (bt *BackupTool) processBundles(ctx context.Context, files []string) error {
+	groups, err := groupFilesForBundle(files, bt.config.Bundle, bt.objectPrefix)
+	if err != nil {
+		return err
+	}
+
+	bt.logger.Info("Bundling files", "count", len(files), "bundles", len(groups))
+	for _, group := range groups {
+		if group.Files = bt.filterVetoedFiles(ctx, group.Files); len(group.Files) == 0 {
+			continue
+		}
+		bundleCtx, span := tracer().Start(ctx, "backup.bundle", trace.WithAttributes(
+			attribute.String("backup.group", group.Key),
+			attribute.Int(TraceAttrCount, len(group.Files)),
+			attribute.String(TraceAttrStorageClass, bt.config.StorageClass),
+		))
+		err := bt.processBundle(bundleCtx, group)
+		endSpan(span, err)
+		if err != nil {
+			bt.logger.Error("Bundle failed", "group", group.Key, errAttr(err))
+			bt.stats.Errors++
+			for _, file := range group.Files {
+				result := bt.newFileResult(ctx, file)
+				result.fail(err)
+				bt.afterUpload(ctx, result, err)
+			}
+		}
+	}
+	return nil
+}
+
+// processBundle handles a single bundle group
+func (bt *BackupTool) processBundle(ctx context.Context, group *bundleGroup) error {
+	hostname, _ := os.Hostname()
+	name, s3Key, err := bt.bundleKey(ctx, group, hostname)
+	if err != nil {
+		return err
+	}
+	manifestKey := s3Key + ".manifest.json"
+
+	bt.logger.Info("Bundling", "count", len(group.Files), LogFieldKey, bt.objectURL(s3Key))
+	if bt.config.DryRun {
+		for _, file := range group.Files {
+			bt.logger.Info("DRY RUN: Would bundle", LogFieldFile, file, "bundle", name)
+			result := bt.newFileResult(ctx, file)
+			result.Key = s3Key
+			result.Member = bundleMemberName(file)
+			result.Outcome = OutcomeDryRun
+		}
+		bt.logger.Info("DRY RUN: Would upload bundle", LogFieldKey, bt.objectURL(s3Key))
+		return nil
+	}
+
+	if err := bt.waitForUploadWindow(ctx); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "backup-log-to-s3-*"+bundleExtension(bt.config.BundleCompression))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary bundle: %w", err)
+	}
+	archivePath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(archivePath)
+
+	members, err := writeBundle(archivePath, group.Files, bt.config.BundleCompression, bufferSize(bt.config))
+	if err != nil {
+		return err
+	}
+	if err := verifyBundle(archivePath, members, bt.config.BundleCompression, bufferSize(bt.config)); err != nil {
+		return fmt.Errorf("bundle verification failed: %w", err)
+	}
+
+	checksum, size, err := fileSHA256(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum bundle: %w", err)
+	}
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle: %w", err)
+	}
+	_, err = bt.putObject(ctx, s3Key, archive, name)
+	archive.Close()
+	if err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{
+		Bundle:      s3Key,
+		Host:        hostname,
+		Created:     time.Now().UTC(),
+		Compression: bt.config.BundleCompression,
+		Size:        size,
+		SHA256:      checksum,
+		Members:     members,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := bt.putObjectWithClass(ctx, manifestKey, strings.NewReader(string(data)), name, "STANDARD"); err != nil {
+		return fmt.Errorf("failed to upload bundle manifest: %w", err)
+	}
+
+	// Confirm the object S3 stored matches what we wrote before touching local files
+	head, err := bt.store.Head(ctx, s3Key)
+	if err != nil {
+		return fmt.Errorf("failed to verify uploaded bundle: %w", err)
+	}
+	if head.Size != size {
+		return fmt.Errorf("uploaded bundle size mismatch: local %d, remote %d", size, head.Size)
+	}
+
+	bt.logger.Info("Bundle upload successful", LogFieldKey, bt.objectURL(s3Key), "count", len(members), LogFieldBytes, size)
+	bt.stats.Uploaded += len(members)
+	bt.metrics.recordUpload(1, size)
+
+	results := make([]*fileResult, 0, len(members))
+	for _, member := range members {
+		result := &fileResult{
+			Path:    member.Path,
+			Key:     s3Key,
+			Member:  member.Name,
+			Size:    member.Size,
+			SHA256:  member.SHA256,
+			ModTime: member.ModTime,
+			Outcome: OutcomeUploaded,
+			ETag:    head.ETag,
+		}
+		bt.results = append(bt.results, result)
+		results = append(results, result)
+	}
+
+	for _, result := range results {
+		if !bt.afterUpload(ctx, result, nil) || !bt.config.DeleteAfterUpload {
+			continue
+		}
+		if err := bt.deleteArchivedFile(ctx, result); err != nil {
+			if errors.Is(err, errHookVeto) {
+				continue
+			}
+			bt.logger.Error("Delete failed", LogFieldFile, result.Path, errAttr(err))
+			bt.stats.Errors++
+			result.setError(err)
+			continue
+		}
+		bt.stats.Deleted++
+		result.Deleted = true
+	}
+	return nil
+}
+
+// filterVetoedFiles runs the pre-upload hook for each file of a bundle and
+// returns the files that may be bundled. The key of a bundle is not known
+// yet, so BACKUP_KEY is empty.
+func (bt *BackupTool) filterVetoedFiles(ctx context.Context, files []string) []string {
+	if bt.config.PreUploadHook == "" {
+		return files
+	}
+	var allowed []string
+	for _, file := range files {
+		result := &fileResult{Path: file}
+		if info, err := os.Stat(file); err == nil {
+			result.Size = info.Size()
+			result.ModTime = info.ModTime().UTC()
+		}
+		if bt.beforeUpload(ctx, result) {
+			allowed = append(allowed, file)
+			continue
+		}
+		bt.results = append(bt.results, result)
+	}
+	return allowed
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGroupFilesForBundle tests grouping files by date and by prefix
+func TestGroupFilesForBundle(t *testing.T) {
+	files := []string{
+		"/var/log/app/app-20241215-0001.log",
+		"/var/log/app/app-20241215-0002.log",
+		"/var/log/app/app-20241216-0001.log",
+		"/var/log/app/app-20241101-0001.log",
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		prefix     string
+		wantGroups map[string]int
+	}{
+		{
+			name:       "Group by date",
+			mode:       BundleByDate,
+			prefix:     "logs",
+			wantGroups: map[string]int{"20241101": 1, "20241215": 2, "20241216": 1},
+		},
+		{
+			name:       "Group by monthly prefix",
+			mode:       BundleByPrefix,
+			prefix:     "logs/YYYY/MM",
+			wantGroups: map[string]int{"logs/2024/11": 1, "logs/2024/12": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bt := &BackupTool{config: Config{S3Prefix: tt.prefix}}
+			groups, err := groupFilesForBundle(files, tt.mode, bt.objectPrefix)
+			if err != nil {
+				t.Fatalf("groupFilesForBundle() error = %v", err)
+			}
+			if len(groups) != len(tt.wantGroups) {
+				t.Fatalf("got %d groups, want %d", len(groups), len(tt.wantGroups))
+			}
+			for _, group := range groups {
+				if want, ok := tt.wantGroups[group.Key]; !ok || len(group.Files) != want {
+					t.Errorf("group %s has %d files, want %d", group.Key, len(group.Files), want)
+				}
+			}
+		})
+	}
+
+	// Files routed to different prefixes by placeholders never share a bundle
+	captures, err := compileGlobCaptures("/var/log/{service}/{service}-YYYYMMDD-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &BackupTool{config: Config{S3Prefix: "logs/{service}"}, captures: captures}
+	groups, err := groupFilesForBundle([]string{
+		"/var/log/api/api-20241215-0001.log",
+		"/var/log/web/web-20241215-0001.log",
+		"/var/log/web/web-20241215-0002.log",
+	}, BundleByDate, bt.objectPrefix)
+	if err != nil {
+		t.Fatalf("groupFilesForBundle() error = %v", err)
+	}
+	if len(groups) != 2 || groups[0].Prefix != "logs/api" || groups[1].Prefix != "logs/web" || len(groups[1].Files) != 2 {
+		t.Errorf("Expected one bundle per service, got %+v", groups)
+	}
+
+	bt = &BackupTool{config: Config{S3Prefix: "logs"}}
+	if _, err := groupFilesForBundle([]string{"/var/log/nodate.log"}, BundleByDate, bt.objectPrefix); err == nil {
+		t.Error("groupFilesForBundle() should fail for files without a date")
+	}
+}
+
+// TestWriteAndVerifyBundle tests that bundles round-trip for every compression
+func TestWriteAndVerifyBundle(t *testing.T) {
+	tempDir := t.TempDir()
+	var files []string
+	for _, name := range []string{"app-20241215-0001.log", "app-20241215-0002.log"} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, []byte("log line for "+name), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		files = append(files, path)
+	}
+
+	for _, compression := range []string{"none", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			archivePath := filepath.Join(tempDir, "bundle"+bundleExtension(compression))
+			members, err := writeBundle(archivePath, files, compression, 0)
+			if err != nil {
+				t.Fatalf("writeBundle() error = %v", err)
+			}
+			if len(members) != len(files) {
+				t.Fatalf("got %d members, want %d", len(members), len(files))
+			}
+			for _, member := range members {
+				if strings.HasPrefix(member.Name, "/") {
+					t.Errorf("member name %s should not be absolute", member.Name)
+				}
+				if len(member.SHA256) != 64 {
+					t.Errorf("member %s has invalid checksum %q", member.Name, member.SHA256)
+				}
+			}
+
+			if err := verifyBundle(archivePath, members, compression, 0); err != nil {
+				t.Errorf("verifyBundle() error = %v", err)
+			}
+
+			members[0].SHA256 = strings.Repeat("0", 64)
+			if err := verifyBundle(archivePath, members, compression, 0); err == nil {
+				t.Error("verifyBundle() should fail on checksum mismatch")
+			}
+		})
+	}
+}
+
+// TestValidateBundleOptions tests bundle option validation
+func TestValidateBundleOptions(t *testing.T) {
+	tests := []struct {
+		mode        string
+		compression string
+		wantErr     bool
+	}{
+		{"", DefaultBundleCompression, false},
+		{BundleByDate, "zstd", false},
+		{BundleByPrefix, "none", false},
+		{"hourly", "gzip", true},
+		{BundleByDate, "bzip2", true},
+	}
+
+	for _, tt := range tests {
+		err := validateBundleOptions(tt.mode, tt.compression)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateBundleOptions(%q, %q) error = %v, wantErr %v", tt.mode, tt.compression, err, tt.wantErr)
+		}
+	}
+}
+
+// TestProcessBundlesDryRun tests that dry run bundling leaves files in place
+func TestProcessBundlesDryRun(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "app-20241215.log")
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	bt := &BackupTool{
+		config: Config{
+			S3Bucket:          "test-bucket",
+			S3Prefix:          "logs/YYYY/MM",
+			DryRun:            true,
+			DeleteAfterUpload: true,
+			Bundle:            BundleByDate,
+			BundleCompression: "gzip",
+		},
+		logger:     slog.New(slog.NewTextHandler(&buf, nil)),
+		cutoffTime: time.Now(),
+	}
+
+	if err := bt.processFiles(context.Background(), []string{path}); err != nil {
+		t.Fatalf("processFiles() error = %v", err)
+	}
+	if bt.stats.Errors != 0 {
+		t.Errorf("Expected no errors, got %d. Output: %s", bt.stats.Errors, buf.String())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Dry run should not delete local files")
+	}
+	if !strings.Contains(buf.String(), "s3://test-bucket/logs/2024/12/") {
+		t.Errorf("Expected bundle key under dated prefix, got: %s", buf.String())
+	}
+}
+
+// TestBundleNotOverwritten tests that a second run bundling files of the
+// same date uploads a new bundle instead of replacing the first one, whose
+// members are already deleted locally
+func TestBundleNotOverwritten(t *testing.T) {
+	sourceDir := t.TempDir()
+	archiveDir := t.TempDir()
+	config := localTestConfig(t, Config{
+		LocalDir:          archiveDir,
+		DeleteAfterUpload: true,
+		Bundle:            BundleByDate,
+		BundleCompression: "gzip",
+	})
+	pattern := filepath.Join(sourceDir, "app-YYYYMMDD-*.log")
+
+	for _, name := range []string{"app-20241215-1.log", "app-20241215-2.log"} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		bt, err := NewBackupTool(config)
+		if err != nil {
+			t.Fatalf("NewBackupTool() error = %v", err)
+		}
+		if err := bt.Run(context.Background(), pattern); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if bt.stats.Uploaded != 1 || bt.stats.Deleted != 1 {
+			t.Fatalf("Stats = %+v, want the new file bundled and deleted", bt.stats)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	bundles := []string{
+		filepath.Join(archiveDir, "logs", hostname+"-20241215.tar.gz"),
+		filepath.Join(archiveDir, "logs", hostname+"-20241215-2.tar.gz"),
+	}
+	for i, bundle := range bundles {
+		members, err := readBundleMembers(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("app-20241215-%d.log", i+1); len(members) != 1 || filepath.Base(members[0]) != want {
+			t.Errorf("Bundle %s contains %v, want %s", bundle, members, want)
+		}
+	}
+}
+
+// readBundleMembers returns the member names of a gzip bundle
+func readBundleMembers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dr, err := newDecompressReader(file, "gzip", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	var names []string
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, header.Name)
+	}
+}
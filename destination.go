@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// destinationSchemes maps destination URL schemes to storage backends
+var destinationSchemes = map[string]string{
+	"s3":     BackendS3,
+	"gs":     BackendGCS,
+	"azblob": BackendAzure,
+	"file":   BackendLocal,
+	"sftp":   BackendSFTP,
+}
+
+// destinationParam is a query parameter accepted in a destination URL
+type destinationParam struct {
+	field    func(*Config) *string
+	backends []string
+}
+
+// destinationParams are the query parameters of destination URLs. They take
+// precedence over the equivalent flags.
+var destinationParams = map[string]destinationParam{
+	"endpoint":      {func(c *Config) *string { return &c.EndpointURL }, []string{BackendS3, BackendGCS, BackendAzure}},
+	"storage_class": {func(c *Config) *string { return &c.StorageClass }, []string{BackendS3, BackendGCS, BackendAzure}},
+	"region":        {func(c *Config) *string { return &c.AWSRegion }, []string{BackendS3}},
+	"profile":       {func(c *Config) *string { return &c.Profile }, []string{BackendS3}},
+	"credentials":   {func(c *Config) *string { return &c.GCSCredentials }, []string{BackendGCS}},
+	"account":       {func(c *Config) *string { return &c.AzureAccount }, []string{BackendAzure}},
+	"key":           {func(c *Config) *string { return &c.SFTPKey }, []string{BackendSFTP}},
+	"known_hosts":   {func(c *Config) *string { return &c.SFTPKnownHosts }, []string{BackendSFTP}},
+	"bwlimit":       {func(c *Config) *string { return &c.DestinationBandwidth }, []string{BackendS3, BackendGCS, BackendAzure, BackendLocal, BackendSFTP}},
+}
+
+// goTemplateVariable matches a Go template style variable such as {{.Year}}
+var goTemplateVariable = regexp.MustCompile(`\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// isDestinationURL reports whether a command line argument is a destination
+// URL rather than a period or glob pattern
+func isDestinationURL(arg string) bool {
+	scheme, _, ok := strings.Cut(arg, "://")
+	_, known := destinationSchemes[scheme]
+	return ok && known
+}
+
+// convertGoTemplate rewrites {{.Year}} style variables to the {year} syntax
+// of key templates. Standard variables are matched case-insensitively; other
+// names (glob placeholders) are kept as written.
+func convertGoTemplate(s string) string {
+	return goTemplateVariable.ReplaceAllStringFunc(s, func(match string) string {
+		name := goTemplateVariable.FindStringSubmatch(match)[1]
+		for _, known := range templateVariables {
+			if strings.EqualFold(name, known) {
+				name = known
+				break
+			}
+		}
+		return "{" + name + "}"
+	})
+}
+
+// applyDestination sets the backend, bucket, prefix and connection options
+// from the -dest URL, e.g. s3://my-logs/logs/{year}/{month}?region=ap-northeast-1.
+// The flags it replaces must not be set as well.
+func applyDestination(config *Config) error {
+	if config.Destination == "" {
+		return nil
+	}
+	if config.S3Bucket != "" || config.S3Prefix != "" || config.LocalDir != "" || config.SFTPHost != "" || config.SFTPDir != "" || !isS3Backend(*config) {
+		return fmt.Errorf("-dest cannot be combined with -bucket, -prefix, -backend, -local-dir, -sftp-host or -sftp-dir")
+	}
+
+	u, err := url.Parse(config.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid destination '%s': %w", config.Destination, err)
+	}
+	backend, ok := destinationSchemes[u.Scheme]
+	if !ok {
+		return fmt.Errorf("invalid destination '%s': unsupported scheme (supported: s3, gs, azblob, file, sftp)", config.Destination)
+	}
+	config.Backend = backend
+
+	keyPath := convertGoTemplate(strings.Trim(u.Path, "/"))
+	switch backend {
+	case BackendLocal, BackendSFTP:
+		// Keys are relative to the directory, so where the path is split
+		// does not change the resulting file paths. The last segment before
+		// the first variable starts the prefix, which must not be empty.
+		if keyPath == "" {
+			return fmt.Errorf("invalid destination '%s': a path is required", config.Destination)
+		}
+		dir, prefix, err := splitDestinationPath(keyPath)
+		if err != nil {
+			return fmt.Errorf("invalid destination '%s': %w", config.Destination, err)
+		}
+		if backend == BackendLocal {
+			if u.Host != "" && u.Host != "localhost" {
+				return fmt.Errorf("invalid destination '%s': file URLs cannot name a remote host", config.Destination)
+			}
+			config.LocalDir = dir
+		} else {
+			if u.Host == "" {
+				return fmt.Errorf("invalid destination '%s': a host is required", config.Destination)
+			}
+			if _, hasPassword := u.User.Password(); hasPassword {
+				return fmt.Errorf("invalid destination '%s': use SFTP_PASSWORD instead of a password in the URL", config.Destination)
+			}
+			config.SFTPHost = u.Host
+			config.SFTPUser = u.User.Username()
+			config.SFTPDir = dir
+		}
+		config.S3Prefix = prefix
+	default:
+		if u.Host == "" {
+			return fmt.Errorf("invalid destination '%s': a bucket is required", config.Destination)
+		}
+		if keyPath == "" {
+			return fmt.Errorf("invalid destination '%s': a key prefix is required (e.g. %s://%s/logs)", config.Destination, u.Scheme, u.Host)
+		}
+		config.S3Bucket = u.Host
+		config.S3Prefix = keyPath
+	}
+
+	names := make([]string, 0, len(u.Query()))
+	for name := range u.Query() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		param, ok := destinationParams[name]
+		if !ok || !slices.Contains(param.backends, backend) {
+			return fmt.Errorf("invalid destination '%s': unsupported parameter '%s' for %s", config.Destination, name, u.Scheme)
+		}
+		*param.field(config) = u.Query().Get(name)
+	}
+	return nil
+}
+
+// splitDestinationPath splits a file or sftp path into the archive directory
+// and the key prefix, which starts at the last segment before the first one
+// containing a variable
+func splitDestinationPath(p string) (string, string, error) {
+	segments := strings.Split(p, "/")
+	first := len(segments)
+	for i, segment := range segments {
+		t, err := parsePrefixTemplate(segment)
+		if err != nil {
+			return "", "", err
+		}
+		if len(t.variables()) > 0 {
+			first = i
+			break
+		}
+	}
+	if first > 0 {
+		first--
+	}
+	return "/" + path.Join(segments[:first]...), strings.Join(segments[first:], "/"), nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dirFS is the file system a directory store writes to. Names are
+// "/"-separated.
+type dirFS interface {
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	MkdirAll(name string) error
+}
+
+// objectSidecar holds what a directory store keeps next to each object that
+// a plain file cannot carry: metadata, tags and the content checksum
+type objectSidecar struct {
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	Tagging        string            `json:"tagging,omitempty"`
+	ChecksumSHA256 string            `json:"checksum_sha256,omitempty"`
+}
+
+// dirStore is an ObjectStore that keeps objects as files below a root
+// directory, with keys mapped to relative paths. The metadata of each object
+// is kept in a hidden sidecar file ".<name>.meta.json" in the same directory.
+type dirStore struct {
+	fs   dirFS
+	root string
+}
+
+// sidecarName returns the sidecar path for an object path
+func sidecarName(name string) string {
+	return path.Join(path.Dir(name), "."+path.Base(name)+".meta.json")
+}
+
+// objectPath returns the path of a key, rejecting keys that would escape the
+// root directory
+func (s *dirStore) objectPath(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || strings.HasPrefix(path.Base(clean), ".") {
+		return "", fmt.Errorf("invalid key for a directory store: %s", key)
+	}
+	return path.Join(s.root, clean), nil
+}
+
+func (s *dirStore) Check(ctx context.Context) error {
+	info, err := s.fs.Stat(s.root)
+	if err != nil {
+		return fmt.Errorf("cannot access archive directory %s: %w", s.root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("archive directory %s is not a directory", s.root)
+	}
+	return nil
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// readers never see a partial object. Directories have no ETags.
+func (s *dirStore) Put(ctx context.Context, req *PutRequest) (string, error) {
+	name, err := s.objectPath(req.Key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.fs.MkdirAll(path.Dir(name)); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", req.Key, err)
+	}
+
+	tmp := path.Join(path.Dir(name), fmt.Sprintf(".%s.%d.tmp", path.Base(name), time.Now().UnixNano()))
+	file, err := s.fs.Create(tmp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	sha := sha256.New()
+	_, err = io.Copy(file, io.TeeReader(req.Body, sha))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.fs.Remove(tmp)
+		return "", fmt.Errorf("failed to write %s: %w", req.Key, err)
+	}
+
+	sidecar := objectSidecar{
+		Metadata:       req.Metadata,
+		Tagging:        req.Tagging,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(sha.Sum(nil)),
+	}
+	if err := s.writeSidecar(name, sidecar); err != nil {
+		s.fs.Remove(tmp)
+		return "", err
+	}
+	if err := s.fs.Rename(tmp, name); err != nil {
+		s.fs.Remove(tmp)
+		return "", fmt.Errorf("failed to move %s into place: %w", req.Key, err)
+	}
+	return "", nil
+}
+
+// writeSidecar writes the sidecar of an object
+func (s *dirStore) writeSidecar(name string, sidecar objectSidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	file, err := s.fs.Create(sidecarName(name))
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// readSidecar reads the sidecar of an object; objects copied into the
+// directory by other means have none
+func (s *dirStore) readSidecar(name string) objectSidecar {
+	var sidecar objectSidecar
+	file, err := s.fs.Open(sidecarName(name))
+	if err != nil {
+		return sidecar
+	}
+	defer file.Close()
+	json.NewDecoder(file).Decode(&sidecar)
+	return sidecar
+}
+
+func (s *dirStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	name, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.fs.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errObjectNotFound
+	}
+
+	sidecar := s.readSidecar(name)
+	return &ObjectInfo{
+		Key:            key,
+		Size:           info.Size(),
+		LastModified:   info.ModTime().UTC(),
+		Metadata:       sidecar.Metadata,
+		ChecksumSHA256: sidecar.ChecksumSHA256,
+	}, nil
+}
+
+func (s *dirStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, _ := s.objectPath(key)
+	file, err := s.fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// List walks the directory holding the prefix in key order, skipping hidden
+// files such as sidecars and unfinished uploads
+func (s *dirStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+	return s.walk(ctx, dir, prefix, fn)
+}
+
+// walk lists the objects below dir (relative to the root) whose key starts
+// with prefix
+func (s *dirStore) walk(ctx context.Context, dir, prefix string, fn func(ObjectInfo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := s.fs.ReadDir(path.Join(s.root, dir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		key := entry.Name()
+		if dir != "" {
+			key = dir + "/" + key
+		}
+		if entry.IsDir() {
+			// Descend only into directories that can hold matching keys
+			if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+				if err := s.walk(ctx, key, prefix, fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := fn(ObjectInfo{Key: key, Size: entry.Size(), LastModified: entry.ModTime().UTC()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *dirStore) Delete(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+	for _, key := range keys {
+		name, err := s.objectPath(key)
+		if err == nil {
+			err = s.fs.Remove(name)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			failed[key] = err
+			continue
+		}
+		s.fs.Remove(sidecarName(name))
+	}
+	return failed, nil
+}
+
+// localFS is the dirFS of the local file system, including NFS mounts
+type localFS struct{}
+
+func (localFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.FromSlash(name))
+}
+
+func (localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(name))
+}
+
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.FromSlash(name))
+}
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Rename(oldname, newname string) error {
+	return os.Rename(filepath.FromSlash(oldname), filepath.FromSlash(newname))
+}
+
+func (localFS) Remove(name string) error {
+	return os.Remove(filepath.FromSlash(name))
+}
+
+func (localFS) MkdirAll(name string) error {
+	return os.MkdirAll(filepath.FromSlash(name), 0755)
+}
+
+// newLocalStore returns a store writing below a local or NFS-mounted directory
+func newLocalStore(dir string) *dirStore {
+	return &dirStore{fs: localFS{}, root: filepath.ToSlash(filepath.Clean(dir))}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"google.golang.org/api/googleapi"
+)
+
+// Exit codes. Schedulers and orchestration use them to decide whether to
+// retry, alert or fix the configuration, so they must stay stable.
+const (
+	// ExitOK means every file was processed successfully
+	ExitOK = 0
+	// ExitFailure means the run failed and no file was archived
+	ExitFailure = 1
+	// ExitConfig means the command line or configuration is invalid
+	ExitConfig = 2
+	// ExitLocked means another instance holds the lock file
+	ExitLocked = 3
+	// ExitAuth means the storage backend rejected the credentials
+	ExitAuth = 4
+	// ExitPartial means some files were archived and others failed
+	ExitPartial = 5
+)
+
+// Error classes recorded in the run report
+const (
+	ErrorClassAuth      = "auth"
+	ErrorClassNotFound  = "not_found"
+	ErrorClassThrottled = "throttled"
+	ErrorClassNetwork   = "network"
+	ErrorClassLocalIO   = "local_io"
+	ErrorClassStorage   = "storage"
+	ErrorClassOther     = "other"
+)
+
+// errLocked is returned when another instance holds the lock file
+var errLocked = errors.New("another instance is already running")
+
+// authErrorCodes are the S3 error codes for rejected credentials
+var authErrorCodes = []string{
+	"AccessDenied", "AllAccessDisabled", "ExpiredToken", "InvalidAccessKeyId",
+	"InvalidToken", "SignatureDoesNotMatch", "TokenRefreshRequired",
+}
+
+// throttleErrorCodes are the S3 error codes for throttled requests
+var throttleErrorCodes = []string{"SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException"}
+
+// authErrorMessages identify credential errors that have no error code,
+// such as missing AWS or Google credentials and SSH authentication failures
+var authErrorMessages = []string{
+	"failed to refresh cached credentials",
+	"could not find default credentials",
+	"ssh: unable to authenticate",
+}
+
+// configError marks an error in the command line or configuration
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+// runFailure is returned by Run when files could not be archived
+type runFailure struct {
+	Errors int
+	// Partial is set when other files were archived despite the errors
+	Partial bool
+	// Class is the error class shared by every failed file, if any
+	Class string
+}
+
+func (e *runFailure) Error() string {
+	return fmt.Sprintf("backup completed with %d errors", e.Errors)
+}
+
+// exitCode maps the error returned by a run to the process exit code
+func exitCode(err error) int {
+	var cfgErr *configError
+	var failure *runFailure
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errLocked):
+		return ExitLocked
+	case errors.As(err, &cfgErr):
+		return ExitConfig
+	case errors.As(err, &failure):
+		if failure.Partial {
+			return ExitPartial
+		}
+		if failure.Class == ErrorClassAuth {
+			return ExitAuth
+		}
+		return ExitFailure
+	case classifyError(err) == ErrorClassAuth:
+		return ExitAuth
+	}
+	return ExitFailure
+}
+
+// classifyError returns the class of an upload or storage error
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		if slices.Contains(authErrorCodes, apiErr.ErrorCode()) {
+			return ErrorClassAuth
+		}
+		if slices.Contains(throttleErrorCodes, apiErr.ErrorCode()) {
+			return ErrorClassThrottled
+		}
+	}
+
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return ErrorClassAuth
+	}
+	message := err.Error()
+	for _, m := range authErrorMessages {
+		if strings.Contains(message, m) {
+			return ErrorClassAuth
+		}
+	}
+
+	if status := httpStatus(err); status != 0 {
+		switch {
+		case status == 401 || status == 403:
+			return ErrorClassAuth
+		case status == 404:
+			return ErrorClassNotFound
+		case status == 429 || status == 503:
+			return ErrorClassThrottled
+		}
+		return ErrorClassStorage
+	}
+
+	// File errors come first: the errno they wrap also satisfies net.Error
+	var pathErr *fs.PathError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errObjectNotFound):
+		return ErrorClassNotFound
+	case errors.As(err, &pathErr), errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission):
+		return ErrorClassLocalIO
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return ErrorClassNetwork
+	}
+	return ErrorClassOther
+}
+
+// httpStatus returns the HTTP status code of an S3, GCS or Azure error, or
+// 0 if the error did not come from an HTTP response
+func httpStatus(err error) int {
+	var s3Err interface{ HTTPStatusCode() int }
+	if errors.As(err, &s3Err) {
+		return s3Err.HTTPStatusCode()
+	}
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.Code
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		return azureErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeAPIError mimics the error codes of the AWS SDK
+type fakeAPIError struct{ code string }
+
+func (e *fakeAPIError) Error() string     { return "api error " + e.code }
+func (e *fakeAPIError) ErrorCode() string { return e.code }
+
+// TestClassifyError tests the error classes of the backends and local I/O
+func TestClassifyError(t *testing.T) {
+	_, openErr := os.Open("/nonexistent/app.log")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"S3 access denied", fmt.Errorf("failed to upload to S3: %w", &fakeAPIError{"AccessDenied"}), ErrorClassAuth},
+		{"S3 slow down", &fakeAPIError{"SlowDown"}, ErrorClassThrottled},
+		{"missing credentials", errors.New("get identity: get credentials: failed to refresh cached credentials, no EC2 IMDS role found"), ErrorClassAuth},
+		{"SSH authentication", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"), ErrorClassAuth},
+		{"GCS forbidden", &googleapi.Error{Code: 403}, ErrorClassAuth},
+		{"GCS server error", &googleapi.Error{Code: 500}, ErrorClassStorage},
+		{"Azure not found", &azcore.ResponseError{StatusCode: 404}, ErrorClassNotFound},
+		{"Azure busy", &azcore.ResponseError{StatusCode: 503}, ErrorClassThrottled},
+		{"network", fmt.Errorf("failed to upload: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), ErrorClassNetwork},
+		{"timeout", fmt.Errorf("failed to upload: %w", context.DeadlineExceeded), ErrorClassNetwork},
+		{"local file", fmt.Errorf("failed to open file: %w", openErr), ErrorClassLocalIO},
+		{"other", errors.New("bundle verification failed"), ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExitCode tests the exit code of each kind of failure
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"config", &configError{errors.New("invalid period")}, ExitConfig},
+		{"locked", fmt.Errorf("%w (lock file exists: /tmp/x.lock)", errLocked), ExitLocked},
+		{"auth at startup", fmt.Errorf("cannot access S3 bucket logs: %w", &fakeAPIError{"InvalidAccessKeyId"}), ExitAuth},
+		{"partial", &runFailure{Errors: 1, Partial: true, Class: ErrorClassAuth}, ExitPartial},
+		{"all files rejected", &runFailure{Errors: 3, Class: ErrorClassAuth}, ExitAuth},
+		{"all files failed", &runFailure{Errors: 3, Class: ErrorClassNetwork}, ExitFailure},
+		{"other", errors.New("failed to find files"), ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
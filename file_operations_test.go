@@ -5,7 +5,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
@@ -203,7 +203,7 @@ func TestFindTargetFilesWithRealFS(t *testing.T) {
 	}
 	
 	// Create a simple logger for testing
-	bt.logger = log.New(os.Stdout, "TEST: ", log.LstdFlags)
+	bt.logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 	
 	t.Run("Find YYYYMMDD pattern files", func(t *testing.T) {
 		pattern := filepath.Join(tempDir, "app*YYYYMMDD.log.gz")
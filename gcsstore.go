@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsStorageClasses maps S3 storage classes to the closest GCS class
+var gcsStorageClasses = map[string]string{
+	"STANDARD":            "STANDARD",
+	"REDUCED_REDUNDANCY":  "STANDARD",
+	"INTELLIGENT_TIERING": "STANDARD",
+	"STANDARD_IA":         "NEARLINE",
+	"ONEZONE_IA":          "NEARLINE",
+	"GLACIER_IR":          "COLDLINE",
+	"GLACIER":             "ARCHIVE",
+	"DEEP_ARCHIVE":        "ARCHIVE",
+}
+
+// gcsStorageClass returns the GCS storage class for -storage-class, which
+// may be an S3 class or a native GCS class such as COLDLINE
+func gcsStorageClass(class string) (string, error) {
+	class = strings.ToUpper(class)
+	switch class {
+	case "":
+		return "", nil
+	case "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE":
+		return class, nil
+	}
+	if mapped, ok := gcsStorageClasses[class]; ok {
+		return mapped, nil
+	}
+	return "", fmt.Errorf("unsupported storage class for GCS: %s", class)
+}
+
+// gcsStore is an ObjectStore backed by a Google Cloud Storage bucket
+type gcsStore struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	name   string
+	// chunkSize is the upload buffer from -buffer-size, 0 for the default
+	chunkSize int
+}
+
+// newGCSStore creates a GCS client. Credentials come from -gcs-credentials or
+// Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS, gcloud or
+// the metadata server). The client connects to STORAGE_EMULATOR_HOST when it
+// is set, e.g. for fake-gcs-server.
+func newGCSStore(ctx context.Context, config Config) (*gcsStore, error) {
+	var opts []option.ClientOption
+	if config.GCSCredentials != "" {
+		opts = append(opts, option.WithCredentialsFile(config.GCSCredentials))
+	}
+	if config.EndpointURL != "" {
+		opts = append(opts, option.WithEndpoint(config.EndpointURL))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &gcsStore{client: client, bucket: client.Bucket(config.S3Bucket), name: config.S3Bucket, chunkSize: gcsChunkSize(bufferSize(config))}, nil
+}
+
+func (s *gcsStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *gcsStore) Check(ctx context.Context) error {
+	if _, err := s.bucket.Attrs(ctx); err != nil {
+		return fmt.Errorf("cannot access GCS bucket %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// Put uploads the object, sending the MD5 of seekable bodies so GCS rejects
+// a corrupted upload. GCS has no object tags, so Tagging is not used. The
+// MD5 is returned as the ETag, as in objectInfo.
+func (s *gcsStore) Put(ctx context.Context, req *PutRequest) (string, error) {
+	storageClass, err := gcsStorageClass(req.StorageClass)
+	if err != nil {
+		return "", err
+	}
+	sum, err := contentMD5(req.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// Cancelling the context aborts the upload instead of committing a
+	// partial object
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := s.bucket.Object(req.Key).NewWriter(ctx)
+	w.StorageClass = storageClass
+	w.Metadata = req.Metadata
+	w.MD5 = sum
+	if s.chunkSize > 0 {
+		w.ChunkSize = s.chunkSize
+	}
+	if _, err := io.Copy(w, req.Body); err != nil {
+		cancel()
+		w.Close()
+		return "", fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	return hex.EncodeToString(w.Attrs().MD5), nil
+}
+
+// objectInfo converts GCS object attributes. The MD5 is reported as the
+// ETag, which is what S3 uses for single-part objects, so verify and restore
+// compare against it.
+func (s *gcsStore) objectInfo(attrs *storage.ObjectAttrs) *ObjectInfo {
+	return &ObjectInfo{
+		Key:          attrs.Name,
+		Size:         attrs.Size,
+		LastModified: attrs.Updated,
+		ETag:         hex.EncodeToString(attrs.MD5),
+		StorageClass: attrs.StorageClass,
+		Metadata:     attrs.Metadata,
+	}
+}
+
+func (s *gcsStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	return s.objectInfo(attrs), nil
+}
+
+func (s *gcsStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := s.Head(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := s.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, info, nil
+}
+
+func (s *gcsStore) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(*s.objectInfo(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *gcsStore) Delete(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+	for _, key := range keys {
+		err := s.bucket.Object(key).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			failed[key] = err
+		}
+	}
+	return failed, nil
+}
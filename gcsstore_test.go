@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// TestGCSStorageClass tests mapping S3 storage classes to GCS classes
+func TestGCSStorageClass(t *testing.T) {
+	tests := []struct {
+		class   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"STANDARD", "STANDARD", false},
+		{"STANDARD_IA", "NEARLINE", false},
+		{"GLACIER_IR", "COLDLINE", false},
+		{"DEEP_ARCHIVE", "ARCHIVE", false},
+		{"coldline", "COLDLINE", false},
+		{"EXPRESS_ONEZONE", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := gcsStorageClass(tt.class)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("gcsStorageClass(%q) error = %v, wantErr %v", tt.class, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("gcsStorageClass(%q) = %s, want %s", tt.class, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches a named {placeholder} in globs and templates
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// globDateTokens are the date tokens a glob may contain, longest first
+var globDateTokens = []string{"YYYY/MM/DD", "YYYY-MM-DD", "YYYY_MM_DD", "YYYYMMDD"}
+
+// globCaptures extracts the values of the {name} placeholders of a glob
+// pattern from the paths it matched
+type globCaptures struct {
+	re    *regexp.Regexp
+	names []string // placeholder name of each capture group
+}
+
+// globPlaceholderNames returns the distinct placeholder names in the pattern
+func globPlaceholderNames(pattern string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range placeholderPattern.FindAllStringSubmatch(pattern, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// compileGlobCaptures builds a matcher for the placeholders of the pattern.
+// It returns nil if the pattern has no placeholders.
+func compileGlobCaptures(pattern string) (*globCaptures, error) {
+	if !placeholderPattern.MatchString(pattern) {
+		return nil, nil
+	}
+
+	var expr strings.Builder
+	var names []string
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); {
+		rest := pattern[i:]
+		if loc := placeholderPattern.FindStringSubmatchIndex(rest); loc != nil && loc[0] == 0 {
+			expr.WriteString("([^/]*)")
+			names = append(names, rest[loc[2]:loc[3]])
+			i += loc[1]
+			continue
+		}
+		if token := globDateToken(rest); token != "" {
+			expr.WriteString("[^/]*")
+			i += len(token)
+			continue
+		}
+
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(rest[1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in glob pattern %s", pattern)
+			}
+			class := rest[1 : end+1]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 2
+			continue
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+		i++
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %s: %w", pattern, err)
+	}
+	return &globCaptures{re: re, names: names}, nil
+}
+
+// globDateToken returns the date token s starts with, if any
+func globDateToken(s string) string {
+	for _, token := range globDateTokens {
+		if strings.HasPrefix(s, token) {
+			return token
+		}
+	}
+	return ""
+}
+
+// match returns the placeholder values for path. ok is false if the path does
+// not match or a repeated placeholder matched different values.
+func (g *globCaptures) match(path string) (map[string]string, bool) {
+	m := g.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	values := make(map[string]string, len(g.names))
+	for i, name := range g.names {
+		value := m[i+1]
+		if prev, ok := values[name]; ok && prev != value {
+			return nil, false
+		}
+		values[name] = value
+	}
+	return values, true
+}
+
+// fileCaptures returns the glob placeholder values of a file found by
+// findTargetFiles, or nil if the glob has no placeholders
+func (bt *BackupTool) fileCaptures(path string) map[string]string {
+	if bt.captures == nil {
+		return nil
+	}
+	values, _ := bt.captures.match(path)
+	return values
+}
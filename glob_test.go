@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestGlobCaptures tests extracting placeholder values from matched paths
+func TestGlobCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		want    map[string]string
+		wantOK  bool
+	}{
+		{
+			name:    "Directory and file name",
+			pattern: "/var/log/{service}/{service}-YYYYMMDD.log.gz",
+			path:    "/var/log/nginx/nginx-20241215.log.gz",
+			want:    map[string]string{"service": "nginx"},
+			wantOK:  true,
+		},
+		{
+			name:    "Repeated placeholder mismatch",
+			pattern: "/var/log/{service}/{service}-YYYYMMDD.log.gz",
+			path:    "/var/log/nginx/apache-20241215.log.gz",
+			wantOK:  false,
+		},
+		{
+			name:    "Several placeholders",
+			pattern: "/srv/{env}/{app}/*-YYYY-MM-DD.log",
+			path:    "/srv/prod/api/access-2024-12-15.log",
+			want:    map[string]string{"env": "prod", "app": "api"},
+			wantOK:  true,
+		},
+		{
+			name:    "Character class",
+			pattern: "/logs/{host}/app[0-9]-YYYYMMDD.log",
+			path:    "/logs/web01/app1-20241215.log",
+			want:    map[string]string{"host": "web01"},
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captures, err := compileGlobCaptures(tt.pattern)
+			if err != nil {
+				t.Fatalf("compileGlobCaptures() error = %v", err)
+			}
+			got, ok := captures.match(tt.path)
+			if ok != tt.wantOK {
+				t.Fatalf("match(%s) ok = %v, want %v", tt.path, ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match(%s) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if captures, err := compileGlobCaptures("/var/log/app-YYYYMMDD.log"); err != nil || captures != nil {
+		t.Errorf("Expected nil matcher for pattern without placeholders, got %v (%v)", captures, err)
+	}
+}
+
+// TestObjectKeyWithPlaceholders tests routing files to prefixes by placeholder
+func TestObjectKeyWithPlaceholders(t *testing.T) {
+	captures, err := compileGlobCaptures("/var/log/{service}/{service}-YYYYMMDD.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &BackupTool{config: Config{S3Prefix: "logs/{service}/YYYY/MM"}, captures: captures}
+
+	got, err := bt.objectKey("/var/log/nginx/nginx-20241215.log.gz")
+	if err != nil {
+		t.Fatalf("objectKey() error = %v", err)
+	}
+	if want := "logs/nginx/2024/12/nginx-20241215.log.gz"; got != want {
+		t.Errorf("objectKey() = %s, want %s", got, want)
+	}
+}
+
+// TestValidatePrefixPlaceholders tests that prefix placeholders must be known
+func TestValidatePrefixPlaceholders(t *testing.T) {
+	glob := "/var/log/{service}/{service}-YYYYMMDD.log.gz"
+	tests := []struct {
+		prefix  string
+		wantErr bool
+	}{
+		{"logs/YYYY/MM", false},
+		{"logs/{service}/YYYY/MM", false},
+		{"logs/{host}/{service}", false},
+		{"logs/{team}", true},
+	}
+
+	for _, tt := range tests {
+		err := validatePrefixTemplate(Config{S3Prefix: tt.prefix}, glob)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePrefixTemplate(%s) error = %v, wantErr %v", tt.prefix, err, tt.wantErr)
+		}
+	}
+}
+
+// TestFindTargetFilesWithPlaceholders tests globbing with {name} placeholders
+func TestFindTargetFilesWithPlaceholders(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, file := range []string{
+		"nginx/nginx-20200101.log",
+		"api/api-20200101.log",
+		"api/nginx-20200101.log",
+	} {
+		path := filepath.Join(tempDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("log"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bt, err := NewBackupTool(Config{Period: "1 day"})
+	if err != nil {
+		t.Fatalf("NewBackupTool() error = %v", err)
+	}
+	files, err := bt.findTargetFiles(filepath.Join(tempDir, "{service}", "{service}-YYYYMMDD.log"))
+	if err != nil {
+		t.Fatalf("findTargetFiles() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %v", files)
+	}
+	if got := bt.fileCaptures(files[0])["service"]; got != "api" {
+		t.Errorf("fileCaptures(%s)[service] = %s, want api", files[0], got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook events, exposed to hook commands as BACKUP_HOOK
+const (
+	HookPreRun     = "pre-run"
+	HookPostRun    = "post-run"
+	HookPreUpload  = "pre-upload"
+	HookPostUpload = "post-upload"
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+)
+
+// DefaultHookTimeout is how long a hook command may run
+const DefaultHookTimeout = time.Minute
+
+// hookOutputLimit caps the hook output kept for the log
+const hookOutputLimit = 4096
+
+// errHookVeto is wrapped by the error of a hook that exited non-zero, which
+// vetoes the step it runs before
+var errHookVeto = errors.New("vetoed by hook")
+
+// validateHooks checks the hook options
+func validateHooks(config Config) error {
+	if config.HookTimeout <= 0 {
+		return fmt.Errorf("-hook-timeout must be positive")
+	}
+	return nil
+}
+
+// runHook runs a hook command through the shell with the given environment
+// added. A non-zero exit returns an error wrapping errHookVeto; a timeout or
+// a command that cannot be started returns any other error. Hooks do not
+// run in dry-run mode.
+func (bt *BackupTool) runHook(ctx context.Context, event, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+	if bt.config.DryRun {
+		bt.logger.Info("DRY RUN: Would run hook", "hook", event, "command", command)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, bt.config.HookTimeout)
+	defer cancel()
+
+	var output limitedBuffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	// Later entries win, so env may override earlier ones
+	cmd.Env = append(os.Environ(), "BACKUP_HOOK="+event)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Do not wait for background processes that keep the output open
+	cmd.WaitDelay = time.Second
+
+	started := time.Now()
+	err := cmd.Run()
+	attrs := []any{"hook", event, durationAttr(time.Since(started))}
+	if out := strings.TrimSpace(output.String()); out != "" {
+		attrs = append(attrs, "output", out)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		bt.logger.Debug("Hook completed", attrs...)
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("%s hook timed out after %s", event, bt.config.HookTimeout)
+	case errors.As(err, &exitErr):
+		err = fmt.Errorf("%s hook exited with status %d: %w", event, exitErr.ExitCode(), errHookVeto)
+	default:
+		err = fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	bt.logger.Warn("Hook failed", append(attrs, errAttr(err))...)
+	return err
+}
+
+// fileHookEnv returns the environment of a file hook
+func (bt *BackupTool) fileHookEnv(result *fileResult, status string) []string {
+	env := []string{
+		"BACKUP_JOB=" + bt.config.JobName,
+		"BACKUP_DESTINATION=" + bt.destinationName(),
+		"BACKUP_FILE=" + result.Path,
+		"BACKUP_KEY=" + result.Key,
+		"BACKUP_SIZE=" + strconv.FormatInt(result.Size, 10),
+		"BACKUP_STATUS=" + status,
+		"BACKUP_ERROR=" + redactSecrets(result.Error),
+	}
+	if result.Key != "" {
+		env = append(env, "BACKUP_URL="+bt.objectURL(result.Key))
+	}
+	return env
+}
+
+// runRunHook runs the pre-run or post-run hook. The post-run hook receives
+// the outcome and the summary numbers of the run.
+func (bt *BackupTool) runRunHook(ctx context.Context, event, command, globPattern string, runErr error) error {
+	env := []string{
+		"BACKUP_JOB=" + bt.config.JobName,
+		"BACKUP_DESTINATION=" + bt.destinationName(),
+		"BACKUP_PATTERN=" + globPattern,
+	}
+	if event == HookPostRun {
+		code := exitCode(runErr)
+		env = append(env,
+			"BACKUP_STATUS="+runStatus(code),
+			"BACKUP_EXIT_CODE="+strconv.Itoa(code),
+			"BACKUP_TOTAL_FILES="+strconv.Itoa(bt.stats.TotalFiles),
+			"BACKUP_UPLOADED="+strconv.Itoa(bt.stats.Uploaded),
+			"BACKUP_DELETED="+strconv.Itoa(bt.stats.Deleted),
+			"BACKUP_SKIPPED="+strconv.Itoa(bt.stats.Skipped),
+			"BACKUP_ERRORS="+strconv.Itoa(bt.stats.Errors),
+		)
+		if runErr != nil {
+			env = append(env, "BACKUP_ERROR="+redactSecrets(runErr.Error()))
+		}
+	}
+	return bt.runHook(ctx, event, command, env)
+}
+
+// beforeUpload runs the pre-upload hook and reports whether the file may be
+// uploaded. A vetoed file is skipped; a hook that timed out or could not
+// run fails the file.
+func (bt *BackupTool) beforeUpload(ctx context.Context, result *fileResult) bool {
+	err := bt.runHook(ctx, HookPreUpload, bt.config.PreUploadHook, bt.fileHookEnv(result, "pending"))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errHookVeto):
+		bt.logger.Info("Upload vetoed by hook", LogFieldFile, result.Path)
+		bt.stats.Skipped++
+		result.Outcome = OutcomeSkipped
+	default:
+		bt.stats.Errors++
+		result.fail(err)
+	}
+	return false
+}
+
+// afterUpload runs the post-upload hook and reports whether the uploaded
+// file may be deleted. After a failed upload the hook is only informed.
+func (bt *BackupTool) afterUpload(ctx context.Context, result *fileResult, uploadErr error) bool {
+	err := bt.runHook(ctx, HookPostUpload, bt.config.PostUploadHook, bt.fileHookEnv(result, result.Outcome))
+	switch {
+	case err == nil:
+		return uploadErr == nil
+	case uploadErr != nil:
+		return false
+	case errors.Is(err, errHookVeto):
+		bt.logger.Info("Local file kept by hook", LogFieldFile, result.Path)
+	default:
+		bt.stats.Errors++
+		result.setError(err)
+	}
+	return false
+}
+
+// deleteArchivedFile deletes an archived local file between the pre-delete
+// and post-delete hooks. A veto by the pre-delete hook returns an error
+// wrapping errHookVeto; the result of the post-delete hook is only logged.
+func (bt *BackupTool) deleteArchivedFile(ctx context.Context, result *fileResult) error {
+	if err := bt.runHook(ctx, HookPreDelete, bt.config.PreDeleteHook, bt.fileHookEnv(result, result.Outcome)); err != nil {
+		if errors.Is(err, errHookVeto) {
+			bt.logger.Info("Local file kept by hook", LogFieldFile, result.Path)
+		}
+		return err
+	}
+
+	err := bt.deleteLocalFile(result.Path)
+	env := bt.fileHookEnv(result, "deleted")
+	if err != nil {
+		env = append(env, "BACKUP_STATUS="+OutcomeFailed, "BACKUP_ERROR="+redactSecrets(err.Error()))
+	}
+	bt.runHook(ctx, HookPostDelete, bt.config.PostDeleteHook, env)
+	return err
+}
+
+// limitedBuffer keeps the first hookOutputLimit bytes written to it
+type limitedBuffer struct {
+	strings.Builder
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := hookOutputLimit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Builder.Write(p[:room])
+		} else {
+			b.Builder.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hookLogger returns a hook command appending a line to logPath
+func hookLogger(logPath string) string {
+	return `echo "$BACKUP_HOOK|$BACKUP_STATUS|${BACKUP_FILE##*/}|$BACKUP_KEY|$BACKUP_UPLOADED" >> ` + logPath
+}
+
+// TestRunHook tests the exit status, timeout and environment of a hook
+func TestRunHook(t *testing.T) {
+	bt := &BackupTool{config: Config{HookTimeout: time.Second}, logger: slog.New(slog.DiscardHandler)}
+	ctx := context.Background()
+
+	if err := bt.runHook(ctx, HookPreUpload, `test "$BACKUP_HOOK" = pre-upload && test "$BACKUP_FILE" = /var/log/a.log`, []string{"BACKUP_FILE=/var/log/a.log"}); err != nil {
+		t.Errorf("runHook() error = %v", err)
+	}
+
+	err := bt.runHook(ctx, HookPreUpload, "echo busy; exit 3", nil)
+	if !errors.Is(err, errHookVeto) || !strings.Contains(err.Error(), "status 3") {
+		t.Errorf("runHook() error = %v, want a veto with status 3", err)
+	}
+
+	bt.config.HookTimeout = 100 * time.Millisecond
+	err = bt.runHook(ctx, HookPreDelete, "exec sleep 5", nil)
+	if err == nil || errors.Is(err, errHookVeto) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("runHook() error = %v, want a timeout", err)
+	}
+
+	bt.config.DryRun = true
+	if err := bt.runHook(ctx, HookPreUpload, "exit 1", nil); err != nil {
+		t.Errorf("Hooks should not run in dry-run mode, got %v", err)
+	}
+}
+
+// TestHooksAroundRun tests the order and environment of the hooks of a
+// successful run
+func TestHooksAroundRun(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	hook := hookLogger(logPath)
+	bt, sourceDir, pattern := newLocalTool(t, Config{
+		DeleteAfterUpload: true,
+		PreRunHook:        hook, PostRunHook: hook,
+		PreUploadHook: hook, PostUploadHook: hook,
+		PreDeleteHook: hook, PostDeleteHook: hook,
+	})
+	if err := bt.Run(context.Background(), pattern); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"pre-run||||",
+		"pre-upload|pending|app-20241214.log|logs/app-20241214.log|",
+		"post-upload|uploaded|app-20241214.log|logs/app-20241214.log|",
+		"pre-delete|uploaded|app-20241214.log|logs/app-20241214.log|",
+		"post-delete|deleted|app-20241214.log|logs/app-20241214.log|",
+		"pre-upload|pending|app-20241215.log|logs/app-20241215.log|",
+		"post-upload|uploaded|app-20241215.log|logs/app-20241215.log|",
+		"pre-delete|uploaded|app-20241215.log|logs/app-20241215.log|",
+		"post-delete|deleted|app-20241215.log|logs/app-20241215.log|",
+		"post-run|success|||2",
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Hook calls:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if entries, _ := os.ReadDir(sourceDir); len(entries) != 0 {
+		t.Errorf("Expected the files to be deleted, found %d", len(entries))
+	}
+}
+
+// TestHookVetoes tests that hooks can veto the run, an upload and a delete
+func TestHookVetoes(t *testing.T) {
+	vetoOne := `test "${BACKUP_FILE##*/}" != app-20241215.log`
+
+	t.Run("pre-run", func(t *testing.T) {
+		logPath := filepath.Join(t.TempDir(), "hooks.log")
+		bt, _, pattern := newLocalTool(t, Config{PreRunHook: "exit 1", PreUploadHook: hookLogger(logPath)})
+		err := bt.Run(context.Background(), pattern)
+		if !errors.Is(err, errHookVeto) || exitCode(err) != ExitFailure {
+			t.Fatalf("Run() error = %v, want a pre-run veto", err)
+		}
+		if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+			t.Error("Expected no file to be processed after a pre-run veto")
+		}
+	})
+
+	t.Run("pre-upload", func(t *testing.T) {
+		bt, _, pattern := newLocalTool(t, Config{PreUploadHook: vetoOne})
+		if err := bt.Run(context.Background(), pattern); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if bt.stats.Uploaded != 1 || bt.stats.Skipped != 1 || bt.stats.Errors != 0 {
+			t.Errorf("Stats = %+v, want 1 uploaded and 1 skipped", bt.stats)
+		}
+		if _, err := os.Stat(filepath.Join(bt.config.LocalDir, "logs", "app-20241215.log")); !os.IsNotExist(err) {
+			t.Error("Expected the vetoed file not to be uploaded")
+		}
+	})
+
+	for _, event := range []string{HookPostUpload, HookPreDelete} {
+		t.Run(event, func(t *testing.T) {
+			config := Config{DeleteAfterUpload: true}
+			if event == HookPostUpload {
+				config.PostUploadHook = vetoOne
+			} else {
+				config.PreDeleteHook = vetoOne
+			}
+			bt, sourceDir, pattern := newLocalTool(t, config)
+			if err := bt.Run(context.Background(), pattern); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if bt.stats.Uploaded != 2 || bt.stats.Deleted != 1 || bt.stats.Errors != 0 {
+				t.Errorf("Stats = %+v, want 2 uploaded and 1 deleted", bt.stats)
+			}
+			if _, err := os.Stat(filepath.Join(sourceDir, "app-20241215.log")); err != nil {
+				t.Errorf("Expected the vetoed file to be kept: %v", err)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		bt, sourceDir, pattern := newLocalTool(t, Config{DeleteAfterUpload: true, PreDeleteHook: "exec sleep 5", HookTimeout: 100 * time.Millisecond})
+		err := bt.Run(context.Background(), pattern)
+		if exitCode(err) != ExitPartial || bt.stats.Errors != 2 {
+			t.Errorf("Run() error = %v with %d errors, want a partial failure with 2 errors", err, bt.stats.Errors)
+		}
+		if entries, _ := os.ReadDir(sourceDir); len(entries) != 2 {
+			t.Errorf("Expected both files to be kept, found %d", len(entries))
+		}
+	})
+}
@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
@@ -198,11 +199,11 @@ func TestIntegrationS3Operations(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create BackupTool: %v", err)
 	}
-	bt.s3Client = s3Client // Override with test client
+	bt.store = newS3Store(s3Client, config.S3Bucket) // Override with test client
 
 	// Test upload
 	t.Run("Upload to S3", func(t *testing.T) {
-		err := bt.uploadToS3(ctx, testFilePath)
+		_, err := bt.uploadToS3(ctx, testFilePath)
 		if err != nil {
 			t.Errorf("uploadToS3() error = %v", err)
 		}
@@ -312,7 +313,7 @@ func TestIntegrationFullWorkflow(t *testing.T) {
 	}
 
 	// Override S3 client with LocalStack client
-	bt.s3Client = s3Client
+	bt.store = newS3Store(s3Client, config.S3Bucket)
 
 	// Run the backup process
 	globPattern := filepath.Join(tempDir, "*-YYYYMMDD.log.gz")
@@ -509,10 +510,10 @@ func TestIntegrationDateBasedPrefix(t *testing.T) {
 			}
 
 			// Override S3 client with LocalStack client
-			bt.s3Client = s3Client
+			bt.store = newS3Store(s3Client, config.S3Bucket)
 
 			// Test upload
-			err = bt.uploadToS3(ctx, testFilePath)
+			_, err = bt.uploadToS3(ctx, testFilePath)
 			if err != nil {
 				t.Errorf("uploadToS3() error = %v", err)
 				return
@@ -668,10 +669,10 @@ func TestIntegrationDateBasedPrefixErrors(t *testing.T) {
 			}
 
 			// Override S3 client with LocalStack client
-			bt.s3Client = s3Client
+			bt.store = newS3Store(s3Client, config.S3Bucket)
 
 			// Test upload
-			err = bt.uploadToS3(ctx, testFilePath)
+			_, err = bt.uploadToS3(ctx, testFilePath)
 			
 			if tc.expectError {
 				if err == nil {
@@ -691,4 +692,331 @@ func TestIntegrationDateBasedPrefixErrors(t *testing.T) {
 			os.Remove(testFilePath)
 		})
 	}
-}
\ No newline at end of file
+}
+// TestIntegrationRestore tests uploading files and restoring them to a target directory
+func TestIntegrationRestore(t *testing.T) {
+	ctx := context.Background()
+
+	// Start LocalStack
+	localstackContainer, endpoint := setupLocalStack(t)
+	defer func() {
+		if err := localstackContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate LocalStack container: %v", err)
+		}
+	}()
+
+	testBucket := "test-restore-bucket"
+	testRegion := "us-east-1"
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(testRegion),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create AWS config: %v", err)
+	}
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	if _, err := s3Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(testBucket)}); err != nil {
+		t.Fatalf("Failed to create test bucket: %v", err)
+	}
+
+	files := map[string]string{
+		"app-20241201.log.gz": "december first",
+		"app-20241215.log.gz": "december fifteenth",
+		"web-20241215.log.gz": "web december fifteenth",
+	}
+
+	bt := &BackupTool{
+		config: Config{
+			S3Bucket:     testBucket,
+			S3Prefix:     "logs/YYYY/MM/DD",
+			StorageClass: "STANDARD",
+		},
+		store:  newS3Store(s3Client, testBucket),
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+	}
+	for name, content := range files {
+		path := filepath.Join(sourceDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file %s: %v", name, err)
+		}
+		if _, err := bt.uploadToS3(ctx, path); err != nil {
+			t.Fatalf("uploadToS3() error = %v", err)
+		}
+	}
+
+	from := time.Date(2024, 12, 10, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC)
+	err = bt.Restore(ctx, RestoreOptions{
+		From:        from,
+		To:          to,
+		Filter:      "app-*",
+		TargetDir:   targetDir,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	restored := filepath.Join(targetDir, sourceDir, "app-20241215.log.gz")
+	data, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatalf("Expected restored file at %s: %v", restored, err)
+	}
+	if string(data) != files["app-20241215.log.gz"] {
+		t.Errorf("Restored content = %q, want %q", data, files["app-20241215.log.gz"])
+	}
+
+	for _, name := range []string{"app-20241201.log.gz", "web-20241215.log.gz"} {
+		if _, err := os.Stat(filepath.Join(targetDir, sourceDir, name)); !os.IsNotExist(err) {
+			t.Errorf("File %s should not have been restored", name)
+		}
+	}
+}
+
+// TestIntegrationVerify tests comparing local files against S3
+func TestIntegrationVerify(t *testing.T) {
+	ctx := context.Background()
+
+	// Start LocalStack
+	localstackContainer, endpoint := setupLocalStack(t)
+	defer func() {
+		if err := localstackContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate LocalStack container: %v", err)
+		}
+	}()
+
+	testBucket := "test-verify-bucket"
+	testRegion := "us-east-1"
+	tempDir := t.TempDir()
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(testRegion),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create AWS config: %v", err)
+	}
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	if _, err := s3Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(testBucket)}); err != nil {
+		t.Fatalf("Failed to create test bucket: %v", err)
+	}
+
+	bt := &BackupTool{
+		config: Config{
+			S3Bucket:     testBucket,
+			S3Prefix:     "logs",
+			StorageClass: "STANDARD",
+		},
+		store:      newS3Store(s3Client, testBucket),
+		logger:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		cutoffTime: time.Now(),
+	}
+
+	lastMonth := time.Now().AddDate(0, -1, 0)
+	archived := filepath.Join(tempDir, fmt.Sprintf("app-%s.log", lastMonth.Format("20060102")))
+	changed := filepath.Join(tempDir, fmt.Sprintf("app-%s.log", lastMonth.AddDate(0, 0, -1).Format("20060102")))
+	missing := filepath.Join(tempDir, fmt.Sprintf("app-%s.log", lastMonth.AddDate(0, 0, -2).Format("20060102")))
+	for _, path := range []string{archived, changed, missing} {
+		if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+	for _, path := range []string{archived, changed} {
+		if _, err := bt.uploadToS3(ctx, path); err != nil {
+			t.Fatalf("uploadToS3() error = %v", err)
+		}
+	}
+	if err := os.WriteFile(changed, []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify test file: %v", err)
+	}
+
+	report, err := bt.Verify(ctx, filepath.Join(tempDir, "app-YYYYMMDD.log"))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if report.Counts[VerifyArchived] != 1 {
+		t.Errorf("Expected 1 archived file, got %d", report.Counts[VerifyArchived])
+	}
+	if report.Counts[VerifyChecksumMismatch] != 1 {
+		t.Errorf("Expected 1 checksum mismatch, got %d", report.Counts[VerifyChecksumMismatch])
+	}
+	if report.Counts[VerifyMissing] != 1 {
+		t.Errorf("Expected 1 missing file, got %d", report.Counts[VerifyMissing])
+	}
+	if report.Discrepancies != 2 {
+		t.Errorf("Expected 2 discrepancies, got %d", report.Discrepancies)
+	}
+}
+
+// TestIntegrationRetention tests uploading recent files while deleting only
+// files past the local retention period
+func TestIntegrationRetention(t *testing.T) {
+	ctx := context.Background()
+
+	// Start LocalStack
+	localstackContainer, endpoint := setupLocalStack(t)
+	defer func() {
+		if err := localstackContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate LocalStack container: %v", err)
+		}
+	}()
+
+	testBucket := "test-retention-bucket"
+	testRegion := "us-east-1"
+	tempDir := t.TempDir()
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(testRegion),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create AWS config: %v", err)
+	}
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+	if _, err := s3Client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(testBucket)}); err != nil {
+		t.Fatalf("Failed to create test bucket: %v", err)
+	}
+
+	recent := filepath.Join(tempDir, fmt.Sprintf("app-%s.log", time.Now().AddDate(0, 0, -3).Format("20060102")))
+	expired := filepath.Join(tempDir, fmt.Sprintf("app-%s.log", time.Now().AddDate(0, 0, -10).Format("20060102")))
+	for _, path := range []string{recent, expired} {
+		if err := os.WriteFile(path, []byte("log content"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	bt, err := NewBackupTool(Config{
+		S3Bucket:     testBucket,
+		S3Prefix:     "logs",
+		StorageClass: "STANDARD",
+		Period:       "1 day",
+		Retention:    "7 days",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create BackupTool: %v", err)
+	}
+	bt.store = newS3Store(s3Client, testBucket)
+
+	files, err := bt.findTargetFiles(filepath.Join(tempDir, "app-YYYYMMDD.log"))
+	if err != nil {
+		t.Fatalf("findTargetFiles() error = %v", err)
+	}
+	if err := bt.processFiles(ctx, files); err != nil {
+		t.Fatalf("processFiles() error = %v", err)
+	}
+
+	if bt.stats.Uploaded != 2 || bt.stats.Deleted != 1 || bt.stats.Retained != 1 || bt.stats.Errors != 0 {
+		t.Errorf("Unexpected stats: %+v", bt.stats)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("File within retention should be kept locally")
+	}
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Error("File past retention should be deleted locally")
+	}
+
+	// A second run finds the retained file already archived and does not upload it again
+	bt.stats = Stats{}
+	if err := bt.processFiles(ctx, []string{recent}); err != nil {
+		t.Fatalf("processFiles() error = %v", err)
+	}
+	if bt.stats.Uploaded != 0 || bt.stats.AlreadyArchived != 1 {
+		t.Errorf("Unexpected stats on second run: %+v", bt.stats)
+	}
+}
+
+// TestIntegrationGCSStore runs the object store tests against fake-gcs-server
+func TestIntegrationGCSStore(t *testing.T) {
+	ctx := context.Background()
+
+	gcsContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "fsouza/fake-gcs-server:latest",
+			Cmd:          []string{"-scheme", "http", "-port", "4443"},
+			ExposedPorts: []string{"4443/tcp"},
+			WaitingFor:   wait.ForListeningPort("4443/tcp").WithStartupTimeout(60 * time.Second),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start fake-gcs-server container: %v", err)
+	}
+	defer func() {
+		if err := gcsContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate container: %v", err)
+		}
+	}()
+
+	endpoint, err := gcsContainer.PortEndpoint(ctx, "4443/tcp", "")
+	if err != nil {
+		t.Fatalf("Failed to get container endpoint: %v", err)
+	}
+	t.Setenv("STORAGE_EMULATOR_HOST", endpoint)
+
+	testBucket := "test-gcs-bucket"
+	store, err := newGCSStore(ctx, Config{Backend: BackendGCS, S3Bucket: testBucket})
+	if err != nil {
+		t.Fatalf("newGCSStore() error = %v", err)
+	}
+	defer store.Close()
+	if err := store.client.Bucket(testBucket).Create(ctx, "test-project", nil); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	testObjectStore(t, store)
+}
+
+// TestIntegrationAzureStore runs the object store tests against Azurite
+func TestIntegrationAzureStore(t *testing.T) {
+	ctx := context.Background()
+
+	azuriteContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "mcr.microsoft.com/azure-storage/azurite:latest",
+			Cmd:          []string{"azurite-blob", "--blobHost", "0.0.0.0", "--skipApiVersionCheck"},
+			ExposedPorts: []string{"10000/tcp"},
+			WaitingFor:   wait.ForListeningPort("10000/tcp").WithStartupTimeout(60 * time.Second),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to start Azurite container: %v", err)
+	}
+	defer func() {
+		if err := azuriteContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate container: %v", err)
+		}
+	}()
+
+	endpoint, err := azuriteContainer.PortEndpoint(ctx, "10000/tcp", "http")
+	if err != nil {
+		t.Fatalf("Failed to get container endpoint: %v", err)
+	}
+	// Well-known development account of Azurite
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;"+
+		"AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;"+
+		"BlobEndpoint="+endpoint+"/devstoreaccount1;")
+
+	store, err := newAzureStore(Config{Backend: BackendAzure, S3Bucket: "test-container"})
+	if err != nil {
+		t.Fatalf("newAzureStore() error = %v", err)
+	}
+	if _, err := store.container.Create(ctx, nil); err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+
+	testObjectStore(t, store)
+}
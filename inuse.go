@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Reasons a file is skipped because it is still being written
+const (
+	SkipReasonOpen     = "open-for-writing"
+	SkipReasonChanging = "changing"
+)
+
+// errOpenCheckUnsupported is returned where -skip-open cannot find open files
+var errOpenCheckUnsupported = errors.New("not supported on this platform")
+
+// validateInUse checks the in-use file options
+func validateInUse(config Config) error {
+	if config.StabilityWindow < 0 {
+		return fmt.Errorf("-stability-window must not be negative")
+	}
+	return nil
+}
+
+// filterInUseFiles removes the files that are still being written: files
+// whose size or mtime changes during -stability-window, and with -skip-open
+// files another process has open for writing. They are skipped with their
+// reason in the report and picked up by a later run.
+func (bt *BackupTool) filterInUseFiles(ctx context.Context, files []string) ([]string, error) {
+	if len(files) == 0 || (!bt.config.SkipOpen && bt.config.StabilityWindow == 0) {
+		return files, nil
+	}
+
+	if bt.config.StabilityWindow > 0 {
+		before := make(map[string]os.FileInfo, len(files))
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				before[file] = info
+			}
+		}
+
+		bt.logger.Info("Waiting for files to settle", "window", bt.config.StabilityWindow.String(), "count", len(files))
+		timer := time.NewTimer(bt.config.StabilityWindow)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		var stable []string
+		for _, file := range files {
+			// Files that vanished are reported when they are processed
+			info, err := os.Stat(file)
+			if previous, ok := before[file]; ok && err == nil &&
+				(info.Size() != previous.Size() || !info.ModTime().Equal(previous.ModTime())) {
+				bt.skipInUse(file, info, SkipReasonChanging, "size", info.Size(), "previous_size", previous.Size())
+				continue
+			}
+			stable = append(stable, file)
+		}
+		files = stable
+	}
+
+	if bt.config.SkipOpen {
+		writers, err := openForWriting(files)
+		if err != nil {
+			// Uploading is still safe with the stability window, so carry on
+			bt.logger.Warn("Cannot check for files open for writing", errAttr(err))
+			return files, nil
+		}
+		var closed []string
+		for _, file := range files {
+			if pid, ok := writers[file]; ok {
+				info, _ := os.Stat(file)
+				bt.skipInUse(file, info, SkipReasonOpen, "pid", pid)
+				continue
+			}
+			closed = append(closed, file)
+		}
+		files = closed
+	}
+	return files, nil
+}
+
+// skipInUse records a file skipped because it is still being written. The
+// file is not hashed, since its content is changing.
+func (bt *BackupTool) skipInUse(file string, info os.FileInfo, reason string, args ...any) {
+	bt.logger.Warn("File in use, skipped", append([]any{LogFieldFile, file, "reason", reason}, args...)...)
+	bt.stats.Skipped++
+	result := &fileResult{Path: file, Outcome: OutcomeSkipped, Reason: reason}
+	if info != nil {
+		result.Size = info.Size()
+		result.ModTime = info.ModTime().UTC()
+	}
+	bt.results = append(bt.results, result)
+}
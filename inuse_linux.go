@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	dev, ino uint64
+}
+
+// openForWriting returns the files another process has open for writing,
+// with the ID of one such process, like fuser(1). It scans /proc/*/fd, so
+// the processes of other users are only seen when running as root.
+func openForWriting(files []string) (map[string]int, error) {
+	targets := make(map[fileID]string, len(files))
+	for _, file := range files {
+		if id, ok := statFileID(file); ok {
+			targets[id] = file
+		}
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	writers := make(map[string]int)
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		// The process may have exited, or belong to another user
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			id, ok := statFileID(filepath.Join(fdDir, fd.Name()))
+			if !ok {
+				continue
+			}
+			file, ok := targets[id]
+			if !ok {
+				continue
+			}
+			if _, found := writers[file]; !found && fdWritable(proc.Name(), fd.Name()) {
+				writers[file] = pid
+			}
+		}
+	}
+	return writers, nil
+}
+
+// statFileID returns the device and inode of a regular file, following
+// symlinks such as /proc/<pid>/fd/<n>
+func statFileID(path string) (fileID, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fileID{}, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// fdWritable reports whether a file descriptor was opened for writing,
+// from the flags in /proc/<pid>/fdinfo/<fd>
+func fdWritable(pid, fd string) bool {
+	file, err := os.Open(filepath.Join("/proc", pid, "fdinfo", fd))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(value), 8, 64)
+		return err == nil && flags&syscall.O_ACCMODE != syscall.O_RDONLY
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// openForWriting is only implemented on Linux, which has /proc
+func openForWriting(files []string) (map[string]int, error) {
+	return nil, errOpenCheckUnsupported
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestStabilityWindow tests that a file appended to during the window is
+// skipped and kept, while the settled file is uploaded and deleted
+func TestStabilityWindow(t *testing.T) {
+	bt, sourceDir, pattern := newLocalTool(t, Config{StabilityWindow: 200 * time.Millisecond, DeleteAfterUpload: true})
+	growing := filepath.Join(sourceDir, "app-20241215.log")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		file, err := os.OpenFile(growing, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		file.WriteString("late line\n")
+	}()
+
+	if err := bt.Run(context.Background(), pattern); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if bt.stats.Uploaded != 1 || bt.stats.Deleted != 1 || bt.stats.Skipped != 1 {
+		t.Errorf("Stats = %+v, want 1 uploaded, 1 deleted and 1 skipped", bt.stats)
+	}
+	if _, err := os.Stat(growing); err != nil {
+		t.Errorf("Expected the changing file to be kept: %v", err)
+	}
+	if result := findResult(bt.results, growing); result == nil || result.Outcome != OutcomeSkipped || result.Reason != SkipReasonChanging {
+		t.Errorf("Result = %+v, want skipped as %s", result, SkipReasonChanging)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bt.filterInUseFiles(ctx, []string{growing}); err == nil {
+		t.Error("Expected the window to end with the context")
+	}
+}
+
+// TestSkipOpen tests that a file another process holds open for writing is
+// skipped, and that a file open only for reading is not
+func TestSkipOpen(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("-skip-open needs /proc")
+	}
+	bt, sourceDir, pattern := newLocalTool(t, Config{SkipOpen: true, DeleteAfterUpload: true})
+	written := filepath.Join(sourceDir, "app-20241215.log")
+	read := filepath.Join(sourceDir, "app-20241214.log")
+
+	writer, err := os.OpenFile(written, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.Open(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sleep", "10")
+	cmd.ExtraFiles = []*os.File{writer, reader}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	reader.Close()
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	writers, err := openForWriting([]string{written, read})
+	if err != nil {
+		t.Fatalf("openForWriting() error = %v", err)
+	}
+	if pid, ok := writers[written]; !ok || pid != cmd.Process.Pid {
+		t.Errorf("openForWriting() = %v, want %s open by %d", writers, written, cmd.Process.Pid)
+	}
+	if _, ok := writers[read]; ok {
+		t.Error("Expected a file open for reading not to be reported")
+	}
+
+	if err := bt.Run(context.Background(), pattern); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if bt.stats.Uploaded != 1 || bt.stats.Skipped != 1 {
+		t.Errorf("Stats = %+v, want 1 uploaded and 1 skipped", bt.stats)
+	}
+	if result := findResult(bt.results, written); result == nil || result.Reason != SkipReasonOpen {
+		t.Errorf("Result = %+v, want skipped as %s", result, SkipReasonOpen)
+	}
+	if _, err := os.Stat(written); err != nil {
+		t.Errorf("Expected the open file to be kept: %v", err)
+	}
+}
+
+// findResult returns the result for a file, or nil
+func findResult(results []*fileResult, path string) *fileResult {
+	for _, result := range results {
+		if result.Path == path {
+			return result
+		}
+	}
+	return nil
+}
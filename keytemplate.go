@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateVariables are the template variables derived from a file's date
+var dateVariables = []string{"year", "month", "day", "hour", "date", "yday", "isoyear", "isoweek"}
+
+// templateVariables are the template variables available to every object,
+// in addition to the placeholders of the glob pattern
+var templateVariables = append([]string{"host", "job", "filename"}, dateVariables...)
+
+// variableNamePattern matches a valid template variable name
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// prefixWord matches a run of letters and digits. Everything else, including
+// "_", "-", "/", "=" and ".", separates words in a prefix.
+var prefixWord = regexp.MustCompile(`[A-Za-z0-9]+`)
+
+// legacyDateWord matches a word made only of the legacy YYYY, MM and DD
+// tokens, so "logs/YYYY_MM_DD" and "app-YYYYMMDD" are converted but the
+// letters in words like "ADDONS" or "COMMON" are left alone
+var legacyDateWord = regexp.MustCompile(`^(?:YYYY|MM|DD)+$`)
+
+// strftimeVariables are the strftime style conversions accepted in prefixes
+var strftimeVariables = map[byte]string{
+	'Y': "{year}",
+	'm': "{month}",
+	'd': "{day}",
+	'H': "{hour}",
+	'F': "{date}",
+	'j': "{yday}",
+	'G': "{isoyear}",
+	'V': "{isoweek}",
+	'%': "%",
+}
+
+// hourPattern matches an hour in a file name: a bare YYYYMMDDHH, e.g.
+// app-2024121513.log, or the hour after a T, e.g. app-2024-12-15T13.log.
+// Numbers after a - or _ are rotation counters, not hours.
+var hourPattern = regexp.MustCompile(`(?:^|\D)\d{8}(\d{2})(?:\D|$)|\d{4}-?\d{2}-?\d{2}T(\d{2})`)
+
+// variablePatterns are the regular expressions the date variables expand to
+// in an object key. Other variables match any single path segment.
+var variablePatterns = map[string]string{
+	"year":    `\d{4}`,
+	"month":   `\d{2}`,
+	"day":     `\d{2}`,
+	"hour":    `\d{2}`,
+	"date":    `\d{4}-\d{2}-\d{2}`,
+	"yday":    `\d{3}`,
+	"isoyear": `\d{4}`,
+	"isoweek": `\d{2}`,
+}
+
+// templatePart is a literal string or a variable reference
+type templatePart struct {
+	Literal  string
+	Variable string
+}
+
+// keyTemplate is a parsed template such as "logs/{year}/{month}". Variables
+// are written {name}; {{ and }} produce literal braces.
+type keyTemplate struct {
+	parts []templatePart
+}
+
+// parseTemplate parses a template, failing on unbalanced braces or invalid
+// variable names
+func parseTemplate(s string) (*keyTemplate, error) {
+	t := &keyTemplate{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{Literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			literal.WriteByte('{')
+			i += 2
+		case strings.HasPrefix(s[i:], "}}"):
+			literal.WriteByte('}')
+			i += 2
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '{' in '%s'", s)
+			}
+			name := s[i+1 : i+end]
+			if !variableNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("invalid variable '{%s}' in '%s' (use {{ and }} for literal braces)", name, s)
+			}
+			flush()
+			t.parts = append(t.parts, templatePart{Variable: name})
+			i += end + 1
+		case s[i] == '}':
+			return nil, fmt.Errorf("unexpected '}' in '%s' (use }} for a literal brace)", s)
+		default:
+			literal.WriteByte(s[i])
+			i++
+		}
+	}
+	flush()
+	return t, nil
+}
+
+// parsePrefixTemplate parses an S3 prefix. {{.Year}} style variables and
+// the strftime conversions %Y, %m, %d, %H, %F, %j, %G and %V are accepted as
+// well, and so are the legacy YYYY, MM and DD tokens as whole words.
+func parsePrefixTemplate(prefix string) (*keyTemplate, error) {
+	converted := convertStrftime(convertGoTemplate(prefix))
+	converted = prefixWord.ReplaceAllStringFunc(converted, func(word string) string {
+		if !legacyDateWord.MatchString(word) {
+			return word
+		}
+		var out strings.Builder
+		for len(word) > 0 {
+			switch {
+			case strings.HasPrefix(word, "YYYY"):
+				out.WriteString("{year}")
+				word = word[4:]
+			case strings.HasPrefix(word, "MM"):
+				out.WriteString("{month}")
+				word = word[2:]
+			default:
+				out.WriteString("{day}")
+				word = word[2:]
+			}
+		}
+		return out.String()
+	})
+	return parseTemplate(converted)
+}
+
+// convertStrftime rewrites strftime conversions such as %Y to template
+// variables. Other % sequences are kept as written.
+func convertStrftime(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+1 < len(s) {
+			if variable, ok := strftimeVariables[s[i+1]]; ok {
+				out.WriteString(variable)
+				i++
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// variables returns the distinct variables used by the template
+func (t *keyTemplate) variables() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, part := range t.parts {
+		if part.Variable != "" && !seen[part.Variable] {
+			seen[part.Variable] = true
+			names = append(names, part.Variable)
+		}
+	}
+	return names
+}
+
+// usesAny reports whether the template uses any of the variables
+func (t *keyTemplate) usesAny(names []string) bool {
+	for _, part := range t.parts {
+		for _, name := range names {
+			if part.Variable == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expand substitutes every variable with its value from vars
+func (t *keyTemplate) expand(vars map[string]string) (string, error) {
+	var out strings.Builder
+	for _, part := range t.parts {
+		if part.Variable == "" {
+			out.WriteString(part.Literal)
+			continue
+		}
+		value, ok := vars[part.Variable]
+		if !ok {
+			return "", fmt.Errorf("unknown variable {%s}", part.Variable)
+		}
+		out.WriteString(value)
+	}
+	return out.String(), nil
+}
+
+// expandKnown expands the template up to the first variable missing from
+// vars. If it stops early, the result is cut back to the last complete path
+// segment, giving a prefix under which every possible expansion lies.
+func (t *keyTemplate) expandKnown(vars map[string]string) string {
+	var out strings.Builder
+	for _, part := range t.parts {
+		if part.Variable == "" {
+			out.WriteString(part.Literal)
+			continue
+		}
+		value, ok := vars[part.Variable]
+		if !ok {
+			expanded := out.String()
+			if i := strings.LastIndex(expanded, "/"); i >= 0 {
+				return expanded[:i]
+			}
+			return ""
+		}
+		out.WriteString(value)
+	}
+	return out.String()
+}
+
+// keyPattern returns a regular expression matching the keys of objects
+// stored under the template: an expansion of the template, a slash and a
+// file name
+func (t *keyTemplate) keyPattern() *regexp.Regexp {
+	var out strings.Builder
+	out.WriteString("^")
+	for _, part := range t.parts {
+		if part.Variable == "" {
+			out.WriteString(regexp.QuoteMeta(part.Literal))
+			continue
+		}
+		if pattern, ok := variablePatterns[part.Variable]; ok {
+			out.WriteString(pattern)
+		} else {
+			out.WriteString(`[^/]*`)
+		}
+	}
+	out.WriteString(`/[^/]+$`)
+	return regexp.MustCompile(out.String())
+}
+
+// prefixBefore expands a prefix with the given variables up to the first
+// unknown one; see expandKnown
+func prefixBefore(prefix string, vars map[string]string) string {
+	t, err := parsePrefixTemplate(prefix)
+	if err != nil {
+		// Prefixes are validated when flags are parsed
+		return prefix
+	}
+	return t.expandKnown(vars)
+}
+
+// validateTemplate checks that a template only uses the standard variables
+// and the placeholders of the glob pattern, and that {job} has a value
+func validateTemplate(t *keyTemplate, globPattern, jobName string) error {
+	known := make(map[string]bool)
+	for _, name := range templateVariables {
+		known[name] = true
+	}
+	for _, name := range globPlaceholderNames(globPattern) {
+		known[name] = true
+	}
+	for _, name := range t.variables() {
+		if !known[name] {
+			return fmt.Errorf("unknown variable {%s}", name)
+		}
+		if name == "job" && jobName == "" {
+			return fmt.Errorf("{job} requires -job")
+		}
+	}
+	return nil
+}
+
+// validatePrefixTemplate checks the -prefix template before any upload
+func validatePrefixTemplate(config Config, globPattern string) error {
+	t, err := parsePrefixTemplate(config.S3Prefix)
+	if err == nil {
+		err = validateTemplate(t, globPattern, config.JobName)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid prefix '%s': %w", config.S3Prefix, err)
+	}
+	return nil
+}
+
+// dateVars returns the date template variables for t
+func dateVars(t time.Time) map[string]string {
+	isoYear, isoWeek := t.ISOWeek()
+	return map[string]string{
+		"year":    t.Format("2006"),
+		"month":   t.Format("01"),
+		"day":     t.Format("02"),
+		"hour":    t.Format("15"),
+		"date":    t.Format("2006-01-02"),
+		"yday":    fmt.Sprintf("%03d", t.YearDay()),
+		"isoyear": fmt.Sprintf("%04d", isoYear),
+		"isoweek": fmt.Sprintf("%02d", isoWeek),
+	}
+}
+
+// extractHourFromFilename returns the hour that directly follows the date in
+// a file name, if any
+func extractHourFromFilename(filename string) (int, bool) {
+	m := hourPattern.FindStringSubmatch(filename)
+	if m == nil {
+		return 0, false
+	}
+	hour, err := strconv.Atoi(m[1] + m[2])
+	if err != nil || hour > 23 {
+		return 0, false
+	}
+	return hour, true
+}
+
+// templateVars returns the template variables for an object. The date comes
+// from the file name, or from the key if the file name has none. Variables
+// that do not apply to the object (e.g. glob placeholders for a bundle) are
+// set to an empty string.
+func (bt *BackupTool) templateVars(s3Key, filePath string) map[string]string {
+	vars := make(map[string]string)
+	for _, name := range templateVariables {
+		vars[name] = ""
+	}
+	if bt.captures != nil {
+		for _, name := range bt.captures.names {
+			vars[name] = ""
+		}
+	}
+
+	vars["host"], _ = os.Hostname()
+	vars["job"] = bt.config.JobName
+	vars["filename"] = filepath.Base(filePath)
+
+	filename := filepath.Base(filePath)
+	date, err := extractDateFromFilename(filename)
+	if err != nil {
+		date, _ = objectDateFromKey(s3Key)
+	}
+	if !date.IsZero() {
+		if hour, ok := extractHourFromFilename(filename); ok {
+			date = date.Add(time.Duration(hour) * time.Hour)
+		}
+		for name, value := range dateVars(date) {
+			vars[name] = value
+		}
+	}
+
+	for name, value := range bt.fileCaptures(filePath) {
+		vars[name] = value
+	}
+	return vars
+}
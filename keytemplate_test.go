@@ -0,0 +1,205 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParsePrefixTemplate tests expanding prefix templates, including the
+// legacy tokens
+func TestParsePrefixTemplate(t *testing.T) {
+	vars := dateVars(time.Date(2024, 12, 15, 13, 0, 0, 0, time.UTC))
+	vars["host"] = "web01"
+	vars["job"] = "nightly"
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{"Variables", "logs/{year}/{month}/{day}", "logs/2024/12/15"},
+		{"Hive partition", "logs/dt={date}", "logs/dt=2024-12-15"},
+		{"Hour, day of year and ISO week", "logs/{isoyear}-W{isoweek}/{yday}/{hour}", "logs/2024-W50/350/13"},
+		{"Host and job", "{job}/{host}/{year}", "nightly/web01/2024"},
+		{"Escaped braces", "logs/{{raw}}/{year}", "logs/{raw}/2024"},
+		{"Legacy tokens", "logs/YYYY/MM/DD", "logs/2024/12/15"},
+		{"Legacy compact token", "logs/YYYYMMDD", "logs/20241215"},
+		{"Legacy tokens inside words are literal", "ADDONS/COMMON/YYYY", "ADDONS/COMMON/2024"},
+		{"Legacy and new syntax", "logs/{host}/YYYY-MM", "logs/web01/2024-12"},
+		{"Legacy tokens with underscores", "logs/YYYY_MM_DD", "logs/2024_12_15"},
+		{"Legacy token after underscore", "backup_YYYY/MM", "backup_2024/12"},
+		{"Legacy tokens after separators", "logs/dt=YYYY-MM-DD/app.YYYY", "logs/dt=2024-12-15/app.2024"},
+		{"Go template variables", "logs/{{.Year}}/{{ .Month }}/{{.Host}}", "logs/2024/12/web01"},
+		{"strftime conversions", "logs/%Y/%m/%d/%H", "logs/2024/12/15/13"},
+		{"strftime date and escape", "logs/dt=%F/100%%/%j-%G-W%V/%x", "logs/dt=2024-12-15/100%/350-2024-W50/%x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parsePrefixTemplate(tt.prefix)
+			if err != nil {
+				t.Fatalf("parsePrefixTemplate(%q) error = %v", tt.prefix, err)
+			}
+			got, err := tmpl.expand(vars)
+			if err != nil {
+				t.Fatalf("expand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExpandDatePrefix tests expanding the legacy date tokens of a prefix
+// for a day
+func TestExpandDatePrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   time.Time
+		prefix string
+		want   string
+	}{
+		{"No date tokens", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "logs", "logs"},
+		{"Year only", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "logs/YYYY", "logs/2024"},
+		{"Year and month", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "logs/YYYY/MM", "logs/2024/12"},
+		{"Full date", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "logs/YYYY/MM/DD", "logs/2024/12/15"},
+		{"Multiple year tokens", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "YYYY/backup/YYYY", "2024/backup/2024"},
+		{"Mixed with text", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "app-YYYY-MM-logs", "app-2024-12-logs"},
+		{"All tokens", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "YYYY-MM-DD", "2024-12-15"},
+		{"Empty prefix", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "", ""},
+		{"Complex path", time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC), "s3://bucket/YYYY/MM/DD/logs", "s3://bucket/2024/12/15/logs"},
+		{"New Year's Day", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "logs/YYYY/MM/DD", "logs/2024/01/01"},
+		{"Leap year Feb 29", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "logs/YYYY/MM/DD", "logs/2024/02/29"},
+		{"Year boundary", time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC), "backup/YYYY/MM/DD", "backup/2023/12/31"},
+		{"Future date", time.Date(2030, 7, 15, 12, 0, 0, 0, time.UTC), "logs/YYYY/MM", "logs/2030/07"},
+		{"Far past date", time.Date(1990, 3, 5, 9, 15, 30, 0, time.UTC), "archive/YYYY", "archive/1990"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parsePrefixTemplate(tt.prefix)
+			if err != nil {
+				t.Fatalf("parsePrefixTemplate(%q) error = %v", tt.prefix, err)
+			}
+			got, err := tmpl.expand(dateVars(tt.date))
+			if err != nil {
+				t.Fatalf("expand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expand(%q, %v) = %q, want %q", tt.prefix, tt.date, got, tt.want)
+			}
+		})
+	}
+
+	// Only the part before a variable that is not a date is known per day
+	tmpl, err := parsePrefixTemplate("logs/{host}/YYYY/MM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tmpl.expandKnown(dateVars(time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC))); got != "logs" {
+		t.Errorf("expandKnown() = %q, want %q", got, "logs")
+	}
+}
+
+// TestParseTemplateErrors tests that malformed templates are rejected
+func TestParseTemplateErrors(t *testing.T) {
+	for _, tmpl := range []string{
+		"logs/{year",
+		"logs/year}",
+		"logs/{}",
+		"logs/{.Year}",
+		"logs/{1st}",
+	} {
+		if _, err := parseTemplate(tmpl); err == nil {
+			t.Errorf("parseTemplate(%q) should fail", tmpl)
+		}
+	}
+}
+
+// TestExpandKnown tests partial expansion used for listing and manifests
+func TestExpandKnown(t *testing.T) {
+	vars := map[string]string{"year": "2024", "month": "12"}
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"logs/{year}/{month}", "logs/2024/12"},
+		{"logs/{year}/{service}/{month}", "logs/2024"},
+		{"logs/app-{service}", "logs"},
+		{"{service}/{year}", ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixBefore(tt.prefix, vars); got != tt.want {
+			t.Errorf("prefixBefore(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestValidatePrefixTemplate tests validation of the -prefix template
+func TestValidatePrefixTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"Date variables", Config{S3Prefix: "logs/{year}/{isoweek}"}, false},
+		{"Glob placeholder", Config{S3Prefix: "logs/{service}"}, false},
+		{"Job with -job", Config{S3Prefix: "{job}/logs", JobName: "nightly"}, false},
+		{"Job without -job", Config{S3Prefix: "{job}/logs"}, true},
+		{"Unknown variable", Config{S3Prefix: "logs/{team}"}, true},
+		{"Unbalanced brace", Config{S3Prefix: "logs/{year"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePrefixTemplate(tt.config, "/var/log/{service}/*-YYYYMMDD.log")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePrefixTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestExtractHourFromFilename tests reading the hour following a date
+func TestExtractHourFromFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     int
+		wantOK   bool
+	}{
+		{"app-2024121513.log", 13, true},
+		{"app-2024-12-15T07.log", 7, true},
+		{"app-20241215T0730.log", 7, true},
+		{"app-20241215.log", 0, false},
+		{"app-20241215-01.log", 0, false},
+		{"app-20241215_07.log.gz", 0, false},
+		{"app-2024-12-15-23.log.gz", 0, false},
+		{"app-20241215-0001.log", 0, false},
+		{"app-2024121599.log", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := extractHourFromFilename(tt.filename)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("extractHourFromFilename(%s) = %d, %v, want %d, %v", tt.filename, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// TestObjectKeyTemplate tests building keys from a file's date and hour
+func TestObjectKeyTemplate(t *testing.T) {
+	bt := &BackupTool{config: Config{S3Prefix: "logs/dt={date}/hour={hour}"}}
+	got, err := bt.objectKey("/var/log/app-2024121513.log")
+	if err != nil {
+		t.Fatalf("objectKey() error = %v", err)
+	}
+	if want := "logs/dt=2024-12-15/hour=13/app-2024121513.log"; got != want {
+		t.Errorf("objectKey() = %s, want %s", got, want)
+	}
+
+	if _, err := bt.objectKey("/var/log/app.log"); err == nil {
+		t.Error("objectKey() should fail for a date-templated prefix and a file without a date")
+	}
+}
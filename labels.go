@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// S3 allows at most 10 tags per object
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// reservedMetadataKeys are always written by the tool and read back by
+// verify, ls and prune-remote, so templates cannot override them
+var reservedMetadataKeys = map[string]bool{
+	"source-host":   true,
+	"backup-date":   true,
+	"original-path": true,
+}
+
+// stringListFlag is a flag that may be repeated, collecting every value
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// labelTemplate is one key=template pair from -tag or -meta
+type labelTemplate struct {
+	Key      string
+	Template string
+}
+
+// parseLabelTemplates splits key=template pairs
+func parseLabelTemplates(values []string) ([]labelTemplate, error) {
+	templates := make([]labelTemplate, 0, len(values))
+	seen := make(map[string]bool)
+	for _, value := range values {
+		key, tmpl, ok := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label '%s': expected key=value", value)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate label key '%s'", key)
+		}
+		seen[key] = true
+		templates = append(templates, labelTemplate{Key: key, Template: tmpl})
+	}
+	return templates, nil
+}
+
+// expandTemplate parses and expands a tag or metadata template
+func expandTemplate(tmpl string, vars map[string]string) (string, error) {
+	t, err := parseTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	return t.expand(vars)
+}
+
+// checkLabelTemplate validates a tag or metadata template
+func checkLabelTemplate(tmpl, globPattern, jobName string) error {
+	t, err := parseTemplate(tmpl)
+	if err != nil {
+		return err
+	}
+	return validateTemplate(t, globPattern, jobName)
+}
+
+// validateObjectLabels checks the -tag and -meta options. Templates may use
+// the standard variables and the placeholders of the glob pattern.
+func validateObjectLabels(config Config, globPattern string) error {
+	tags, err := parseLabelTemplates(config.ObjectTags)
+	if err != nil {
+		return fmt.Errorf("-tag: %w", err)
+	}
+	if len(tags) > maxObjectTags {
+		return fmt.Errorf("-tag: at most %d tags are allowed, got %d", maxObjectTags, len(tags))
+	}
+	metadata, err := parseLabelTemplates(config.ObjectMetadata)
+	if err != nil {
+		return fmt.Errorf("-meta: %w", err)
+	}
+
+	for _, tag := range tags {
+		if len(tag.Key) > maxTagKeyLength {
+			return fmt.Errorf("-tag: key '%s' is longer than %d characters", tag.Key, maxTagKeyLength)
+		}
+		if err := checkLabelTemplate(tag.Template, globPattern, config.JobName); err != nil {
+			return fmt.Errorf("-tag %s: %w", tag.Key, err)
+		}
+	}
+	for _, meta := range metadata {
+		if reservedMetadataKeys[strings.ToLower(meta.Key)] {
+			return fmt.Errorf("-meta: '%s' is reserved", meta.Key)
+		}
+		if err := checkLabelTemplate(meta.Template, globPattern, config.JobName); err != nil {
+			return fmt.Errorf("-meta %s: %w", meta.Key, err)
+		}
+	}
+	return nil
+}
+
+// applyObjectLabels adds the configured tags and user metadata to an upload
+// request
+func (bt *BackupTool) applyObjectLabels(req *PutRequest, originalPath string) error {
+	if len(bt.config.ObjectTags) == 0 && len(bt.config.ObjectMetadata) == 0 {
+		return nil
+	}
+
+	vars := bt.templateVars(req.Key, originalPath)
+
+	metadata, err := parseLabelTemplates(bt.config.ObjectMetadata)
+	if err != nil {
+		return err
+	}
+	for _, meta := range metadata {
+		value, err := expandTemplate(meta.Template, vars)
+		if err != nil {
+			return err
+		}
+		req.Metadata[strings.ToLower(meta.Key)] = value
+	}
+
+	tags, err := parseLabelTemplates(bt.config.ObjectTags)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		value, err := expandTemplate(tag.Template, vars)
+		if err != nil {
+			return err
+		}
+		if len(value) > maxTagValueLength {
+			return fmt.Errorf("tag %s value is longer than %d characters: %s", tag.Key, maxTagValueLength, value)
+		}
+		values[tag.Key] = value
+	}
+	req.Tagging = encodeTagging(values)
+	return nil
+}
+
+// encodeTagging encodes tags in the URL query format of the x-amz-tagging header
+func encodeTagging(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = tagEscape(key) + "=" + tagEscape(tags[key])
+	}
+	return strings.Join(pairs, "&")
+}
+
+// tagEscape percent-encodes a tag key or value, encoding spaces as %20
+func tagEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
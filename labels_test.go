@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestValidateObjectLabels tests validation of the -tag and -meta options
+func TestValidateObjectLabels(t *testing.T) {
+	glob := "/var/log/{service}/{service}-YYYYMMDD.log.gz"
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"No labels", Config{}, false},
+		{"Static and template tags", Config{ObjectTags: []string{"env=production", "app={service}", "day={date}"}}, false},
+		{"Metadata", Config{ObjectMetadata: []string{"collector={host}"}}, false},
+		{"Missing equals", Config{ObjectTags: []string{"production"}}, true},
+		{"Empty key", Config{ObjectTags: []string{"=production"}}, true},
+		{"Duplicate key", Config{ObjectTags: []string{"env=a", "env=b"}}, true},
+		{"Unknown variable", Config{ObjectTags: []string{"team={team}"}}, true},
+		{"Reserved metadata", Config{ObjectMetadata: []string{"Source-Host=x"}}, true},
+		{"Too many tags", Config{ObjectTags: []string{"a=1", "b=2", "c=3", "d=4", "e=5", "f=6", "g=7", "h=8", "i=9", "j=10", "k=11"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateObjectLabels(tt.config, glob)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateObjectLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestEncodeTagging tests the x-amz-tagging encoding
+func TestEncodeTagging(t *testing.T) {
+	got := encodeTagging(map[string]string{"env": "prod", "app": "web api", "path": "a/b&c=d"})
+	want := "app=web%20api&env=prod&path=a%2Fb%26c%3Dd"
+	if got != want {
+		t.Errorf("encodeTagging() = %s, want %s", got, want)
+	}
+}
+
+// TestApplyObjectLabels tests expanding tags and metadata for an upload
+func TestApplyObjectLabels(t *testing.T) {
+	captures, err := compileGlobCaptures("/var/log/{service}/{service}-YYYYMMDD.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &BackupTool{
+		config: Config{
+			ObjectTags:     []string{"app={service}", "env=production", "month={year}-{month}"},
+			ObjectMetadata: []string{"Collector={host}", "file={filename}"},
+		},
+		captures: captures,
+	}
+
+	input := &PutRequest{
+		Key:      "logs/nginx-20241215.log.gz",
+		Metadata: map[string]string{"source-host": "web01"},
+	}
+	if err := bt.applyObjectLabels(input, "/var/log/nginx/nginx-20241215.log.gz"); err != nil {
+		t.Fatalf("applyObjectLabels() error = %v", err)
+	}
+
+	if got, want := input.Tagging, "app=nginx&env=production&month=2024-12"; got != want {
+		t.Errorf("Tagging = %s, want %s", got, want)
+	}
+	hostname, _ := os.Hostname()
+	if input.Metadata["collector"] != hostname {
+		t.Errorf("Metadata[collector] = %s, want %s", input.Metadata["collector"], hostname)
+	}
+	if input.Metadata["file"] != "nginx-20241215.log.gz" {
+		t.Errorf("Metadata[file] = %s, want nginx-20241215.log.gz", input.Metadata["file"])
+	}
+	if input.Metadata["source-host"] != "web01" {
+		t.Error("Standard metadata should be kept")
+	}
+
+	// Bundles do not match the glob; placeholders expand to an empty string
+	input = &PutRequest{Key: "logs/web01-20241215.tar.gz", Metadata: map[string]string{}}
+	if err := bt.applyObjectLabels(input, "web01-20241215.tar.gz"); err != nil {
+		t.Fatalf("applyObjectLabels() error = %v", err)
+	}
+	if got, want := input.Tagging, "app=&env=production&month=2024-12"; got != want {
+		t.Errorf("Tagging = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// MaxDatePrefixDays bounds how many days are expanded into individual
+// date-templated prefixes before falling back to listing the static prefix
+const MaxDatePrefixDays = 3660
+
+// archivedObject describes an object found under the backup prefix
+type archivedObject struct {
+	Key          string
+	Size         int64
+	StorageClass string
+	ETag         string
+	LastModified time.Time
+	// Date is extracted from the object's file name, zero if none was found
+	Date time.Time
+}
+
+// staticPrefix returns the part of a prefix template before the first
+// variable, cut back to the last complete path segment
+func staticPrefix(prefix string) string {
+	return prefixBefore(prefix, nil)
+}
+
+// datePrefixes returns the key prefixes to list for a date range. When the
+// prefix is date-templated and either end of the range is set, only the
+// prefixes for days inside the range are returned. A missing end is today,
+// and a missing start is MaxDatePrefixDays before the end. Each prefix is cut
+// at the first variable that is not known per day, such as {hour} or a glob
+// placeholder.
+func datePrefixes(prefix string, from, to time.Time) []string {
+	tmpl, err := parsePrefixTemplate(prefix)
+	if err != nil || !tmpl.usesAny(dateVariables) || (from.IsZero() && to.IsZero()) {
+		return []string{staticPrefix(prefix)}
+	}
+	if to.IsZero() {
+		now := time.Now()
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -MaxDatePrefixDays)
+	}
+	if to.Before(from) || to.Sub(from) > MaxDatePrefixDays*24*time.Hour {
+		return []string{staticPrefix(prefix)}
+	}
+
+	var prefixes []string
+	seen := make(map[string]bool)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		vars := dateVars(day)
+		delete(vars, "hour")
+		processed := tmpl.expandKnown(vars)
+		if !seen[processed] {
+			seen[processed] = true
+			prefixes = append(prefixes, processed)
+		}
+	}
+	return prefixes
+}
+
+// parseDateFlag parses a date given as YYYY-MM-DD or YYYYMMDD. An empty
+// string yields the zero time.
+func parseDateFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s (expected YYYY-MM-DD)", value)
+}
+
+// dateInRange reports whether date falls within [from, to]. Zero bounds are
+// open. Objects without a date only match an unbounded range.
+func dateInRange(date, from, to time.Time) bool {
+	if date.IsZero() {
+		return from.IsZero() && to.IsZero()
+	}
+	if !from.IsZero() && date.Before(from) {
+		return false
+	}
+	if !to.IsZero() && date.After(to) {
+		return false
+	}
+	return true
+}
+
+// isManifestKey reports whether the key is a bundle or run manifest written
+// by this tool rather than an archived log
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, ".manifest.json") || strings.Contains(key, "/_manifests/") || strings.HasPrefix(key, "_manifests/")
+}
+
+// listArchivedObjects lists archived objects under the prefix whose file
+// name matches filter and whose date falls within the range
+func (bt *BackupTool) listArchivedObjects(ctx context.Context, from, to time.Time, filter string) ([]archivedObject, error) {
+	var objects []archivedObject
+	for _, prefix := range datePrefixes(bt.config.S3Prefix, from, to) {
+		listPrefix := prefix
+		if listPrefix != "" && !strings.HasSuffix(listPrefix, "/") {
+			listPrefix += "/"
+		}
+		bt.logger.Debug("Listing", LogFieldKey, bt.objectURL(listPrefix))
+
+		err := bt.store.List(ctx, listPrefix, func(item ObjectInfo) error {
+			if isManifestKey(item.Key) {
+				return nil
+			}
+			name := path.Base(item.Key)
+			if filter != "" {
+				if ok, _ := path.Match(filter, name); !ok {
+					return nil
+				}
+			}
+
+			obj := archivedObject{
+				Key:          item.Key,
+				Size:         item.Size,
+				StorageClass: item.StorageClass,
+				ETag:         item.ETag,
+				LastModified: item.LastModified,
+			}
+			if date, err := extractDateFromFilename(name); err == nil {
+				obj.Date = date
+			}
+			if dateInRange(obj.Date, from, to) {
+				objects = append(objects, obj)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", bt.objectURL(listPrefix), err)
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStaticPrefix tests cutting a prefix before its first date token
+func TestStaticPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"logs", "logs"},
+		{"logs/YYYY/MM/DD", "logs"},
+		{"archive/app/YYYY", "archive/app"},
+		{"YYYY/MM", ""},
+		{"logs/app-YYYY", "logs"},
+		{"logs/{service}/YYYY", "logs"},
+	}
+
+	for _, tt := range tests {
+		if got := staticPrefix(tt.prefix); got != tt.want {
+			t.Errorf("staticPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestDatePrefixes tests expanding a date range into key prefixes
+func TestDatePrefixes(t *testing.T) {
+	from := time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var yearPrefixes []string
+	for year := 2014; year <= 2025; year++ {
+		yearPrefixes = append(yearPrefixes, fmt.Sprintf("logs/%d", year))
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		from   time.Time
+		to     time.Time
+		want   []string
+	}{
+		{
+			name:   "No date tokens",
+			prefix: "logs",
+			from:   from,
+			to:     to,
+			want:   []string{"logs"},
+		},
+		{
+			name:   "Daily prefix",
+			prefix: "logs/YYYY/MM/DD",
+			from:   from,
+			to:     to,
+			want:   []string{"logs/2024/12/30", "logs/2024/12/31", "logs/2025/01/01", "logs/2025/01/02"},
+		},
+		{
+			name:   "Monthly prefix is deduplicated",
+			prefix: "logs/YYYY/MM",
+			from:   from,
+			to:     to,
+			want:   []string{"logs/2024/12", "logs/2025/01"},
+		},
+		{
+			name:   "Placeholders fall back to static prefix",
+			prefix: "logs/{service}/YYYY/MM",
+			from:   from,
+			to:     to,
+			want:   []string{"logs"},
+		},
+		{
+			name:   "Unbounded range falls back to static prefix",
+			prefix: "logs/YYYY/MM/DD",
+			want:   []string{"logs"},
+		},
+		{
+			name:   "Missing end is today",
+			prefix: "logs/YYYY/MM/DD",
+			from:   today.AddDate(0, 0, -2),
+			want: []string{
+				today.AddDate(0, 0, -2).Format("logs/2006/01/02"),
+				today.AddDate(0, 0, -1).Format("logs/2006/01/02"),
+				today.Format("logs/2006/01/02"),
+			},
+		},
+		{
+			name:   "Missing start is capped",
+			prefix: "logs/YYYY",
+			to:     to,
+			want:   yearPrefixes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := datePrefixes(tt.prefix, tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("datePrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDateInRange tests date range filtering
+func TestDateInRange(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 12, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name string
+		date time.Time
+		from time.Time
+		to   time.Time
+		want bool
+	}{
+		{"Unbounded", day(15), time.Time{}, time.Time{}, true},
+		{"Inside range", day(15), day(10), day(20), true},
+		{"On lower bound", day(10), day(10), day(20), true},
+		{"On upper bound", day(20), day(10), day(20), true},
+		{"Before range", day(9), day(10), day(20), false},
+		{"After range", day(21), day(10), day(20), false},
+		{"No date with unbounded range", time.Time{}, time.Time{}, time.Time{}, true},
+		{"No date with range", time.Time{}, day(10), time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := dateInRange(tt.date, tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: dateInRange() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestParseDateFlag tests parsing of date flags
+func TestParseDateFlag(t *testing.T) {
+	want := time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2024-12-15", "20241215"} {
+		got, err := parseDateFlag(value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseDateFlag(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if got, err := parseDateFlag(""); err != nil || !got.IsZero() {
+		t.Errorf("parseDateFlag(\"\") = %v, %v, want zero time", got, err)
+	}
+	if _, err := parseDateFlag("15/12/2024"); err == nil {
+		t.Error("parseDateFlag() should fail for unsupported formats")
+	}
+}
+
+// TestIsManifestKey tests recognizing manifests written by the tool
+func TestIsManifestKey(t *testing.T) {
+	tests := map[string]bool{
+		"logs/2024/12/web01-20241215.tar.gz":                false,
+		"logs/2024/12/web01-20241215.tar.gz.manifest.json":  true,
+		"logs/2024/12/_manifests/web01-20241215T1030Z.json": true,
+		"_manifests/web01-20241215T1030Z.json":              true,
+		"logs/app20241215.log.gz":                           false,
+	}
+	for key, want := range tests {
+		if got := isManifestKey(key); got != want {
+			t.Errorf("isManifestKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const DefaultListConcurrency = 8
+
+// ListOptions holds the options for the ls subcommand
+type ListOptions struct {
+	From        time.Time
+	To          time.Time
+	Filter      string
+	Host        string
+	Format      string
+	Concurrency int
+	Metadata    bool
+}
+
+// listEntry is one row of the ls output
+type listEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	StorageClass string    `json:"storage_class"`
+	LastModified time.Time `json:"last_modified"`
+	FileDate     string    `json:"file_date,omitempty"`
+	SourceHost   string    `json:"source_host,omitempty"`
+	BackupDate   string    `json:"backup_date,omitempty"`
+	OriginalPath string    `json:"original_path,omitempty"`
+}
+
+// parseListFlags parses the arguments of the ls subcommand
+func parseListFlags(args []string) (Config, ListOptions, error) {
+	var config Config
+	var opts ListOptions
+	var from, to string
+
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	fs.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required with -backend s3)")
+	fs.StringVar(&config.S3Prefix, "prefix", "", "S3 prefix template the logs were uploaded with (e.g. logs/{year}/{month}) (required)")
+	fs.StringVar(&config.OutputFile, "output", "", "Output log file path (logs go to stderr if not specified)")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Verbose logging")
+	fs.StringVar(&from, "from", "", "List logs dated on or after this date (YYYY-MM-DD)")
+	fs.StringVar(&to, "to", "", "List logs dated on or before this date (YYYY-MM-DD)")
+	fs.StringVar(&opts.Filter, "filter", "", "Only list files whose name matches this glob (e.g. \"nginx-*\")")
+	fs.StringVar(&opts.Host, "host", "", "Only list objects uploaded from this source host")
+	fs.StringVar(&opts.Format, "format", "table", "Output format (table, json, csv)")
+	fs.IntVar(&opts.Concurrency, "concurrency", DefaultListConcurrency, "Number of parallel metadata requests")
+	fs.BoolVar(&opts.Metadata, "metadata", true, "Read source host and backup date from object metadata (one HEAD request per object)")
+	addLogFlags(fs, &config)
+	addStoreFlags(fs, &config)
+	addAWSFlags(fs, &config)
+	fs.Usage = func() { showListUsage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return config, opts, err
+	}
+
+	var errs []string
+	if err := applyDestination(&config); err != nil {
+		errs = append(errs, err.Error())
+	} else if err := validateStore(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if config.S3Prefix == "" && config.Destination == "" {
+		errs = append(errs, "S3 prefix is required (use -prefix flag or -dest)")
+	} else if _, err := parsePrefixTemplate(config.S3Prefix); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid prefix '%s': %v", config.S3Prefix, err))
+	}
+	switch opts.Format {
+	case "table", "json", "csv":
+	default:
+		errs = append(errs, fmt.Sprintf("invalid format: %s (supported: table, json, csv)", opts.Format))
+	}
+	if opts.Concurrency < 1 {
+		errs = append(errs, "concurrency must be at least 1")
+	}
+	if opts.Host != "" && !opts.Metadata {
+		errs = append(errs, "-host requires -metadata")
+	}
+
+	var err error
+	if opts.From, err = parseDateFlag(from); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if opts.To, err = parseDateFlag(to); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && opts.To.Before(opts.From) {
+		errs = append(errs, "-to must not be before -from")
+	}
+
+	if len(errs) > 0 {
+		return config, opts, errors.New(strings.Join(errs, "\n"))
+	}
+	return config, opts, nil
+}
+
+func showListUsage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `Usage: %s ls [OPTIONS]
+
+Lists archived objects. With a date-templated prefix and -from or -to, only
+the YYYY/MM/DD key prefixes inside the range are listed. A missing -to is
+today, and a missing -from is 3660 days before -to.
+
+OPTIONS:
+`, os.Args[0])
+	fs.PrintDefaults()
+	fmt.Fprintf(fs.Output(), `
+EXAMPLES:
+  %s ls -bucket my-logs -prefix "logs/YYYY/MM/DD" -from 2024-12-01 -to 2024-12-07
+  %s ls -bucket my-logs -prefix logs -host web01 -format csv
+`, os.Args[0], os.Args[0])
+}
+
+// runList is the entry point of the ls subcommand
+func runList(args []string) error {
+	config, opts, err := parseListFlags(args)
+	if err != nil {
+		return &configError{err}
+	}
+
+	logger, err := newReportLogger(config)
+	if err != nil {
+		return err
+	}
+	bt := &BackupTool{config: config, logger: logger}
+
+	ctx := context.Background()
+	if err := bt.initStore(ctx); err != nil {
+		return err
+	}
+	defer bt.closeStore()
+
+	entries, err := bt.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return writeListEntries(os.Stdout, entries, opts.Format)
+}
+
+// List returns the archived objects matching the options
+func (bt *BackupTool) List(ctx context.Context, opts ListOptions) ([]listEntry, error) {
+	objects, err := bt.listArchivedObjects(ctx, opts.From, opts.To, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listEntry, len(objects))
+	for i, obj := range objects {
+		entries[i] = listEntry{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			StorageClass: obj.StorageClass,
+			LastModified: obj.LastModified.UTC(),
+		}
+		if !obj.Date.IsZero() {
+			entries[i].FileDate = obj.Date.Format("2006-01-02")
+		}
+	}
+	if !opts.Metadata {
+		return entries, nil
+	}
+
+	if err := bt.fillListMetadata(ctx, entries, opts.Concurrency); err != nil {
+		return nil, err
+	}
+	if opts.Host == "" {
+		return entries, nil
+	}
+
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if entry.SourceHost == opts.Host {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
+
+// fillListMetadata reads the backup metadata of each entry in parallel
+func (bt *BackupTool) fillListMetadata(ctx context.Context, entries []listEntry, concurrency int) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	indexes := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				head, err := bt.store.Head(ctx, entries[i].Key)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to read metadata of %s: %w", entries[i].Key, err)
+					}
+					mu.Unlock()
+					continue
+				}
+				entries[i].SourceHost = head.Metadata["source-host"]
+				entries[i].BackupDate = head.Metadata["backup-date"]
+				entries[i].OriginalPath = head.Metadata["original-path"]
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return firstErr
+}
+
+// writeListEntries writes the entries as a table, JSON or CSV
+func writeListEntries(w io.Writer, entries []listEntry, format string) error {
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"key", "size", "storage_class", "last_modified", "file_date", "source_host", "backup_date", "original_path"}); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			record := []string{
+				entry.Key,
+				strconv.FormatInt(entry.Size, 10),
+				entry.StorageClass,
+				entry.LastModified.Format(time.RFC3339),
+				entry.FileDate,
+				entry.SourceHost,
+				entry.BackupDate,
+				entry.OriginalPath,
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tSIZE\tSTORAGE CLASS\tFILE DATE\tSOURCE HOST\tBACKUP DATE")
+		var total int64
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", entry.Key, entry.Size, entry.StorageClass, entry.FileDate, entry.SourceHost, entry.BackupDate)
+			total += entry.Size
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n%d objects, %d bytes\n", len(entries), total)
+		return nil
+	}
+}
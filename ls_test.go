@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseListFlags tests parsing and validation of ls options
+func TestParseListFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{
+			name: "Valid options",
+			args: []string{"-bucket", "my-logs", "-prefix", "logs/YYYY/MM/DD", "-from", "2024-12-01", "-to", "2024-12-07", "-format", "csv"},
+		},
+		{
+			name:    "Missing bucket",
+			args:    []string{"-prefix", "logs"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid format",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-format", "yaml"},
+			wantErr: true,
+		},
+		{
+			name: "Destination URL",
+			args: []string{"-dest", "s3://my-logs/logs/{year}/{month}?region=ap-northeast-1"},
+		},
+		{
+			name:    "Destination URL with -bucket",
+			args:    []string{"-dest", "s3://my-logs/logs", "-bucket", "other-logs"},
+			wantErr: true,
+		},
+		{
+			name:    "Host filter without metadata",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-host", "web01", "-metadata=false"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseListFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseListFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestWriteListEntries tests table, JSON and CSV output
+func TestWriteListEntries(t *testing.T) {
+	entries := []listEntry{
+		{
+			Key:          "logs/2024/12/15/app20241215.log.gz",
+			Size:         1024,
+			StorageClass: "STANDARD_IA",
+			LastModified: time.Date(2024, 12, 16, 1, 0, 0, 0, time.UTC),
+			FileDate:     "2024-12-15",
+			SourceHost:   "web01",
+			BackupDate:   "2024-12-16T01:00:00Z",
+			OriginalPath: "/var/log/app20241215.log.gz",
+		},
+	}
+
+	var table bytes.Buffer
+	if err := writeListEntries(&table, entries, "table"); err != nil {
+		t.Fatalf("writeListEntries(table) error = %v", err)
+	}
+	for _, want := range []string{"STORAGE CLASS", "STANDARD_IA", "web01", "1 objects, 1024 bytes"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("Expected table to contain %q, got: %s", want, table.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeListEntries(&out, entries, "json"); err != nil {
+		t.Fatalf("writeListEntries(json) error = %v", err)
+	}
+	var decoded []listEntry
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON output: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].SourceHost != "web01" {
+		t.Errorf("Decoded entries = %+v", decoded)
+	}
+
+	var csvOut bytes.Buffer
+	if err := writeListEntries(&csvOut, entries, "csv"); err != nil {
+		t.Fatalf("writeListEntries(csv) error = %v", err)
+	}
+	records, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV output: %v", err)
+	}
+	if len(records) != 2 || records[1][0] != entries[0].Key || records[1][1] != "1024" {
+		t.Errorf("CSV records = %v", records)
+	}
+
+	var empty bytes.Buffer
+	if err := writeListEntries(&empty, nil, "json"); err != nil {
+		t.Fatalf("writeListEntries(json) error = %v", err)
+	}
+	if strings.TrimSpace(empty.String()) != "[]" {
+		t.Errorf("Expected empty JSON array, got %q", empty.String())
+	}
+}
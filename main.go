@@ -3,10 +3,11 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -17,7 +18,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -43,6 +46,8 @@ type Config struct {
 	Period           string
 	DryRun           bool
 	Verbose          bool
+	LogFormat        string
+	LogLevel         string
 	DeleteAfterUpload bool
 	Help             bool
 	Version          bool
@@ -53,6 +58,87 @@ type Config struct {
 	CABundle         string
 	CLIReadTimeout   int
 	CLIConnectTimeout int
+	// Bundle options
+	Bundle            string
+	BundleCompression string
+	// Manifest options
+	Manifest bool
+	// Local file the run report is written to
+	Report string
+	// Prometheus metrics options
+	MetricsTextfile string
+	MetricsPushURL  string
+	// OpenTelemetry tracing options
+	TraceExporter string
+	TraceEndpoint string
+	TraceFile     string
+	// Upload throttling options
+	BandwidthLimit string
+	// DestinationBandwidth is the bwlimit parameter of the destination URL
+	DestinationBandwidth string
+	UploadWindows        []string
+	// In-use file checks
+	SkipOpen        bool
+	StabilityWindow time.Duration
+	// Resource options
+	Nice        int
+	IONice      string
+	BufferSize  string
+	MemoryLimit string
+	// Hook commands run before and after the run and each upload and delete
+	PreRunHook     string
+	PostRunHook    string
+	PreUploadHook  string
+	PostUploadHook string
+	PreDeleteHook  string
+	PostDeleteHook string
+	HookTimeout    time.Duration
+	// Notification options
+	NotifyOn              string
+	NotifyWebhook         string
+	NotifyWebhookTemplate string
+	NotifySlack           string
+	NotifyEmail           []string
+	NotifyErrors          int
+	NotifyInterval        time.Duration
+	NotifyState           string
+	SMTPServer            string
+	SMTPFrom              string
+	SMTPUser              string
+	// Local retention period; files are deleted once older and confirmed in S3
+	Retention string
+	// Object Lock options
+	ObjectLockMode   string
+	ObjectLockRetain string
+	ObjectLockFrom   string
+	LegalHold        bool
+	// ObjectLockAllowExpired uploads files whose retention already expired
+	// without a lock instead of failing them
+	ObjectLockAllowExpired bool
+	// Object tag and user metadata templates (key=template)
+	ObjectTags     []string
+	ObjectMetadata []string
+	// Job name available to templates as {job}
+	JobName string
+	// Layout preset and partition registration output
+	Layout           string
+	PartitionsOutput string
+	PartitionsFormat string
+	AthenaTable      string
+	// Storage backend options; Destination is a URL that sets the backend,
+	// bucket and prefix in one go
+	Destination    string
+	Backend        string
+	LocalDir       string
+	SFTPHost       string
+	SFTPUser       string
+	SFTPKey        string
+	SFTPKnownHosts string
+	SFTPDir        string
+	GCSCredentials string
+	AzureAccount   string
+	// Additional destination URLs every file is replicated to
+	Replicas []string
 }
 
 // Stats holds the statistics for the backup operation
@@ -62,21 +148,92 @@ type Stats struct {
 	Deleted    int
 	Errors     int
 	Skipped    int
+	// Local retention statistics
+	AlreadyArchived int
+	Retained        int
+	// Per-destination results when files are replicated
+	Destinations []DestinationStats `json:",omitempty"`
 }
 
 // BackupTool represents the main backup tool
 type BackupTool struct {
-	config      Config
-	s3Client    *s3.Client
-	logger      *log.Logger
-	stats       Stats
-	lockFile    *os.File
-	cutoffTime  time.Time
+	config          Config
+	store           ObjectStore
+	logger          *slog.Logger
+	stats           Stats
+	lockFile        *os.File
+	cutoffTime      time.Time
+	retentionCutoff time.Time
+	startTime       time.Time
+	results         []*fileResult
+	metrics         *runMetrics
+	// Upload throttling: the global and per-destination bandwidth limits
+	// and the daily upload windows
+	bandwidth     *rate.Limiter
+	destBandwidth *rate.Limiter
+	uploadWindows []uploadWindow
+	captures        *globCaptures
+	replicas        []*replica
 }
 
 // NewBackupTool creates a new backup tool instance
 func NewBackupTool(config Config) (*BackupTool, error) {
-	// Setup logger
+	logger, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate cutoff time based on period
+	cutoffTime, err := calculateCutoffTime(config.Period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period '%s': %w", config.Period, err)
+	}
+
+	// Calculate local retention cutoff if a retention period is set
+	var retentionCutoff time.Time
+	if config.Retention != "" {
+		if err := validateRetention(config); err != nil {
+			return nil, err
+		}
+		retentionCutoff, err = calculateCutoffTime(config.Retention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention '%s': %w", config.Retention, err)
+		}
+	}
+
+	bt := &BackupTool{
+		config:          config,
+		logger:          logger,
+		cutoffTime:      cutoffTime,
+		retentionCutoff: retentionCutoff,
+		metrics:         newRunMetrics(config.JobName),
+	}
+	if bt.bandwidth, err = newBandwidthLimiter(config.BandwidthLimit); err != nil {
+		return nil, fmt.Errorf("invalid -bwlimit: %w", err)
+	}
+	if bt.destBandwidth, err = newBandwidthLimiter(config.DestinationBandwidth); err != nil {
+		return nil, fmt.Errorf("invalid bwlimit in destination: %w", err)
+	}
+	if bt.uploadWindows, err = parseUploadWindows(config.UploadWindows); err != nil {
+		return nil, err
+	}
+	if err := bt.newReplicas(); err != nil {
+		return nil, err
+	}
+	return bt, nil
+}
+
+// consoleOutput returns where log lines and other diagnostics go on the
+// console: stdout, or stderr when stdout carries the partitions of the run
+func consoleOutput(config Config) io.Writer {
+	if config.PartitionsOutput == "-" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// newLogger creates the logger writing to the output file and/or the console
+func newLogger(config Config) (*slog.Logger, error) {
 	var logWriter io.Writer
 	if config.OutputFile != "" {
 		logFile, err := os.OpenFile(config.OutputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
@@ -84,27 +241,31 @@ func NewBackupTool(config Config) (*BackupTool, error) {
 			return nil, fmt.Errorf("failed to open log file: %w", err)
 		}
 		if config.Verbose {
-			logWriter = io.MultiWriter(os.Stdout, logFile)
+			logWriter = io.MultiWriter(consoleOutput(config), logFile)
 		} else {
 			logWriter = logFile
 		}
 	} else {
-		logWriter = os.Stdout
+		logWriter = consoleOutput(config)
 	}
 
-	logger := log.New(logWriter, "", log.LstdFlags)
-
-	// Calculate cutoff time based on period
-	cutoffTime, err := calculateCutoffTime(config.Period)
+	handler, err := newLogHandler(logWriter, config)
 	if err != nil {
-		return nil, fmt.Errorf("invalid period '%s': %w", config.Period, err)
+		return nil, err
 	}
+	return slog.New(handler), nil
+}
 
-	return &BackupTool{
-		config:     config,
-		logger:     logger,
-		cutoffTime: cutoffTime,
-	}, nil
+// newStartupFailureTool returns a tool with no files for reporting a run
+// that failed before NewBackupTool returned, so that the metrics and
+// notifications still see the failure
+func newStartupFailureTool(config Config) *BackupTool {
+	logger, err := newLogger(config)
+	if err != nil {
+		// The log file may be what failed
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+	return &BackupTool{config: config, logger: logger, metrics: newRunMetrics(config.JobName)}
 }
 
 // parsePeriod parses a period string like "1 day", "7 days", "1 month" etc.
@@ -181,29 +342,29 @@ func (bt *BackupTool) initAWS(ctx context.Context) error {
 		configFile := filepath.Join(homeDir, ".aws", "config")
 		credentialsFile := filepath.Join(homeDir, ".aws", "credentials")
 		
-		bt.logger.Printf("Checking AWS config files in: %s (homeDir: %s)", filepath.Join(homeDir, ".aws"), homeDir)
+		bt.logger.Debug("Checking AWS config files", "dir", filepath.Join(homeDir, ".aws"))
 		
 		if _, err := os.Stat(configFile); err == nil {
 			loadOptions = append(loadOptions, config.WithSharedConfigFiles([]string{configFile}))
-			bt.logger.Printf("Found AWS config file: %s", configFile)
+			bt.logger.Debug("Found AWS config file", LogFieldFile, configFile)
 		} else {
-			bt.logger.Printf("AWS config file not found: %s (error: %v)", configFile, err)
+			bt.logger.Debug("AWS config file not found", LogFieldFile, configFile, errAttr(err))
 		}
 		
 		if _, err := os.Stat(credentialsFile); err == nil {
 			loadOptions = append(loadOptions, config.WithSharedCredentialsFiles([]string{credentialsFile}))
-			bt.logger.Printf("Found AWS credentials file: %s", credentialsFile)
+			bt.logger.Debug("Found AWS credentials file", LogFieldFile, credentialsFile)
 		} else {
-			bt.logger.Printf("AWS credentials file not found: %s (error: %v)", credentialsFile, err)
+			bt.logger.Debug("AWS credentials file not found", LogFieldFile, credentialsFile, errAttr(err))
 		}
 	} else {
-		bt.logger.Printf("Failed to get home directory: %v", err)
+		bt.logger.Warn("Failed to get home directory", errAttr(err))
 	}
 	
 	// Add profile if specified
 	if bt.config.Profile != "" {
 		loadOptions = append(loadOptions, config.WithSharedConfigProfile(bt.config.Profile))
-		bt.logger.Printf("Using AWS profile: %s", bt.config.Profile)
+		bt.logger.Info("Using AWS profile", "profile", bt.config.Profile)
 	}
 	
 	// Load config
@@ -216,7 +377,7 @@ func (bt *BackupTool) initAWS(ctx context.Context) error {
 	if cfg.Region == "" {
 		return fmt.Errorf("AWS region is not set. Please specify -region flag or set AWS_DEFAULT_REGION environment variable")
 	}
-	bt.logger.Printf("AWS region loaded: %s", cfg.Region)
+	bt.logger.Info("AWS region loaded", "region", cfg.Region)
 	
 	// Create custom HTTP client if needed
 	if bt.config.NoVerifySSL || bt.config.CABundle != "" || bt.config.CLIReadTimeout > 0 || bt.config.CLIConnectTimeout > 0 {
@@ -237,8 +398,10 @@ func (bt *BackupTool) initAWS(ctx context.Context) error {
 		cfg.HTTPClient = httpClient
 	}
 	
-	// Create S3 client options
-	s3Options := []func(*s3.Options){}
+	// Create S3 client options, tracing each API call
+	s3Options := []func(*s3.Options){func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, addS3Tracing)
+	}}
 	
 	// Add endpoint URL if specified
 	if bt.config.EndpointURL != "" {
@@ -249,17 +412,22 @@ func (bt *BackupTool) initAWS(ctx context.Context) error {
 		})
 	}
 
-	bt.s3Client = s3.NewFromConfig(cfg, s3Options...)
+	store := newS3Store(s3.NewFromConfig(cfg, s3Options...), bt.config.S3Bucket)
+	bt.store = store
 
 	// Test S3 access
-	_, err = bt.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(bt.config.S3Bucket),
-	})
-	if err != nil {
-		return fmt.Errorf("cannot access S3 bucket %s: %w", bt.config.S3Bucket, err)
+	if err := store.Check(ctx); err != nil {
+		return err
+	}
+
+	// Object Lock settings are rejected unless the bucket was created with it
+	if bt.config.ObjectLockMode != "" || bt.config.LegalHold {
+		if err := store.checkObjectLockEnabled(ctx); err != nil {
+			return err
+		}
 	}
 
-	bt.logger.Printf("AWS S3 client initialized successfully")
+	bt.logger.Info("AWS S3 client initialized", "bucket", bt.config.S3Bucket)
 	return nil
 }
 
@@ -331,7 +499,7 @@ func (bt *BackupTool) acquireLock() error {
 	lockFile, err := os.OpenFile(bt.config.LockFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
 	if err != nil {
 		if os.IsExist(err) {
-			return fmt.Errorf("another instance is already running (lock file exists: %s)", bt.config.LockFile)
+			return fmt.Errorf("%w (lock file exists: %s)", errLocked, bt.config.LockFile)
 		}
 		return fmt.Errorf("failed to create lock file: %w", err)
 	}
@@ -409,18 +577,6 @@ func extractDateFromFilename(filename string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("no date pattern found in filename: %s", filename)
 }
 
-// processPrefixWithDate processes a prefix that may contain date format tokens
-// Returns the processed prefix with date values substituted
-func processPrefixWithDate(prefix string, date time.Time) string {
-	// Replace date format tokens with actual date values
-	result := prefix
-	result = strings.ReplaceAll(result, "YYYY", date.Format("2006"))
-	result = strings.ReplaceAll(result, "MM", date.Format("01"))
-	result = strings.ReplaceAll(result, "DD", date.Format("02"))
-	
-	return result
-}
-
 // convertGlobPattern converts user glob pattern to actual pattern
 func convertGlobPattern(pattern string) string {
 	// Replace YYYY/MM/DD with wildcard first (longest pattern first)
@@ -431,17 +587,25 @@ func convertGlobPattern(pattern string) string {
 	pattern = strings.ReplaceAll(pattern, "YYYY_MM_DD", "*")
 	// Replace YYYYMMDD with wildcard
 	pattern = strings.ReplaceAll(pattern, "YYYYMMDD", "*")
+	// Replace {name} placeholders with wildcard
+	pattern = placeholderPattern.ReplaceAllString(pattern, "*")
 	return pattern
 }
 
 // findTargetFiles finds files matching the glob pattern and cutoff time
 func (bt *BackupTool) findTargetFiles(globPattern string) ([]string, error) {
-	bt.logger.Printf("Searching for files matching pattern: %s", globPattern)
-	bt.logger.Printf("Cutoff time: %s", bt.cutoffTime.Format("2006-01-02 15:04:05"))
+	bt.logger.Info("Searching for files", "pattern", globPattern, "cutoff", bt.cutoffTime.Format("2006-01-02 15:04:05"))
 
 	// Convert glob pattern
 	searchPattern := convertGlobPattern(globPattern)
-	bt.logger.Printf("Converted search pattern: %s", searchPattern)
+	bt.logger.Debug("Converted search pattern", "pattern", searchPattern)
+
+	// Compile the {name} placeholder matcher, if any
+	captures, err := compileGlobCaptures(globPattern)
+	if err != nil {
+		return nil, err
+	}
+	bt.captures = captures
 
 	// Find files using glob
 	matches, err := filepath.Glob(searchPattern)
@@ -456,10 +620,19 @@ func (bt *BackupTool) findTargetFiles(globPattern string) ([]string, error) {
 			continue
 		}
 
+		// A placeholder repeated in the pattern must match the same value
+		if bt.captures != nil {
+			if _, ok := bt.captures.match(file); !ok {
+				bt.logger.Info("File skipped", LogFieldFile, file, "reason", "placeholders do not match")
+				bt.stats.Skipped++
+				continue
+			}
+		}
+
 		filename := filepath.Base(file)
 		fileDate, err := extractDateFromFilename(filename)
 		if err != nil {
-			bt.logger.Printf("Could not extract date from filename: %s (%v)", file, err)
+			bt.logger.Warn("Could not extract date from filename", LogFieldFile, file, errAttr(err))
 			bt.stats.Skipped++
 			continue
 		}
@@ -470,9 +643,9 @@ func (bt *BackupTool) findTargetFiles(globPattern string) ([]string, error) {
 		// Files older than cutoff date should be included
 		if fileDateNormalized.Before(bt.cutoffTime) {
 			targetFiles = append(targetFiles, file)
-			bt.logger.Printf("Target file found: %s (date: %s)", file, fileDate.Format("2006-01-02"))
+			bt.logger.Debug("Target file found", LogFieldFile, file, "date", fileDate.Format("2006-01-02"))
 		} else {
-			bt.logger.Printf("File skipped (too recent): %s (date: %s)", file, fileDate.Format("2006-01-02"))
+			bt.logger.Debug("File skipped", LogFieldFile, file, "reason", "too recent", "date", fileDate.Format("2006-01-02"))
 			bt.stats.Skipped++
 		}
 	}
@@ -486,68 +659,123 @@ func printError(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, ColorRed+"Error: "+format+ColorReset+"\n", args...)
 }
 
-// uploadToS3 uploads a file to S3
-func (bt *BackupTool) uploadToS3(ctx context.Context, filePath string) error {
+// objectKey builds the S3 key for a file
+func (bt *BackupTool) objectKey(filePath string) (string, error) {
+	prefix, err := bt.objectPrefix(filePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s", prefix, filepath.Base(filePath)), nil
+}
+
+// objectPrefix expands the prefix template for a file, using the date
+// extracted from the file name and the values the glob pattern matched
+func (bt *BackupTool) objectPrefix(filePath string) (string, error) {
+	tmpl, err := parsePrefixTemplate(bt.config.S3Prefix)
+	if err != nil {
+		return "", fmt.Errorf("invalid prefix '%s': %w", bt.config.S3Prefix, err)
+	}
+
 	filename := filepath.Base(filePath)
-	
-	// Generate S3 key with optional date-based directory structure in prefix
-	var s3Key string
-	if strings.Contains(bt.config.S3Prefix, "YYYY") || strings.Contains(bt.config.S3Prefix, "MM") || strings.Contains(bt.config.S3Prefix, "DD") {
-		// Extract date from filename
-		fileDate, err := extractDateFromFilename(filename)
-		if err != nil {
-			return fmt.Errorf("failed to extract date from filename %s for date-based prefix: %w", filename, err)
+	if tmpl.usesAny(dateVariables) {
+		if _, err := extractDateFromFilename(filename); err != nil {
+			return "", fmt.Errorf("failed to extract date from filename %s for date-based prefix: %w", filename, err)
 		}
-		
-		// Process prefix with date substitution
-		processedPrefix := processPrefixWithDate(bt.config.S3Prefix, fileDate)
-		s3Key = fmt.Sprintf("%s/%s", processedPrefix, filename)
-	} else {
-		s3Key = fmt.Sprintf("%s/%s", bt.config.S3Prefix, filename)
 	}
 
-	bt.logger.Printf("Uploading: %s -> s3://%s/%s", filePath, bt.config.S3Bucket, s3Key)
+	prefix, err := tmpl.expand(bt.templateVars("", filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to expand prefix for %s: %w", filePath, err)
+	}
+	return prefix, nil
+}
+
+// uploadToS3 uploads a file to S3 and returns the ETag of the stored object
+func (bt *BackupTool) uploadToS3(ctx context.Context, filePath string) (string, error) {
+	// Generate S3 key with optional date-based directory structure in prefix
+	s3Key, err := bt.objectKey(filePath)
+	if err != nil {
+		return "", err
+	}
 
 	if bt.config.DryRun {
-		bt.logger.Printf("DRY RUN: Would upload %s to s3://%s/%s", filePath, bt.config.S3Bucket, s3Key)
-		return nil
+		bt.logger.Info("DRY RUN: Would upload", LogFieldFile, filePath, LogFieldKey, bt.objectURL(s3Key))
+		return "", nil
 	}
+	bt.logger.Debug("Uploading", LogFieldFile, filePath, LogFieldKey, bt.objectURL(s3Key))
+	started := time.Now()
 
 	// Open file
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", filePath, err)
+		return "", fmt.Errorf("failed to open file %s: %w", filePath, err)
 	}
 	defer file.Close()
 
+	etag, err := bt.putObject(ctx, s3Key, file, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	bt.metrics.recordUpload(1, size)
+	bt.logger.Info("Upload successful", LogFieldFile, filePath, LogFieldKey, bt.objectURL(s3Key), LogFieldBytes, size, durationAttr(time.Since(started)))
+	return etag, nil
+}
+
+// putObject uploads a log file or bundle to the given key with the standard
+// backup metadata and the configured tags and user metadata, returning the
+// ETag of the stored object
+func (bt *BackupTool) putObject(ctx context.Context, s3Key string, body io.Reader, originalPath string) (string, error) {
+	req, err := bt.newPutRequest(s3Key, bt.throttle(ctx, body), originalPath, bt.config.StorageClass)
+	if err != nil {
+		return "", err
+	}
+	if err := bt.applyObjectLabels(req, originalPath); err != nil {
+		return "", err
+	}
+	return bt.store.Put(ctx, req)
+}
+
+// putObjectWithClass uploads body to the given key using a specific storage class
+func (bt *BackupTool) putObjectWithClass(ctx context.Context, s3Key string, body io.Reader, originalPath, storageClass string) error {
+	req, err := bt.newPutRequest(s3Key, body, originalPath, storageClass)
+	if err != nil {
+		return err
+	}
+	_, err = bt.store.Put(ctx, req)
+	return err
+}
+
+// newPutRequest builds the upload request with the standard backup metadata
+// and Object Lock settings
+func (bt *BackupTool) newPutRequest(s3Key string, body io.Reader, originalPath, storageClass string) (*PutRequest, error) {
 	// Get hostname
 	hostname, _ := os.Hostname()
 
-	// Upload to S3
-	_, err = bt.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:       aws.String(bt.config.S3Bucket),
-		Key:          aws.String(s3Key),
-		Body:         file,
-		StorageClass: types.StorageClass(bt.config.StorageClass),
+	req := &PutRequest{
+		Key:          s3Key,
+		Body:         body,
+		StorageClass: storageClass,
 		Metadata: map[string]string{
 			"source-host":   hostname,
 			"backup-date":   time.Now().UTC().Format(time.RFC3339),
-			"original-path": filePath,
+			"original-path": originalPath,
 		},
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to upload to S3: %w", err)
 	}
-
-	bt.logger.Printf("Upload successful: s3://%s/%s", bt.config.S3Bucket, s3Key)
-	return nil
+	if err := bt.applyObjectLock(req, originalPath); err != nil {
+		return nil, err
+	}
+	return req, nil
 }
 
 // deleteLocalFile deletes the local file
 func (bt *BackupTool) deleteLocalFile(filePath string) error {
 	if bt.config.DryRun {
-		bt.logger.Printf("DRY RUN: Would delete %s", filePath)
+		bt.logger.Info("DRY RUN: Would delete", LogFieldFile, filePath)
 		return nil
 	}
 
@@ -556,66 +784,141 @@ func (bt *BackupTool) deleteLocalFile(filePath string) error {
 		return fmt.Errorf("failed to delete local file %s: %w", filePath, err)
 	}
 
-	bt.logger.Printf("Local file deleted: %s", filePath)
+	bt.logger.Info("Local file deleted", LogFieldFile, filePath)
 	return nil
 }
 
 // processFiles processes the found files
 func (bt *BackupTool) processFiles(ctx context.Context, files []string) error {
+	files, err := bt.filterInUseFiles(ctx, files)
+	if err != nil {
+		return err
+	}
+
+	if bt.config.Bundle != "" {
+		return bt.processBundles(ctx, files)
+	}
+	if bt.config.Retention != "" {
+		return bt.processFilesWithRetention(ctx, files)
+	}
+
 	for _, file := range files {
-		// Double-check file still exists
-		if _, err := os.Stat(file); err != nil {
-			bt.logger.Printf("File not found (may have been processed): %s", file)
-			bt.stats.Skipped++
-			continue
-		}
+		fileCtx, span := startFileSpan(ctx, file, bt.config.StorageClass)
+		endFileSpan(span, bt.processFile(fileCtx, file))
+	}
 
-		// Upload to S3
-		if err := bt.uploadToS3(ctx, file); err != nil {
-			bt.logger.Printf("Upload failed: %s (%v)", file, err)
-			bt.stats.Errors++
-			continue
-		}
-		bt.stats.Uploaded++
+	return nil
+}
 
-		// Delete local file only if delete option is enabled
-		if bt.config.DeleteAfterUpload {
-			if err := bt.deleteLocalFile(file); err != nil {
-				bt.logger.Printf("Delete failed: %s (%v)", file, err)
-				bt.stats.Errors++
-				continue
+// processFile uploads a single file and deletes it if requested
+func (bt *BackupTool) processFile(ctx context.Context, file string) *fileResult {
+	// Double-check file still exists
+	if _, err := os.Stat(file); err != nil {
+		bt.logger.Warn("File not found (may have been processed)", LogFieldFile, file)
+		bt.stats.Skipped++
+		result := &fileResult{Path: file, Outcome: OutcomeSkipped, Error: err.Error(), ErrorClass: classifyError(err)}
+		bt.results = append(bt.results, result)
+		return result
+	}
+
+	result := bt.newFileResult(ctx, file)
+	result.Key, _ = bt.objectKey(file)
+	if err := bt.waitForUploadWindow(ctx); err != nil {
+		bt.stats.Errors++
+		result.fail(err)
+		return result
+	}
+	if !bt.beforeUpload(ctx, result) {
+		return result
+	}
+
+	// Upload to S3 and any replicas
+	started := time.Now()
+	etag, err := bt.uploadToDestinations(ctx, file)
+	result.Duration = seconds(time.Since(started))
+	if err != nil {
+		bt.logger.Error("Upload failed", LogFieldFile, file, errAttr(err))
+		bt.stats.Errors++
+		result.fail(err)
+		bt.afterUpload(ctx, result, err)
+		return result
+	}
+	bt.stats.Uploaded++
+	result.Outcome = bt.uploadedOutcome()
+	result.ETag = etag
+	if !bt.afterUpload(ctx, result, nil) {
+		return result
+	}
+
+	// Delete local file only if delete option is enabled
+	if bt.config.DeleteAfterUpload {
+		if err := bt.deleteArchivedFile(ctx, result); err != nil {
+			if errors.Is(err, errHookVeto) {
+				return result
 			}
-			bt.stats.Deleted++
+			bt.logger.Error("Delete failed", LogFieldFile, file, errAttr(err))
+			bt.stats.Errors++
+			result.setError(err)
+			return result
 		}
+		bt.stats.Deleted++
+		result.Deleted = !bt.config.DryRun
 	}
-
-	return nil
+	return result
 }
 
 // logSummary logs the summary statistics
 func (bt *BackupTool) logSummary(globPattern string) {
-	bt.logger.Printf("=== Backup Summary ===")
-	bt.logger.Printf("Glob pattern: %s", globPattern)
-	bt.logger.Printf("Cutoff time: %s", bt.cutoffTime.Format("2006-01-02 15:04:05"))
-	bt.logger.Printf("Total files: %d", bt.stats.TotalFiles)
-	bt.logger.Printf("Uploaded: %d", bt.stats.Uploaded)
-	bt.logger.Printf("Deleted: %d", bt.stats.Deleted)
-	bt.logger.Printf("Skipped: %d", bt.stats.Skipped)
-	bt.logger.Printf("Errors: %d", bt.stats.Errors)
+	attrs := []any{
+		"pattern", globPattern,
+		"cutoff", bt.cutoffTime.Format("2006-01-02 15:04:05"),
+		"total_files", bt.stats.TotalFiles,
+		"uploaded", bt.stats.Uploaded,
+		"deleted", bt.stats.Deleted,
+		"skipped", bt.stats.Skipped,
+	}
+	if bt.config.Retention != "" {
+		attrs = append(attrs, "already_archived", bt.stats.AlreadyArchived, "retained", bt.stats.Retained)
+	}
+	attrs = append(attrs, "errors", bt.stats.Errors)
+	bt.logger.Info("Backup summary", attrs...)
+	bt.logDestinationSummary()
 }
 
-// Run executes the backup process
-func (bt *BackupTool) Run(ctx context.Context, globPattern string) error {
-	bt.logger.Printf("=== Log backup process started ===")
-	bt.logger.Printf("Glob pattern: %s", globPattern)
-
-	// Validate glob pattern
+// validateGlobPattern checks that the glob pattern contains a date format
+func validateGlobPattern(globPattern string) error {
 	if !strings.Contains(globPattern, "YYYYMMDD") &&
 		!strings.Contains(globPattern, "YYYY-MM-DD") &&
 		!strings.Contains(globPattern, "YYYY/MM/DD") &&
 		!strings.Contains(globPattern, "YYYY_MM_DD") {
 		return fmt.Errorf("invalid glob pattern. Must contain 'YYYYMMDD', 'YYYY-MM-DD', 'YYYY/MM/DD', or 'YYYY_MM_DD'\n\nExamples:\n  *YYYYMMDD.log.gz           - Matches app20241215.log.gz\n  YYYY-MM-DD.gz              - Matches 2024-12-15.gz\n  YYYY/MM/DD.gz              - Matches 2024/12/15.gz\n  YYYY_MM_DD.gz              - Matches 2024_12_15.gz\n  /var/log/app*YYYYMMDD.gz   - Matches /var/log/app20241215.gz\n  nginx-YYYY-MM-DD.log.gz    - Matches nginx-2024-12-15.log.gz\n  access_YYYY/MM/DD.log.gz   - Matches access_2024/12/15.log.gz")
 	}
+	return nil
+}
+
+// Run executes the backup process
+func (bt *BackupTool) Run(ctx context.Context, globPattern string) (err error) {
+	ctx, span := tracer().Start(ctx, "backup.run", trace.WithAttributes(
+		attribute.String("backup.pattern", globPattern),
+		attribute.String("backup.period", bt.config.Period),
+		attribute.String("backup.destination", bt.destinationName()),
+		attribute.String(TraceAttrStorageClass, bt.config.StorageClass),
+	))
+	defer func() {
+		endSpan(span, err,
+			attribute.Int("backup.files", bt.stats.TotalFiles),
+			attribute.Int("backup.uploaded", bt.stats.Uploaded),
+			attribute.Int("backup.errors", bt.stats.Errors),
+		)
+	}()
+
+	bt.startTime = time.Now()
+	bt.logger.Info("Log backup process started", "pattern", globPattern, "version", Version)
+
+	// Validate glob pattern
+	if err := validateGlobPattern(globPattern); err != nil {
+		return &configError{err}
+	}
 
 	// Acquire lock
 	if err := bt.acquireLock(); err != nil {
@@ -623,66 +926,190 @@ func (bt *BackupTool) Run(ctx context.Context, globPattern string) error {
 	}
 	defer bt.releaseLock()
 
-	// Initialize AWS
-	if err := bt.initAWS(ctx); err != nil {
+	// Run the hooks around the run; a failed pre-run hook stops the run
+	defer func() {
+		bt.runRunHook(ctx, HookPostRun, bt.config.PostRunHook, globPattern, err)
+	}()
+	if err := bt.runRunHook(ctx, HookPreRun, bt.config.PreRunHook, globPattern, nil); err != nil {
+		return err
+	}
+
+	// Initialize the storage backend
+	if err := bt.initStore(ctx); err != nil {
+		return err
+	}
+	defer bt.closeStore()
+	if err := bt.initReplicas(ctx); err != nil {
 		return err
 	}
+	defer bt.closeReplicas()
 
 	// Find target files
+	_, findSpan := tracer().Start(ctx, "backup.find_files")
 	files, err := bt.findTargetFiles(globPattern)
+	endSpan(findSpan, err, attribute.Int(TraceAttrCount, len(files)))
 	if err != nil {
 		return err
 	}
 
 	if len(files) == 0 {
-		bt.logger.Printf("No files found for pattern '%s' before %s", globPattern, bt.cutoffTime.Format("2006-01-02"))
-		bt.logger.Printf("=== Log backup process completed ===")
+		bt.logger.Info("No files found", "pattern", globPattern, "cutoff", bt.cutoffTime.Format("2006-01-02"))
+		if bt.config.Manifest {
+			if err := bt.uploadRunManifest(ctx, globPattern); err != nil {
+				return err
+			}
+		}
+		if bt.config.PartitionsOutput != "" {
+			if err := bt.writePartitionsOutput(); err != nil {
+				return err
+			}
+		}
+		bt.logger.Info("Log backup process completed", durationAttr(time.Since(bt.startTime)))
 		return nil
 	}
 
-	bt.logger.Printf("Found %d files to backup", len(files))
+	bt.logger.Info("Found files to backup", "count", len(files))
 
 	// Process files
 	if err := bt.processFiles(ctx, files); err != nil {
 		return err
 	}
 
+	// Upload run manifest
+	if bt.config.Manifest {
+		if err := bt.uploadRunManifest(ctx, globPattern); err != nil {
+			bt.logger.Error("Manifest upload failed", errAttr(err))
+			bt.stats.Errors++
+		}
+	}
+
+	// Write the partitions for table registration
+	if bt.config.PartitionsOutput != "" {
+		if err := bt.writePartitionsOutput(); err != nil {
+			bt.logger.Error("Partitions output failed", errAttr(err))
+			bt.stats.Errors++
+		}
+	}
+
 	// Log summary
 	bt.logSummary(globPattern)
 
 	if bt.stats.Errors > 0 {
-		bt.logger.Printf("Backup completed with %d errors", bt.stats.Errors)
-		return fmt.Errorf("backup completed with %d errors", bt.stats.Errors)
+		bt.logger.Error("Backup completed with errors", "errors", bt.stats.Errors, durationAttr(time.Since(bt.startTime)))
+		return &runFailure{
+			Errors:  bt.stats.Errors,
+			Partial: bt.stats.Uploaded+bt.stats.AlreadyArchived > 0,
+			Class:   failureClass(bt.results),
+		}
 	}
 
-	bt.logger.Printf("=== Log backup process completed successfully ===")
+	bt.logger.Info("Log backup process completed successfully", durationAttr(time.Since(bt.startTime)))
 	return nil
 }
 
+// addAWSFlags registers the region and AWS CLI compatible options on fs
+func addAWSFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.AWSRegion, "region", "", "AWS region (uses AWS_DEFAULT_REGION if not specified)")
+
+	// AWS CLI compatible options
+	fs.StringVar(&config.Profile, "profile", "", "Use a specific profile from your credential file")
+	fs.StringVar(&config.EndpointURL, "endpoint-url", "", "Override command's default URL with the given URL")
+	fs.BoolVar(&config.NoVerifySSL, "no-verify-ssl", false, "By default, the AWS CLI uses SSL when communicating with AWS services")
+	fs.StringVar(&config.CABundle, "ca-bundle", "", "The CA certificate bundle to use when verifying SSL certificates")
+	fs.IntVar(&config.CLIReadTimeout, "cli-read-timeout", 0, "The maximum socket read time in seconds (0 means no timeout)")
+	fs.IntVar(&config.CLIConnectTimeout, "cli-connect-timeout", 0, "The maximum socket connect time in seconds (0 means no timeout)")
+}
+
 func parseFlags() (Config, string, string, error) {
 	var config Config
 	var period string
 	var globPattern string
 
-	flag.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required)")
+	flag.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required with -backend s3)")
 	flag.StringVar(&config.S3Prefix, "prefix", "", "S3 prefix (supports date format like logs/YYYY/MM/DD) (required)")
-	flag.StringVar(&config.AWSRegion, "region", "", "AWS region (uses AWS_DEFAULT_REGION if not specified)")
 	flag.StringVar(&config.OutputFile, "output", "", "Output log file path (outputs to stdout if not specified)")
 	flag.StringVar(&config.LockFile, "lock", DefaultLockFile, "Lock file path")
 	flag.StringVar(&config.StorageClass, "storage-class", DefaultStorageClass, "S3 storage class")
+	flag.StringVar(&config.JobName, "job", "", "Job name, available to prefix, tag and metadata templates as {job}")
 	flag.BoolVar(&config.DryRun, "dry-run", false, "Dry run mode")
-	flag.BoolVar(&config.Verbose, "verbose", false, "Verbose logging")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Verbose logging, same as -log-level debug")
 	flag.BoolVar(&config.DeleteAfterUpload, "delete", false, "Delete local files after successful upload")
+	flag.StringVar(&config.Retention, "retention", "", "Keep local files for this period and delete them once older and confirmed in S3 (e.g. \"7 days\")")
 	flag.BoolVar(&config.Help, "help", false, "Show help")
 	flag.BoolVar(&config.Version, "version", false, "Show version")
-	
-	// AWS CLI compatible options
-	flag.StringVar(&config.Profile, "profile", "", "Use a specific profile from your credential file")
-	flag.StringVar(&config.EndpointURL, "endpoint-url", "", "Override command's default URL with the given URL")
-	flag.BoolVar(&config.NoVerifySSL, "no-verify-ssl", false, "By default, the AWS CLI uses SSL when communicating with AWS services")
-	flag.StringVar(&config.CABundle, "ca-bundle", "", "The CA certificate bundle to use when verifying SSL certificates")
-	flag.IntVar(&config.CLIReadTimeout, "cli-read-timeout", 0, "The maximum socket read time in seconds (0 means no timeout)")
-	flag.IntVar(&config.CLIConnectTimeout, "cli-connect-timeout", 0, "The maximum socket connect time in seconds (0 means no timeout)")
+	addLogFlags(flag.CommandLine, &config)
+	addStoreFlags(flag.CommandLine, &config)
+	addAWSFlags(flag.CommandLine, &config)
+
+	// Bundle options
+	flag.StringVar(&config.Bundle, "bundle", "", "Bundle files into one tar archive per group (date or prefix)")
+	flag.StringVar(&config.BundleCompression, "bundle-compression", DefaultBundleCompression, "Bundle compression (none, gzip, zstd)")
+
+	// Replication options
+	flag.Var((*stringListFlag)(&config.Replicas), "replicate", "Also upload each file to this destination URL (repeatable, add ?required=false for best effort)")
+
+	// Manifest options
+	flag.BoolVar(&config.Manifest, "manifest", false, "Upload a JSON manifest describing the run under the prefix")
+	flag.StringVar(&config.Report, "report", "", "Write a JSON or YAML (.yaml, .yml) report of the run to this file")
+
+	// Metrics options
+	flag.StringVar(&config.MetricsTextfile, "metrics-textfile", "", "Write Prometheus metrics to this .prom file for the node_exporter textfile collector")
+	flag.StringVar(&config.MetricsPushURL, "metrics-push-url", "", "Push Prometheus metrics to this Pushgateway URL")
+
+	// Tracing options
+	flag.StringVar(&config.TraceExporter, "trace", "", "Export OpenTelemetry traces (otlp, stdout, file)")
+	flag.StringVar(&config.TraceEndpoint, "trace-endpoint", "", "OTLP/HTTP endpoint URL for -trace otlp (default: OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)")
+	flag.StringVar(&config.TraceFile, "trace-file", "", "File the spans are appended to as JSON with -trace file")
+
+	// Throttling options
+	flag.StringVar(&config.BandwidthLimit, "bwlimit", "", "Limit the upload rate of all destinations together, e.g. 10M (bytes per second)")
+	flag.Var((*stringListFlag)(&config.UploadWindows), "upload-window", "Only upload during this daily local time window, e.g. 22:00-06:00 (repeatable)")
+
+	// In-use file options
+	flag.BoolVar(&config.SkipOpen, "skip-open", false, "Skip files another process has open for writing (Linux only)")
+	flag.DurationVar(&config.StabilityWindow, "stability-window", 0, "Skip files whose size or mtime changes within this time, e.g. 5s")
+
+	// Resource options
+	addResourceFlags(flag.CommandLine, &config)
+
+	// Hook options
+	flag.StringVar(&config.PreRunHook, "pre-run", "", "Shell command run before the run; a non-zero exit stops the run")
+	flag.StringVar(&config.PostRunHook, "post-run", "", "Shell command run after the run")
+	flag.StringVar(&config.PreUploadHook, "pre-upload", "", "Shell command run before each upload; a non-zero exit skips the file")
+	flag.StringVar(&config.PostUploadHook, "post-upload", "", "Shell command run after each upload; a non-zero exit keeps the local file")
+	flag.StringVar(&config.PreDeleteHook, "pre-delete", "", "Shell command run before each local delete; a non-zero exit keeps the file")
+	flag.StringVar(&config.PostDeleteHook, "post-delete", "", "Shell command run after each local delete")
+	flag.DurationVar(&config.HookTimeout, "hook-timeout", DefaultHookTimeout, "Maximum run time of a hook command")
+
+	// Notification options
+	flag.StringVar(&config.NotifyOn, "notify-on", NotifyOnFailure, "When to send notifications (failure, always)")
+	flag.StringVar(&config.NotifyWebhook, "notify-webhook", "", "POST a JSON notification to this webhook URL")
+	flag.StringVar(&config.NotifyWebhookTemplate, "notify-webhook-template", "", "Go template file rendering the JSON body of -notify-webhook")
+	flag.StringVar(&config.NotifySlack, "notify-slack", "", "Post notifications to this Slack-compatible incoming webhook URL")
+	flag.Var((*stringListFlag)(&config.NotifyEmail), "notify-email", "Email notifications to this address (repeatable, requires -smtp-server)")
+	flag.IntVar(&config.NotifyErrors, "notify-errors", DefaultNotifyErrors, "Number of file errors included in a notification")
+	flag.DurationVar(&config.NotifyInterval, "notify-interval", DefaultNotifyInterval, "Minimum interval between notifications with the same status (0 disables rate limiting)")
+	flag.StringVar(&config.NotifyState, "notify-state", "", "File the rate limit state is kept in (default: <lock file>.notify)")
+	flag.StringVar(&config.SMTPServer, "smtp-server", "", "SMTP server as host[:port] for -notify-email (default port 25)")
+	flag.StringVar(&config.SMTPFrom, "smtp-from", "", "Sender address of notification emails (default: backup-log-to-s3@<hostname>)")
+	flag.StringVar(&config.SMTPUser, "smtp-user", "", "SMTP user; the password is read from SMTP_PASSWORD")
+
+	// Object Lock options
+	flag.StringVar(&config.ObjectLockMode, "object-lock-mode", "", "Object Lock retention mode (GOVERNANCE, COMPLIANCE)")
+	flag.StringVar(&config.ObjectLockRetain, "object-lock-retain", "", "Object Lock retention period (e.g. \"7 years\")")
+	flag.StringVar(&config.ObjectLockFrom, "object-lock-from", ObjectLockFromUpload, "Count the retention period from the upload time or the file date (upload, file-date)")
+	flag.BoolVar(&config.ObjectLockAllowExpired, "object-lock-allow-expired", false, "Upload files whose retention already expired without a lock instead of failing them")
+	flag.BoolVar(&config.LegalHold, "legal-hold", false, "Place a legal hold on uploaded objects")
+
+	// Object tag and user metadata options
+	flag.Var((*stringListFlag)(&config.ObjectTags), "tag", "Object tag as key=template (repeatable)")
+	flag.Var((*stringListFlag)(&config.ObjectMetadata), "meta", "User metadata as key=template (repeatable)")
+
+	// Layout and partition options
+	flag.StringVar(&config.Layout, "layout", "", "Key layout preset (hive: <prefix>/year=/month=/day=/host=)")
+	flag.StringVar(&config.PartitionsOutput, "partitions-output", "", "Write the partitions created by the run to this file (- for stdout)")
+	flag.StringVar(&config.PartitionsFormat, "partitions-format", PartitionsFormatDDL, "Partitions output format (ddl, json)")
+	flag.StringVar(&config.AthenaTable, "athena-table", "", "Athena table name used in the partition DDL (e.g. logs_db.app_logs)")
 
 	flag.Parse()
 
@@ -700,6 +1127,13 @@ func parseFlags() (Config, string, string, error) {
 	
 	// Get period and glob pattern from command line args
 	args := flag.Args()
+	if len(args) == 3 && isDestinationURL(args[0]) {
+		if config.Destination != "" {
+			errors = append(errors, "Destination given both as -dest and as an argument")
+		}
+		config.Destination = args[0]
+		args = args[1:]
+	}
 	if len(args) != 2 {
 		errors = append(errors, "Both period and glob pattern are required")
 	} else {
@@ -710,11 +1144,60 @@ func parseFlags() (Config, string, string, error) {
 	}
 
 	// Validate required fields
-	if config.S3Bucket == "" {
-		errors = append(errors, "S3 bucket name is required (use -bucket flag)")
+	if err := applyDestination(&config); err != nil {
+		errors = append(errors, err.Error())
+	} else if err := validateStore(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if config.S3Prefix == "" && config.Destination == "" {
+		errors = append(errors, "S3 prefix is required (use -prefix flag, -dest or a destination argument)")
+	}
+	if err := validateLayout(config); err != nil {
+		errors = append(errors, err.Error())
+	} else {
+		config.S3Prefix = layoutPrefix(config.Layout, config.S3Prefix)
+	}
+	if err := validatePartitionOptions(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateBundleOptions(config.Bundle, config.BundleCompression); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateRetention(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateObjectLock(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateObjectLabels(config, globPattern); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validatePrefixTemplate(config, globPattern); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateReplicas(config, globPattern); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateMetrics(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateTracing(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateNotify(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateHooks(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateThrottle(config); err != nil {
+		errors = append(errors, err.Error())
+	}
+	if err := validateResources(config); err != nil {
+		errors = append(errors, err.Error())
 	}
-	if config.S3Prefix == "" {
-		errors = append(errors, "S3 prefix is required (use -prefix flag)")
+	if err := validateInUse(config); err != nil {
+		errors = append(errors, err.Error())
 	}
 	
 	// If there are validation errors, display them all
@@ -722,7 +1205,7 @@ func parseFlags() (Config, string, string, error) {
 		for _, err := range errors {
 			printError(err)
 		}
-		fmt.Fprintf(os.Stderr, "\nUsage: %s [OPTIONS] <period> <glob_pattern>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nUsage: %s [OPTIONS] [destination] <period> <glob_pattern>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Use -help for more information.\n")
 		return config, "", "", fmt.Errorf("missing required arguments")
 	}
@@ -735,13 +1218,21 @@ func parseFlags() (Config, string, string, error) {
 }
 
 func showUsage() {
-	fmt.Printf(`Usage: %s [OPTIONS] <period> <glob_pattern>
+	fmt.Printf(`Usage: %s [OPTIONS] [destination] <period> <glob_pattern>
+       %s <subcommand> [OPTIONS]
 
 Log backup tool that uploads files matching the glob pattern to S3.
 Only files older than the specified period are processed.
 By default, local files are kept after upload. Use -delete to remove them.
 
+SUBCOMMANDS:
+  restore         Download archived logs back to disk (see "restore -help")
+  verify          Compare local files against S3 without changing anything (see "verify -help")
+  ls              List archived objects for a date range (see "ls -help")
+  prune-remote    Delete or transition archived objects past the remote retention (see "prune-remote -help")
+
 ARGUMENTS:
+  destination     Optional destination URL, same as -dest (e.g. s3://my-logs/logs/{year}/{month})
   period          Time period (e.g., "1 day", "7 days", "1 month", "1 year")
   glob_pattern    File pattern with YYYYMMDD, YYYY-MM-DD, YYYY/MM/DD, or YYYY_MM_DD date format
 
@@ -764,10 +1255,11 @@ PATTERN EXAMPLES:
 
 OPTIONS:
   -bucket string
-        S3 bucket name (required)
+        S3 bucket name (required with -backend s3)
   -prefix string
-        S3 prefix (supports date format: YYYY, MM, DD tokens) (required)
-        Examples: "logs", "logs/YYYY", "logs/YYYY/MM", "logs/YYYY/MM/DD"
+        S3 prefix template (required); see TEMPLATE VARIABLES
+        Examples: "logs", "logs/{year}/{month}", "logs/dt={date}", "logs/{service}/{year}"
+        The legacy YYYY, MM and DD tokens are still accepted as whole words.
   -region string
         AWS region (uses AWS_DEFAULT_REGION if not specified)
   -output string
@@ -776,17 +1268,271 @@ OPTIONS:
         Lock file path (default "%s")
   -storage-class string
         S3 storage class (default "%s")
+  -job string
+        Job name, available to templates as {job}
   -dry-run
         Dry run mode (default false)
   -verbose
-        Verbose logging (default false)
+        Verbose logging, same as -log-level debug (default false)
   -delete
         Delete local files after successful upload (default false)
+  -retention string
+        Local retention period (e.g. "7 days"). Files older than <period> are uploaded
+        (skipped if S3 already holds an identical copy) and kept locally until they are
+        older than the retention period and S3 confirms they are archived.
+        Cannot be combined with -delete or -bundle.
+  -manifest
+        Upload a JSON manifest of the run to <prefix>/_manifests/<host>-<timestamp>.json
+        It records host, version, config hash, cutoff and each file's path, key,
+        size, SHA-256, mtime and outcome (default false)
+  -report string
+        Write a report of the run to this local file, as YAML if it ends in .yaml
+        or .yml and JSON otherwise. It has the status, exit code and error class of
+        the run, and each file's outcome, bytes, duration, key, ETag and error class.
+        It is also written when the run fails.
   -help
         Show this help
   -version
         Show version
 
+LOGGING OPTIONS:
+  -log-format string
+        Log format: text (key=value) or json, one record per line (default "text")
+        Records carry stable fields: file, key (object URL), bytes, duration
+        (seconds) and error. Passwords, keys and tokens are redacted.
+  -log-level string
+        Minimum log level: debug, info, warn or error (default "info")
+
+METRICS OPTIONS:
+  -metrics-textfile path
+        Write Prometheus metrics to this file at the end of each run, for the
+        node_exporter textfile collector (must end in .prom). Metrics are labelled
+        backup_job=<-job> and cover uploaded files and bytes, errors by class, run
+        duration, exit code and the time of the last fully successful run, which a
+        failed run keeps from the previous file.
+  -metrics-push-url url
+        Also push the metrics to this Prometheus Pushgateway, grouped by job=<-job>
+
+THROTTLING OPTIONS:
+  -bwlimit rate
+        Limit the upload rate in bytes per second, e.g. 512K, 10M or 1G (binary
+        units). The limit is shared by the primary destination and all replicas;
+        add bwlimit=<rate> to a -dest or -replicate URL to also limit that
+        destination on its own.
+  -upload-window HH:MM-HH:MM
+        Only start uploads during this daily window in local time, e.g. 22:00-06:00
+        (repeatable, or comma separated). Outside the windows the run pauses before
+        the next file and resumes when a window opens; an upload in progress is
+        finished. Deletes of files uploaded earlier are not delayed.
+
+IN-USE FILE OPTIONS:
+  -skip-open
+        Skip files another process has open for writing, e.g. a log shipper still
+        appending, found by scanning /proc/*/fd like fuser(1). Run as root to see
+        the processes of other users. Linux only; elsewhere a warning is logged.
+  -stability-window duration
+        Skip files whose size or mtime changes within this time, e.g. 5s. The
+        window is waited once for all files before the first upload.
+
+  Skipped files have the reason open-for-writing or changing in the -report
+  file and are uploaded by a later run.
+
+RESOURCE OPTIONS:
+  -nice n
+        Lower the CPU priority of the process to nice value n (0-19), like
+        nice(1). Hook commands inherit the priority.
+  -ionice class
+        Lower the IO priority: idle (only use the disk when nothing else does) or
+        best-effort[:0-7] (7 is lowest). Linux only; elsewhere a warning is logged.
+  -buffer-size size
+        Bound the memory used per upload and for bundle compression, e.g. 1M. GCS
+        uploads in chunks of this size (rounded to 256K, default 16M), Azure in
+        blocks of at least 1M, and zstd runs single-threaded with a window of at
+        most half the size. S3 and file destinations always stream from disk.
+  -memory-limit size
+        Soft memory limit of the Go runtime, e.g. 256M. The garbage collector
+        runs more often as the limit is approached.
+
+  Files are never loaded into memory as a whole: uploads, checksums, bundles
+  and restores all stream from and to disk.
+
+HOOK OPTIONS:
+  -pre-run, -post-run command
+        Shell command run before and after the run, e.g. to make an application
+        reopen its logs. A non-zero exit of -pre-run stops the run (exit code 1);
+        -post-run also runs after a failed run and receives BACKUP_STATUS,
+        BACKUP_EXIT_CODE and BACKUP_TOTAL_FILES, BACKUP_UPLOADED, BACKUP_DELETED,
+        BACKUP_SKIPPED and BACKUP_ERRORS.
+  -pre-upload, -post-upload command
+        Shell command run before and after each upload. A non-zero exit of
+        -pre-upload skips the file; of -post-upload it keeps the local file.
+  -pre-delete, -post-delete command
+        Shell command run before and after each local delete. A non-zero exit of
+        -pre-delete keeps the file.
+  -hook-timeout duration
+        Maximum run time of a hook (default 1m). A hook that times out or cannot
+        be started vetoes the step and counts as an error.
+        File hooks receive BACKUP_HOOK, BACKUP_FILE, BACKUP_KEY, BACKUP_URL,
+        BACKUP_SIZE, BACKUP_STATUS (pending, uploaded, already-archived, failed or
+        deleted), BACKUP_ERROR, BACKUP_JOB and BACKUP_DESTINATION. Hooks do not
+        run with -dry-run.
+
+NOTIFICATION OPTIONS:
+  -notify-on string
+        failure notifies about failed and partially failed runs, always about every
+        run (default "failure"). A notification carries the status, exit code, the
+        summary numbers and the first -notify-errors file errors.
+  -notify-webhook url
+        POST the notification as JSON to this URL
+  -notify-webhook-template path
+        Go template file for the -notify-webhook body, e.g.
+        {"text": {{json .Status}}, "errors": {{json .Errors}}}
+  -notify-slack url
+        Post the notification to a Slack-compatible incoming webhook
+  -notify-email address
+        Email the notification to this address (repeatable)
+  -smtp-server host[:port]
+        SMTP server for -notify-email (default port 25, STARTTLS when offered)
+  -smtp-from address
+        Sender address (default: backup-log-to-s3@<hostname>)
+  -smtp-user string
+        SMTP user; the password is read from SMTP_PASSWORD
+  -notify-errors int
+        File errors included in a notification (default 10)
+  -notify-interval duration
+        Hold back notifications with the same status as the last one sent for this
+        long, counting them in the next one; 0 disables (default 1h)
+  -notify-state path
+        Rate limit state file (default: <lock file>.notify)
+
+TRACING OPTIONS:
+  -trace string
+        Export OpenTelemetry traces: otlp, stdout or file. A run has a backup.run span
+        with backup.find_files, one backup.file span per file (backup.bundle with
+        -bundle) and backup.hash spans for checksums; each S3 API call is a client
+        span such as S3.PutObject. Spans carry backup.bytes and backup.storage_class.
+  -trace-endpoint url
+        OTLP/HTTP endpoint for -trace otlp, e.g. http://otel-collector:4318
+        (default: OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)
+  -trace-file path
+        File the spans are appended to as JSON with -trace file
+
+STORAGE BACKEND OPTIONS:
+  -dest string
+        Destination URL, also accepted as the first argument. It sets the backend,
+        bucket and prefix at once and cannot be combined with -bucket or -prefix:
+          s3://<bucket>/<prefix>          gs://<bucket>/<prefix>
+          azblob://<container>/<prefix>   file:///<dir>/<prefix>
+          sftp://[user@]host[:port]/<dir>/<prefix>
+        The path is a prefix template; {year} and {{.Year}} are both accepted.
+        Query parameters override the matching flags: endpoint, region, profile
+        and storage_class (s3), endpoint, credentials and storage_class (gs),
+        endpoint, account and storage_class (azblob), key and known_hosts (sftp),
+        and bwlimit (all backends, see -bwlimit)
+        e.g. s3://my-logs/logs/{year}/{month}?region=ap-northeast-1&storage_class=GLACIER_IR
+  -backend string
+        Where archived logs are stored: s3, gcs, azblob, local or sftp (default "s3")
+        With gcs and azblob, -bucket names the GCS bucket or Azure container and
+        -storage-class is mapped to the closest tier (e.g. GLACIER -> ARCHIVE/Archive)
+        or may be given as a native class such as COLDLINE or Cool.
+        With local and sftp, keys become paths below the archive directory and
+        metadata is kept in a hidden .<name>.meta.json file next to each object.
+        Object Lock and -partitions-output require s3; -storage-class is ignored.
+  -gcs-credentials string
+        Service account key file for -backend gcs
+        (default: Application Default Credentials; STORAGE_EMULATOR_HOST is honoured)
+  -azure-account string
+        Storage account for -backend azblob; authenticates with AZURE_STORAGE_KEY
+        or DefaultAzureCredential, unless AZURE_STORAGE_CONNECTION_STRING is set
+  -local-dir string
+        Archive directory for -backend local, e.g. an NFS mount
+  -sftp-host string
+        SFTP server as host[:port] for -backend sftp
+  -sftp-user string
+        SFTP user name (default: current user)
+  -sftp-dir string
+        Archive directory on the SFTP server
+  -sftp-key string
+        SSH private key file; an SSH agent and SFTP_PASSWORD are also tried
+  -sftp-known-hosts string
+        known_hosts file used to verify the server (default ~/.ssh/known_hosts)
+
+BUNDLE OPTIONS:
+  -bundle string
+        Bundle files into one tar archive per group instead of uploading them one by one
+        "date":   one bundle per extracted file date
+        "prefix": one bundle per processed prefix (e.g. per month with -prefix logs/YYYY/MM)
+        A <bundle>.manifest.json listing members and SHA-256 checksums is uploaded alongside.
+        Existing bundles are never overwritten; a later bundle of the same group gets -2, -3, ...
+        With -delete, local files are removed only after the whole bundle is verified.
+  -bundle-compression string
+        Bundle compression: none, gzip, zstd (default "%s")
+
+REPLICATION OPTIONS:
+  -replicate url
+        Also upload every file to this destination (repeatable). The URL has the
+        -dest syntax, so each replica has its own prefix and storage class, e.g.
+        -replicate "s3://dr-logs/logs/{year}/{month}?region=us-west-2&storage_class=DEEP_ARCHIVE"
+        Replicas are required by default: with -delete, the local file is only
+        deleted once the primary destination and every required replica hold it.
+        Add required=false to the query for a best-effort copy whose failures are
+        logged but do not fail the run. Other options (region, tags, Object Lock)
+        are inherited. With -retention, files the primary destination already
+        holds are uploaded to the replicas that lack them. Not supported with -bundle.
+
+OBJECT LOCK OPTIONS:
+  -object-lock-mode string
+        Object Lock retention mode for uploaded objects: GOVERNANCE or COMPLIANCE
+        The bucket must have Object Lock enabled; this is checked before uploading.
+  -object-lock-retain string
+        Object Lock retention period (e.g. "7 years") (required with -object-lock-mode)
+  -object-lock-from string
+        Count the retention period from "upload" time or the "file-date" extracted
+        from the file name (default "upload")
+  -object-lock-allow-expired
+        With -object-lock-from file-date, a file whose retention period already
+        expired fails, since S3 cannot lock it and it would be stored unprotected.
+        Set this to upload such files without retention instead (default false)
+  -legal-hold
+        Place a legal hold on uploaded objects (default false)
+
+TAG AND METADATA OPTIONS:
+  -tag key=template
+        Add an S3 object tag to each uploaded log file or bundle (repeatable, max 10)
+  -meta key=template
+        Add user metadata to each uploaded log file or bundle (repeatable)
+        source-host, backup-date and original-path are always set and reserved.
+  Tag and metadata values are templates (see TEMPLATE VARIABLES), e.g.
+        -tag app={service} -tag env=production -meta host={host}
+        with the glob "/var/log/{service}/{service}-YYYYMMDD.log.gz"
+
+PARTITION OPTIONS:
+  -layout hive
+        Append year={year}/month={month}/day={day}/host={host} to the prefix.
+        The prefix becomes the table location and must not contain variables.
+  -partitions-output path
+        Write the partitions created by the run to a file (- for stdout; log
+        lines and -trace stdout spans then go to stderr)
+  -partitions-format format
+        Partitions output format: ddl (ALTER TABLE ... ADD PARTITION) or json (default: ddl)
+  -athena-table name
+        Table name used in the DDL, e.g. logs_db.app_logs (required for ddl)
+
+TEMPLATE VARIABLES:
+  {year} {month} {day}   Date from the file name (2024, 12, 15)
+  {hour}                 Hour following the date in the file name (00 if none)
+  {date}                 Date as YYYY-MM-DD, e.g. "dt={date}" for Hive/Athena partitions
+  {yday}                 Day of year (001-366)
+  {isoyear} {isoweek}    ISO 8601 week-numbering year and week (2024, 50)
+  {host}                 Hostname
+  {job}                  Value of -job
+  {filename}             File name
+  {name}                 A {name} placeholder of the glob pattern
+  {{.Year}} style variables and the strftime conversions %%Y %%m %%d %%H %%F %%j %%G %%V
+  (%%%% for a literal %%) are accepted as well, and so are YYYY, MM and DD as whole
+  words (logs/YYYY_MM_DD). Write {{ and }} for literal braces. Templates are
+  validated before any upload.
+
 AWS CLI COMPATIBLE OPTIONS:
   -profile string
         Use a specific profile from your credential file
@@ -807,31 +1553,59 @@ EXAMPLES:
   %s -bucket my-logs -prefix logs "1 month" "YYYY/MM/DD.gz"
   %s -bucket my-logs -prefix logs -dry-run "7 days" "/var/log/app*YYYYMMDD.gz"
   
-PREFIX WITH DATE FORMAT EXAMPLES:
+PREFIX TEMPLATE EXAMPLES:
   %s -bucket my-logs -prefix "logs" "1 month" "*YYYYMMDD.log.gz"
     # Saves to: my-logs/logs/filename.log.gz (no date substitution)
-  %s -bucket my-logs -prefix "logs/YYYY" "1 month" "*YYYYMMDD.log.gz"
+  %s -bucket my-logs -prefix "logs/{year}" "1 month" "*YYYYMMDD.log.gz"
     # Saves to: my-logs/logs/2024/filename.log.gz (year from filename date)
-  %s -bucket my-logs -prefix "logs/YYYY/MM" "1 month" "*YYYYMMDD.log.gz"
+  %s -bucket my-logs -prefix "logs/{year}/{month}" "1 month" "*YYYYMMDD.log.gz"
     # Saves to: my-logs/logs/2024/12/filename.log.gz (year/month from filename date)
-  %s -bucket my-logs -prefix "logs/YYYY/MM/DD" "1 month" "*YYYYMMDD.log.gz"
-    # Saves to: my-logs/logs/2024/12/15/filename.log.gz (full date from filename)
+  %s -bucket my-logs -prefix "logs/dt={date}" "1 month" "*YYYYMMDD.log.gz"
+    # Saves to: my-logs/logs/dt=2024-12-15/filename.log.gz (Hive-style partition)
 
-DATE TOKEN BEHAVIOR:
-  - YYYY: Replaced with 4-digit year from filename date (e.g., 2024)
-  - MM:   Replaced with 2-digit month from filename date (e.g., 12)
-  - DD:   Replaced with 2-digit day from filename date (e.g., 15)
+DATE BEHAVIOR:
   - Date is extracted from filename patterns: YYYYMMDD, YYYY-MM-DD, YYYY/MM/DD, YYYY_MM_DD
-  - If filename contains no date pattern, upload will fail with error
+  - If the prefix uses a date variable and the filename contains no date, upload will fail
+  - Legacy YYYY/MM/DD prefix tokens only match whole words, so "ADDONS/YYYY" keeps "ADDONS"
 
 ENVIRONMENT VARIABLES:
   AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_DEFAULT_REGION
   See AWS documentation for authentication options.
 
-`, os.Args[0], DefaultLockFile, DefaultStorageClass, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+EXIT CODES:
+  0  Success
+  1  Failure: no file could be archived, or the run stopped early
+  2  Invalid command line or configuration
+  3  Another instance holds the lock file
+  4  The storage backend rejected the credentials
+  5  Partial failure: some files were archived and others failed
+
+`, os.Args[0], os.Args[0], DefaultLockFile, DefaultStorageClass, DefaultBundleCompression, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+}
+
+// subcommands maps subcommand names to their entry points. Each receives the
+// arguments following the subcommand name.
+var subcommands = map[string]func(args []string) error{
+	"restore":      runRestore,
+	"verify":       runVerify,
+	"ls":           runList,
+	"prune-remote": runPrune,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(0)
+				}
+				printError("%v", err)
+				os.Exit(exitCode(err))
+			}
+			os.Exit(0)
+		}
+	}
+
 	config, _, globPattern, err := parseFlags()
 	if err != nil {
 		// Error message is already printed by parseFlags() in red
@@ -843,7 +1617,7 @@ func main() {
 			fmt.Fprintf(os.Stderr, "\n")
 			showUsage()
 		}
-		os.Exit(1)
+		os.Exit(ExitConfig)
 	}
 
 	if config.Help {
@@ -857,13 +1631,52 @@ func main() {
 
 	backupTool, err := NewBackupTool(config)
 	if err != nil {
+		err = &configError{err}
+		if config.Report != "" {
+			if reportErr := writeStartupReport(config, globPattern, err); reportErr != nil {
+				printError("%v", reportErr)
+			}
+		}
+		failedTool := newStartupFailureTool(config)
+		if metricsErr := failedTool.writeMetrics(err); metricsErr != nil {
+			failedTool.logger.Error("Metrics export failed", errAttr(metricsErr))
+		}
+		if notifyErr := failedTool.notify(context.Background(), globPattern, err); notifyErr != nil {
+			failedTool.logger.Error("Notification failed", errAttr(notifyErr))
+		}
 		printError("%v", err)
-		os.Exit(1)
+		os.Exit(ExitConfig)
 	}
+	applyResourceLimits(config, backupTool.logger)
 
 	ctx := context.Background()
-	if err := backupTool.Run(ctx, globPattern); err != nil {
+	shutdownTracing, err := initTracing(ctx, config)
+	if err != nil {
+		printError("%v", err)
+		os.Exit(ExitConfig)
+	}
+
+	err = backupTool.Run(ctx, globPattern)
+	code := exitCode(err)
+	if traceErr := shutdownTracing(ctx); traceErr != nil {
+		backupTool.logger.Error("Trace export failed", errAttr(traceErr))
+	}
+	if metricsErr := backupTool.writeMetrics(err); metricsErr != nil {
+		backupTool.logger.Error("Metrics export failed", errAttr(metricsErr))
+	}
+	if config.Report != "" {
+		if reportErr := backupTool.writeReport(globPattern, err); reportErr != nil {
+			printError("%v", reportErr)
+			if code == ExitOK {
+				code = ExitFailure
+			}
+		}
+	}
+	if notifyErr := backupTool.notify(ctx, globPattern, err); notifyErr != nil {
+		backupTool.logger.Error("Notification failed", errAttr(notifyErr))
+	}
+	if err != nil {
 		printError("%v", err)
-		os.Exit(1)
 	}
+	os.Exit(code)
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// File outcomes recorded in the run manifest
+const (
+	OutcomeUploaded = "uploaded"
+	OutcomeFailed   = "failed"
+	OutcomeSkipped  = "skipped"
+	OutcomeDryRun   = "dry-run"
+
+	OutcomeAlreadyArchived = "already-archived"
+)
+
+// fileResult records what happened to a single local file during a run
+type fileResult struct {
+	Path    string    `json:"path"`
+	Key     string    `json:"key,omitempty"`
+	Member  string    `json:"member,omitempty"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	Outcome string    `json:"outcome"`
+	// Reason a file was skipped because it is still being written
+	Reason  string `json:"reason,omitempty"`
+	Deleted bool   `json:"deleted"`
+	// ETag of the uploaded object, as reported by the backend
+	ETag string `json:"etag,omitempty"`
+	// Duration of the upload in seconds
+	Duration   float64 `json:"duration,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"error_class,omitempty"`
+}
+
+// runManifest describes a whole run and is uploaded under the prefix
+type runManifest struct {
+	Host        string        `json:"host"`
+	Version     string        `json:"version"`
+	ConfigHash  string        `json:"config_hash"`
+	GlobPattern string        `json:"glob_pattern"`
+	Cutoff      time.Time     `json:"cutoff"`
+	Started     time.Time     `json:"started"`
+	Finished    time.Time     `json:"finished"`
+	DryRun      bool          `json:"dry_run"`
+	Stats       Stats         `json:"stats"`
+	Files       []*fileResult `json:"files"`
+}
+
+// newFileResult creates a result entry for filePath and adds it to the run.
+// The checksum is only computed when a manifest will be written, since it
+// requires reading the whole file.
+func (bt *BackupTool) newFileResult(ctx context.Context, filePath string) *fileResult {
+	result := &fileResult{Path: filePath}
+	if info, err := os.Stat(filePath); err == nil {
+		result.Size = info.Size()
+		result.ModTime = info.ModTime().UTC()
+	}
+	if bt.config.Manifest {
+		_, span := tracer().Start(ctx, "backup.hash", trace.WithAttributes(attribute.String(TraceAttrFile, filePath)))
+		checksum, size, err := fileSHA256(filePath)
+		endSpan(span, err, attribute.Int64(TraceAttrBytes, size))
+		if err == nil {
+			result.SHA256 = checksum
+		}
+	}
+	bt.results = append(bt.results, result)
+	return result
+}
+
+// fail marks the result as failed with the given error
+func (r *fileResult) fail(err error) {
+	r.Outcome = OutcomeFailed
+	r.setError(err)
+}
+
+// setError records an error without changing the outcome, e.g. when the
+// upload succeeded but the local file could not be deleted
+func (r *fileResult) setError(err error) {
+	r.Error = err.Error()
+	r.ErrorClass = classifyError(err)
+}
+
+// uploadedOutcome returns the outcome for a successful upload
+func (bt *BackupTool) uploadedOutcome() string {
+	if bt.config.DryRun {
+		return OutcomeDryRun
+	}
+	return OutcomeUploaded
+}
+
+// archiveSettings are the options that decide what is archived where, for
+// configHash. Options that only change how a run behaves or reports, such
+// as -dry-run, -verbose, logging, throttling and notifications, are left out.
+type archiveSettings struct {
+	Backend                string
+	Bucket                 string
+	Prefix                 string
+	LocalDir               string
+	SFTPHost               string
+	SFTPDir                string
+	AzureAccount           string
+	EndpointURL            string
+	Replicas               []string
+	Period                 string
+	Retention              string
+	DeleteAfterUpload      bool
+	StorageClass           string
+	Bundle                 string
+	BundleCompression      string
+	Layout                 string
+	JobName                string
+	ObjectTags             []string
+	ObjectMetadata         []string
+	ObjectLockMode         string
+	ObjectLockRetain       string
+	ObjectLockFrom         string
+	ObjectLockAllowExpired bool
+	LegalHold              bool
+}
+
+// configHashLength is the number of hex digits of the SHA-256 kept in the
+// config hash; enough to tell the settings of one host's runs apart
+const configHashLength = 12
+
+// configHash returns a short stable digest of the archive settings so runs
+// with different settings can be told apart
+func configHash(config Config) string {
+	data, err := json.Marshal(archiveSettings{
+		Backend:                config.Backend,
+		Bucket:                 config.S3Bucket,
+		Prefix:                 config.S3Prefix,
+		LocalDir:               config.LocalDir,
+		SFTPHost:               config.SFTPHost,
+		SFTPDir:                config.SFTPDir,
+		AzureAccount:           config.AzureAccount,
+		EndpointURL:            config.EndpointURL,
+		Replicas:               config.Replicas,
+		Period:                 config.Period,
+		Retention:              config.Retention,
+		DeleteAfterUpload:      config.DeleteAfterUpload,
+		StorageClass:           config.StorageClass,
+		Bundle:                 config.Bundle,
+		BundleCompression:      config.BundleCompression,
+		Layout:                 config.Layout,
+		JobName:                config.JobName,
+		ObjectTags:             config.ObjectTags,
+		ObjectMetadata:         config.ObjectMetadata,
+		ObjectLockMode:         config.ObjectLockMode,
+		ObjectLockRetain:       config.ObjectLockRetain,
+		ObjectLockFrom:         config.ObjectLockFrom,
+		ObjectLockAllowExpired: config.ObjectLockAllowExpired,
+		LegalHold:              config.LegalHold,
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:configHashLength]
+}
+
+// runManifestKey returns the S3 key of the manifest for a run started at the given time
+func (bt *BackupTool) runManifestKey(hostname string, started time.Time) string {
+	// Variables that differ per file (such as glob placeholders) are not
+	// known here, so manifests go under the part of the prefix before them
+	vars := dateVars(started)
+	vars["host"] = hostname
+	if bt.config.JobName != "" {
+		vars["job"] = bt.config.JobName
+	}
+	prefix := prefixBefore(bt.config.S3Prefix, vars)
+	key := fmt.Sprintf("_manifests/%s-%s.json", hostname, started.UTC().Format("20060102T150405Z"))
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		key = prefix + "/" + key
+	}
+	return key
+}
+
+// buildRunManifest assembles the manifest for the current run
+func (bt *BackupTool) buildRunManifest(globPattern, hostname string) runManifest {
+	files := bt.results
+	if files == nil {
+		files = []*fileResult{}
+	}
+	return runManifest{
+		Host:        hostname,
+		Version:     Version,
+		ConfigHash:  configHash(bt.config),
+		GlobPattern: globPattern,
+		Cutoff:      bt.cutoffTime,
+		Started:     bt.startTime.UTC(),
+		Finished:    time.Now().UTC(),
+		DryRun:      bt.config.DryRun,
+		Stats:       bt.stats,
+		Files:       files,
+	}
+}
+
+// uploadRunManifest uploads the manifest for the current run
+func (bt *BackupTool) uploadRunManifest(ctx context.Context, globPattern string) error {
+	hostname, _ := os.Hostname()
+	manifest := bt.buildRunManifest(globPattern, hostname)
+	s3Key := bt.runManifestKey(hostname, bt.startTime)
+
+	if bt.config.DryRun {
+		bt.logger.Info("DRY RUN: Would upload run manifest", LogFieldKey, bt.objectURL(s3Key), "count", len(manifest.Files))
+		return nil
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run manifest: %w", err)
+	}
+	if err := bt.putObjectWithClass(ctx, s3Key, strings.NewReader(string(data)), "", "STANDARD"); err != nil {
+		return fmt.Errorf("failed to upload run manifest: %w", err)
+	}
+
+	bt.logger.Info("Run manifest uploaded", LogFieldKey, bt.objectURL(s3Key))
+	return nil
+}
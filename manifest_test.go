@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunManifestKey tests that the manifest key uses the processed prefix
+func TestRunManifestKey(t *testing.T) {
+	bt := &BackupTool{config: Config{S3Prefix: "logs/YYYY/MM"}}
+	started := time.Date(2024, 12, 15, 10, 30, 0, 0, time.UTC)
+
+	got := bt.runManifestKey("web01", started)
+	want := "logs/2024/12/_manifests/web01-20241215T103000Z.json"
+	if got != want {
+		t.Errorf("runManifestKey() = %s, want %s", got, want)
+	}
+
+	// Placeholders vary per file, so the manifest goes above them
+	bt.config.S3Prefix = "logs/{service}/YYYY/MM"
+	if got, want := bt.runManifestKey("web01", started), "logs/_manifests/web01-20241215T103000Z.json"; got != want {
+		t.Errorf("runManifestKey() = %s, want %s", got, want)
+	}
+	bt.config.S3Prefix = "{service}"
+	if got, want := bt.runManifestKey("web01", started), "_manifests/web01-20241215T103000Z.json"; got != want {
+		t.Errorf("runManifestKey() = %s, want %s", got, want)
+	}
+}
+
+// TestConfigHash tests that the config hash is stable and sensitive to changes
+func TestConfigHash(t *testing.T) {
+	a := Config{S3Bucket: "bucket", S3Prefix: "logs", Period: "1 day"}
+	b := a
+	if configHash(a) != configHash(b) {
+		t.Error("configHash() should be stable for identical configs")
+	}
+	if got := configHash(a); len(got) != configHashLength {
+		t.Errorf("configHash() = %q, want %d hex digits", got, configHashLength)
+	}
+	b.S3Prefix = "other"
+	if configHash(a) == configHash(b) {
+		t.Error("configHash() should change when the config changes")
+	}
+
+	// Runtime-only options do not change what is archived
+	b = a
+	b.DryRun, b.Verbose, b.LogLevel, b.OutputFile, b.BandwidthLimit = true, true, "debug", "/var/log/backup.log", "10M"
+	if configHash(a) != configHash(b) {
+		t.Error("configHash() should not change with runtime-only options")
+	}
+	b = a
+	b.StorageClass = "GLACIER"
+	if configHash(a) == configHash(b) {
+		t.Error("configHash() should change with the storage class")
+	}
+}
+
+// TestRunManifestRecordsResults tests that processed files end up in the manifest
+func TestRunManifestRecordsResults(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "app20241215.log.gz")
+	if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	missing := filepath.Join(tempDir, "app20241214.log.gz")
+
+	var buf bytes.Buffer
+	bt := &BackupTool{
+		config: Config{
+			S3Bucket: "test-bucket",
+			S3Prefix: "logs/YYYY",
+			DryRun:   true,
+			Manifest: true,
+		},
+		logger:     slog.New(slog.NewTextHandler(&buf, nil)),
+		cutoffTime: time.Date(2024, 12, 16, 0, 0, 0, 0, time.UTC),
+		startTime:  time.Date(2024, 12, 17, 0, 0, 0, 0, time.UTC),
+	}
+
+	if err := bt.processFiles(context.Background(), []string{path, missing}); err != nil {
+		t.Fatalf("processFiles() error = %v", err)
+	}
+
+	manifest := bt.buildRunManifest("*YYYYMMDD.log.gz", "web01")
+	if len(manifest.Files) != 2 {
+		t.Fatalf("Expected 2 files in manifest, got %d", len(manifest.Files))
+	}
+
+	uploaded := manifest.Files[0]
+	if uploaded.Outcome != OutcomeDryRun {
+		t.Errorf("Outcome = %s, want %s", uploaded.Outcome, OutcomeDryRun)
+	}
+	if uploaded.Key != "logs/2024/app20241215.log.gz" {
+		t.Errorf("Key = %s, want logs/2024/app20241215.log.gz", uploaded.Key)
+	}
+	if uploaded.Size != 4 || len(uploaded.SHA256) != 64 {
+		t.Errorf("Expected size and checksum to be recorded, got %d %q", uploaded.Size, uploaded.SHA256)
+	}
+	if manifest.Files[1].Outcome != OutcomeSkipped {
+		t.Errorf("Outcome = %s, want %s", manifest.Files[1].Outcome, OutcomeSkipped)
+	}
+
+	if err := bt.uploadRunManifest(context.Background(), "*YYYYMMDD.log.gz"); err != nil {
+		t.Fatalf("uploadRunManifest() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `msg="DRY RUN: Would upload run manifest" key=s3://test-bucket/logs/2024/_manifests/`) {
+		t.Errorf("Expected dry run manifest log, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+// metricsNamespace prefixes every metric name
+const metricsNamespace = "backup_log_to_s3"
+
+// DefaultMetricsJob is the backup_job label of runs without -job
+const DefaultMetricsJob = "backup-log-to-s3"
+
+// errorClasses are the class labels of errors_total. Every class is reported
+// each run, so a push replaces the errors of a previous run on the
+// Pushgateway.
+var errorClasses = []string{
+	ErrorClassAuth, ErrorClassNotFound, ErrorClassThrottled, ErrorClassNetwork,
+	ErrorClassLocalIO, ErrorClassStorage, ErrorClassOther, ErrorClassConfig, ErrorClassLocked,
+}
+
+// lastSuccessMetric is carried over between runs, so a failed run does not
+// reset the freshness alert
+const lastSuccessMetric = metricsNamespace + "_last_success_timestamp_seconds"
+
+// runMetrics holds the Prometheus metrics of a run. The label is backup_job
+// rather than job, which Prometheus sets to the scrape job itself.
+type runMetrics struct {
+	job      string
+	registry *prometheus.Registry
+
+	uploadedFiles *prometheus.CounterVec
+	uploadedBytes *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	duration      *prometheus.GaugeVec
+	lastRun       *prometheus.GaugeVec
+	exitCode      *prometheus.GaugeVec
+	lastSuccess   *prometheus.GaugeVec
+}
+
+// newRunMetrics creates and registers the metrics of a run
+func newRunMetrics(job string) *runMetrics {
+	if job == "" {
+		job = DefaultMetricsJob
+	}
+	labels := []string{"backup_job"}
+	m := &runMetrics{
+		job:      job,
+		registry: prometheus.NewRegistry(),
+		uploadedFiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "uploaded_files_total",
+			Help: "Files uploaded by the last run.",
+		}, labels),
+		uploadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "uploaded_bytes_total",
+			Help: "Bytes uploaded by the last run.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "errors_total",
+			Help: "Errors of the last run by error class.",
+		}, []string{"backup_job", "class"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "run_duration_seconds",
+			Help: "Duration of the last run.",
+		}, labels),
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "last_run_timestamp_seconds",
+			Help: "Time the last run finished.",
+		}, labels),
+		exitCode: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "last_run_exit_code",
+			Help: "Exit code of the last run.",
+		}, labels),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "last_success_timestamp_seconds",
+			Help: "Time the last fully successful run finished.",
+		}, labels),
+	}
+	m.registry.MustRegister(m.uploadedFiles, m.uploadedBytes, m.errors, m.duration, m.lastRun, m.exitCode, m.lastSuccess)
+
+	// Report zero rather than no data before the first upload
+	m.uploadedFiles.WithLabelValues(job)
+	m.uploadedBytes.WithLabelValues(job)
+	for _, class := range errorClasses {
+		m.errors.WithLabelValues(job, class)
+	}
+	return m
+}
+
+// validateMetrics checks the metrics options
+func validateMetrics(config Config) error {
+	if config.MetricsTextfile != "" && !strings.HasSuffix(config.MetricsTextfile, ".prom") {
+		return fmt.Errorf("-metrics-textfile must end in .prom for the node_exporter textfile collector")
+	}
+	if config.MetricsPushURL != "" {
+		u, err := url.Parse(config.MetricsPushURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -metrics-push-url '%s': must be an http or https URL", config.MetricsPushURL)
+		}
+	}
+	return nil
+}
+
+// recordUpload counts an uploaded file or bundle
+func (m *runMetrics) recordUpload(files int, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.uploadedFiles.WithLabelValues(m.job).Add(float64(files))
+	m.uploadedBytes.WithLabelValues(m.job).Add(float64(bytes))
+}
+
+// recordRun sets the metrics describing the outcome of the run. Failed
+// files are counted by class; an error that stopped the run is counted once.
+func (bt *BackupTool) recordRun(runErr error) {
+	m := bt.metrics
+	finished := time.Now()
+
+	var failure *runFailure
+	for _, result := range bt.results {
+		if result.ErrorClass != "" {
+			m.errors.WithLabelValues(m.job, result.ErrorClass).Inc()
+		}
+	}
+	if runErr != nil && !errors.As(runErr, &failure) {
+		m.errors.WithLabelValues(m.job, runErrorClass(runErr)).Inc()
+	}
+
+	if !bt.startTime.IsZero() {
+		m.duration.WithLabelValues(m.job).Set(seconds(finished.Sub(bt.startTime)))
+	}
+	m.lastRun.WithLabelValues(m.job).Set(float64(finished.Unix()))
+	m.exitCode.WithLabelValues(m.job).Set(float64(exitCode(runErr)))
+	if runErr == nil {
+		m.lastSuccess.WithLabelValues(m.job).Set(float64(finished.Unix()))
+	}
+}
+
+// writeMetrics records the outcome of the run and writes the textfile and
+// pushes to the Pushgateway as configured
+func (bt *BackupTool) writeMetrics(runErr error) error {
+	bt.recordRun(runErr)
+
+	var errs []error
+	if path := bt.config.MetricsTextfile; path != "" {
+		if runErr != nil {
+			if last, ok := readLastSuccess(path, bt.metrics.job); ok {
+				bt.metrics.lastSuccess.WithLabelValues(bt.metrics.job).Set(last)
+			}
+		}
+		if err := prometheus.WriteToTextfile(path, bt.metrics.registry); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write metrics textfile: %w", err))
+		}
+	}
+
+	if bt.config.MetricsPushURL != "" {
+		// The metrics are grouped by the backup job. Add (POST) only replaces
+		// the pushed metrics, so the last success time of a previous run
+		// survives a failed run, while errors_total is always pushed.
+		err := push.New(bt.config.MetricsPushURL, bt.metrics.job).
+			Gatherer(bt.metrics.registry).
+			Add()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to push metrics: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readLastSuccess returns the last success time of the job recorded in a
+// previous textfile
+func readLastSuccess(path, job string) (float64, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(file)
+	if err != nil {
+		return 0, false
+	}
+	family, ok := families[lastSuccessMetric]
+	if !ok {
+		return 0, false
+	}
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "backup_job" && label.GetValue() == job {
+				return metric.GetGauge().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteMetricsTextfile tests the textfile and that a failed run keeps
+// the last success time of the previous run
+func TestWriteMetricsTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.prom")
+	newTool := func() *BackupTool {
+		return &BackupTool{
+			config:    Config{JobName: "nginx", MetricsTextfile: path},
+			logger:    slog.New(slog.DiscardHandler),
+			metrics:   newRunMetrics("nginx"),
+			startTime: time.Now().Add(-2 * time.Second),
+		}
+	}
+
+	bt := newTool()
+	bt.metrics.recordUpload(2, 2048)
+	if err := bt.writeMetrics(nil); err != nil {
+		t.Fatalf("writeMetrics() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`backup_log_to_s3_uploaded_files_total{backup_job="nginx"} 2`,
+		`backup_log_to_s3_uploaded_bytes_total{backup_job="nginx"} 2048`,
+		`backup_log_to_s3_last_run_exit_code{backup_job="nginx"} 0`,
+		`backup_log_to_s3_run_duration_seconds{backup_job="nginx"} 2`,
+		`backup_log_to_s3_last_success_timestamp_seconds{backup_job="nginx"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in textfile:\n%s", want, data)
+		}
+	}
+	lastSuccess, ok := readLastSuccess(path, "nginx")
+	if !ok {
+		t.Fatal("readLastSuccess() found no last success")
+	}
+
+	bt = newTool()
+	bt.results = []*fileResult{
+		{Path: "a.log", Outcome: OutcomeFailed, ErrorClass: ErrorClassNetwork},
+		{Path: "b.log", Outcome: OutcomeFailed, ErrorClass: ErrorClassNetwork},
+	}
+	if err := bt.writeMetrics(&runFailure{Errors: 2, Class: ErrorClassNetwork}); err != nil {
+		t.Fatalf("writeMetrics() error = %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	for _, want := range []string{
+		`backup_log_to_s3_errors_total{backup_job="nginx",class="network"} 2`,
+		`backup_log_to_s3_last_run_exit_code{backup_job="nginx"} 1`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in textfile:\n%s", want, data)
+		}
+	}
+	if got, ok := readLastSuccess(path, "nginx"); !ok || got != lastSuccess {
+		t.Errorf("Last success after failure = %v (%v), want %v", got, ok, lastSuccess)
+	}
+}
+
+// TestWriteMetricsPush tests the Pushgateway grouping key and payload
+func TestWriteMetricsPush(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	bt := &BackupTool{
+		config:  Config{MetricsPushURL: server.URL},
+		logger:  slog.New(slog.DiscardHandler),
+		metrics: newRunMetrics(""),
+	}
+	if err := bt.writeMetrics(&configError{errors.New("invalid period")}); err != nil {
+		t.Fatalf("writeMetrics() error = %v", err)
+	}
+	if method != http.MethodPost || path != "/metrics/job/backup-log-to-s3" {
+		t.Errorf("Pushed with %s %s", method, path)
+	}
+	// The payload is protobuf encoded, so only check the metric names
+	for _, want := range []string{"backup_log_to_s3_errors_total", "config", "backup_log_to_s3_last_run_exit_code"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the pushed metrics", want)
+		}
+	}
+	if strings.Contains(body, lastSuccessMetric) {
+		t.Error("A failed run must not push a last success time")
+	}
+}
+
+// TestWriteMetricsPushAfterFailure tests that a clean run after a failed one
+// pushes zero errors, replacing the errors of the failed run
+func TestWriteMetricsPushAfterFailure(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var bt *BackupTool
+	for _, runErr := range []error{&configError{errors.New("invalid period")}, nil} {
+		bt = &BackupTool{
+			config:  Config{MetricsPushURL: server.URL},
+			logger:  slog.New(slog.DiscardHandler),
+			metrics: newRunMetrics(""),
+		}
+		if err := bt.writeMetrics(runErr); err != nil {
+			t.Fatalf("writeMetrics(%v) error = %v", runErr, err)
+		}
+	}
+
+	if len(bodies) != 2 || !strings.Contains(bodies[1], "backup_log_to_s3_errors_total") || !strings.Contains(bodies[1], ErrorClassConfig) {
+		t.Fatal("Expected the clean run to push errors_total for every class")
+	}
+	families, err := bt.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != metricsNamespace+"_errors_total" {
+			continue
+		}
+		if len(family.GetMetric()) != len(errorClasses) {
+			t.Errorf("Pushed %d error classes, want %d", len(family.GetMetric()), len(errorClasses))
+		}
+		for _, metric := range family.GetMetric() {
+			if value := metric.GetCounter().GetValue(); value != 0 {
+				t.Errorf("errors_total%v = %v after a clean run, want 0", metric.GetLabel(), value)
+			}
+		}
+	}
+}
+
+// TestValidateMetrics tests the metrics options
+func TestValidateMetrics(t *testing.T) {
+	tests := []struct {
+		config  Config
+		wantErr bool
+	}{
+		{Config{}, false},
+		{Config{MetricsTextfile: "/var/lib/node_exporter/backup.prom", MetricsPushURL: "http://pushgateway:9091"}, false},
+		{Config{MetricsTextfile: "/var/lib/node_exporter/backup.txt"}, true},
+		{Config{MetricsPushURL: "pushgateway:9091"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateMetrics(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("validateMetrics(%+v) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
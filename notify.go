@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// When notifications are sent
+const (
+	// NotifyOnFailure notifies about failed and partially failed runs
+	NotifyOnFailure = "failure"
+	// NotifyOnAlways notifies about every run
+	NotifyOnAlways = "always"
+)
+
+// Notification defaults
+const (
+	DefaultNotifyErrors   = 10
+	DefaultNotifyInterval = time.Hour
+	notifyTimeout         = 10 * time.Second
+)
+
+// notification is the payload sent to every notification channel. The
+// default webhook body is its JSON encoding.
+type notification struct {
+	Status      string        `json:"status"`
+	ExitCode    int           `json:"exit_code"`
+	Job         string        `json:"job,omitempty"`
+	Host        string        `json:"host"`
+	Pattern     string        `json:"pattern"`
+	Destination string        `json:"destination"`
+	Started     time.Time     `json:"started"`
+	Finished    time.Time     `json:"finished"`
+	Duration    float64       `json:"duration"`
+	Summary     notifySummary `json:"summary"`
+	// Error is the error that ended the run
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	// Errors are the first -notify-errors file errors
+	Errors     []string `json:"errors"`
+	MoreErrors int      `json:"more_errors,omitempty"`
+	// Suppressed counts the notifications held back by the rate limit since
+	// the last one sent
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// notifySummary holds the numbers of the backup summary log record
+type notifySummary struct {
+	TotalFiles      int `json:"total_files"`
+	Uploaded        int `json:"uploaded"`
+	Deleted         int `json:"deleted"`
+	Skipped         int `json:"skipped"`
+	AlreadyArchived int `json:"already_archived"`
+	Retained        int `json:"retained"`
+	Errors          int `json:"errors"`
+}
+
+// notifyState is persisted between runs to rate limit notifications
+type notifyState struct {
+	Status     string    `json:"status"`
+	Sent       time.Time `json:"sent"`
+	Suppressed int       `json:"suppressed"`
+}
+
+// notifyEnabled reports whether any notification channel is configured
+func notifyEnabled(config Config) bool {
+	return config.NotifyWebhook != "" || config.NotifySlack != "" || len(config.NotifyEmail) > 0
+}
+
+// validateNotify checks the notification options
+func validateNotify(config Config) error {
+	switch config.NotifyOn {
+	case NotifyOnFailure, NotifyOnAlways:
+	default:
+		return fmt.Errorf("invalid -notify-on '%s' (supported: %s, %s)", config.NotifyOn, NotifyOnFailure, NotifyOnAlways)
+	}
+	for name, raw := range map[string]string{"-notify-webhook": config.NotifyWebhook, "-notify-slack": config.NotifySlack} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid %s: must be an http or https URL", name)
+		}
+	}
+	if config.NotifyWebhookTemplate != "" {
+		if config.NotifyWebhook == "" {
+			return fmt.Errorf("-notify-webhook-template requires -notify-webhook")
+		}
+		if _, err := loadWebhookTemplate(config.NotifyWebhookTemplate); err != nil {
+			return err
+		}
+	}
+	if len(config.NotifyEmail) > 0 && config.SMTPServer == "" {
+		return fmt.Errorf("-notify-email requires -smtp-server")
+	}
+	for _, addr := range append([]string{config.SMTPFrom}, config.NotifyEmail...) {
+		if addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid email address '%s'", addr)
+		}
+	}
+	if config.NotifyErrors < 0 {
+		return fmt.Errorf("-notify-errors must not be negative")
+	}
+	if config.NotifyInterval < 0 {
+		return fmt.Errorf("-notify-interval must not be negative")
+	}
+	return nil
+}
+
+// loadWebhookTemplate parses a -notify-webhook-template file. The template
+// receives the notification and can use json to quote any value.
+func loadWebhookTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook template: %w", err)
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// notifyStatePath returns the file the rate limit state is kept in, next to
+// the lock file unless -notify-state is set
+func notifyStatePath(config Config) string {
+	if config.NotifyState != "" {
+		return config.NotifyState
+	}
+	if config.LockFile != "" {
+		return config.LockFile + ".notify"
+	}
+	return ""
+}
+
+// buildNotification creates the notification of a run that ended with runErr
+func (bt *BackupTool) buildNotification(globPattern string, runErr error) notification {
+	hostname, _ := os.Hostname()
+	finished := time.Now().UTC()
+	started := bt.startTime.UTC()
+	if bt.startTime.IsZero() {
+		started = finished
+	}
+	n := notification{
+		ExitCode:    exitCode(runErr),
+		Job:         bt.config.JobName,
+		Host:        hostname,
+		Pattern:     globPattern,
+		Destination: bt.destinationName(),
+		Started:     started,
+		Finished:    finished,
+		Duration:    seconds(finished.Sub(started)),
+		Summary: notifySummary{
+			TotalFiles:      bt.stats.TotalFiles,
+			Uploaded:        bt.stats.Uploaded,
+			Deleted:         bt.stats.Deleted,
+			Skipped:         bt.stats.Skipped,
+			AlreadyArchived: bt.stats.AlreadyArchived,
+			Retained:        bt.stats.Retained,
+			Errors:          bt.stats.Errors,
+		},
+		Errors: []string{},
+	}
+	n.Status = runStatus(n.ExitCode)
+	if runErr != nil {
+		n.Error = redactSecrets(runErr.Error())
+		n.ErrorClass = runErrorClass(runErr)
+	}
+
+	for _, result := range bt.results {
+		if result.Error == "" {
+			continue
+		}
+		if len(n.Errors) >= bt.config.NotifyErrors {
+			n.MoreErrors++
+			continue
+		}
+		n.Errors = append(n.Errors, redactSecrets(result.Path+": "+result.Error))
+	}
+	return n
+}
+
+// notify sends the notification of the run to the configured channels.
+// Successful runs only notify with -notify-on always. A notification for
+// the same status as the last one sent is held back until -notify-interval
+// has passed, so a failing job does not send an alert every run.
+func (bt *BackupTool) notify(ctx context.Context, globPattern string, runErr error) error {
+	if !notifyEnabled(bt.config) {
+		return nil
+	}
+	n := bt.buildNotification(globPattern, runErr)
+	statePath := notifyStatePath(bt.config)
+
+	if n.Status == ReportSuccess && bt.config.NotifyOn != NotifyOnAlways {
+		// A new failure after a successful run is a new incident
+		if statePath != "" {
+			os.Remove(statePath)
+		}
+		return nil
+	}
+
+	var state notifyState
+	if statePath != "" {
+		state = readNotifyState(statePath)
+		if state.Status == n.Status && time.Since(state.Sent) < bt.config.NotifyInterval {
+			state.Suppressed++
+			bt.logger.Info("Notification suppressed by rate limit", "status", n.Status, "last_sent", state.Sent.Format(time.RFC3339), "suppressed", state.Suppressed)
+			return writeNotifyState(statePath, state)
+		}
+		if state.Status == n.Status {
+			n.Suppressed = state.Suppressed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	var errs []error
+	sent := 0
+	if bt.config.NotifyWebhook != "" {
+		if err := sendWebhook(ctx, bt.config, n); err != nil {
+			errs = append(errs, fmt.Errorf("webhook notification failed: %w", err))
+		} else {
+			sent++
+		}
+	}
+	if bt.config.NotifySlack != "" {
+		if err := sendSlack(ctx, bt.config.NotifySlack, n); err != nil {
+			errs = append(errs, fmt.Errorf("Slack notification failed: %w", err))
+		} else {
+			sent++
+		}
+	}
+	if len(bt.config.NotifyEmail) > 0 {
+		if err := sendEmail(ctx, bt.config, n); err != nil {
+			errs = append(errs, fmt.Errorf("email notification failed: %w", err))
+		} else {
+			sent++
+		}
+	}
+	if sent > 0 {
+		bt.logger.Info("Notification sent", "status", n.Status, "channels", sent)
+	}
+
+	// Keep the state of a notification that reached no channel, so the
+	// next run tries again
+	if statePath != "" && sent > 0 {
+		if err := writeNotifyState(statePath, notifyState{Status: n.Status, Sent: time.Now().UTC()}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readNotifyState reads the rate limit state. A missing or unreadable file
+// is treated as no notification sent yet.
+func readNotifyState(path string) notifyState {
+	var state notifyState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return notifyState{}
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return notifyState{}
+	}
+	return state
+}
+
+// writeNotifyState writes the rate limit state
+func writeNotifyState(path string, state notifyState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write notification state: %w", err)
+	}
+	return nil
+}
+
+// sendWebhook posts the notification as JSON, rendered through the
+// -notify-webhook-template if set
+func sendWebhook(ctx context.Context, config Config, n notification) error {
+	var body []byte
+	if config.NotifyWebhookTemplate == "" {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		body = data
+	} else {
+		tmpl, err := loadWebhookTemplate(config.NotifyWebhookTemplate)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return fmt.Errorf("failed to render webhook template: %w", err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return fmt.Errorf("webhook template did not produce valid JSON")
+		}
+		body = buf.Bytes()
+	}
+	return postJSON(ctx, config.NotifyWebhook, body)
+}
+
+// sendSlack posts the notification to a Slack-compatible incoming webhook
+func sendSlack(ctx context.Context, webhookURL string, n notification) error {
+	body, err := json.Marshal(map[string]string{"text": slackEmoji(n.Status) + " " + notificationText(n)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, webhookURL, body)
+}
+
+// postJSON posts a JSON body and checks for a 2xx response. The URL is left
+// out of errors because webhook URLs usually embed their secret.
+func postJSON(ctx context.Context, webhookURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "backup-log-to-s3/"+Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendEmail sends the notification as a plain text email. The password for
+// -smtp-user is read from SMTP_PASSWORD; STARTTLS is used when offered. The
+// whole conversation is bounded by ctx, since net/smtp has no timeouts and a
+// hung server would otherwise block the exit.
+func sendEmail(ctx context.Context, config Config, n notification) error {
+	addr := config.SMTPServer
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		addr = net.JoinHostPort(addr, "25")
+	}
+	from := config.SMTPFrom
+	if from == "" {
+		from = "backup-log-to-s3@" + n.Host
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if config.SMTPUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.SMTPUser, os.Getenv("SMTP_PASSWORD"), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range config.NotifyEmail {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildEmail(from, config.NotifyEmail, n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail formats the notification as an RFC 5322 message
+func buildEmail(from string, to []string, n notification) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", notificationTitle(n))
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.Finished.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(notificationText(n), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// notificationTitle is the first line of a notification and the email
+// subject
+func notificationTitle(n notification) string {
+	name := "backup-log-to-s3"
+	if n.Job != "" {
+		name += " " + n.Job
+	}
+	switch n.Status {
+	case ReportSuccess:
+		return fmt.Sprintf("[%s] Backup succeeded on %s", name, n.Host)
+	case ReportPartial:
+		return fmt.Sprintf("[%s] Backup partially failed on %s (exit code %d)", name, n.Host, n.ExitCode)
+	}
+	return fmt.Sprintf("[%s] Backup failed on %s (exit code %d)", name, n.Host, n.ExitCode)
+}
+
+// notificationText formats the notification as plain text for Slack and
+// email
+func notificationText(n notification) string {
+	var b strings.Builder
+	b.WriteString(notificationTitle(n))
+	fmt.Fprintf(&b, "\nPattern: %s\nDestination: %s\n", n.Pattern, n.Destination)
+	s := n.Summary
+	fmt.Fprintf(&b, "Files: %d total, %d uploaded, %d deleted, %d skipped", s.TotalFiles, s.Uploaded, s.Deleted, s.Skipped)
+	if s.AlreadyArchived > 0 || s.Retained > 0 {
+		fmt.Fprintf(&b, ", %d already archived, %d retained", s.AlreadyArchived, s.Retained)
+	}
+	fmt.Fprintf(&b, ", %d errors\nDuration: %.1fs", s.Errors, n.Duration)
+	if n.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s", n.Error)
+	}
+	if len(n.Errors) > 0 {
+		b.WriteString("\nErrors:")
+		for _, e := range n.Errors {
+			b.WriteString("\n- " + e)
+		}
+		if n.MoreErrors > 0 {
+			fmt.Fprintf(&b, "\n... and %d more", n.MoreErrors)
+		}
+	}
+	if n.Suppressed > 0 {
+		fmt.Fprintf(&b, "\n%d similar notifications were suppressed since the last one", n.Suppressed)
+	}
+	return b.String()
+}
+
+// slackEmoji marks the status of a Slack message
+func slackEmoji(status string) string {
+	switch status {
+	case ReportSuccess:
+		return ":white_check_mark:"
+	case ReportPartial:
+		return ":warning:"
+	}
+	return ":x:"
+}
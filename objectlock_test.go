@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestValidateObjectLock tests validation of the Object Lock options
+func TestValidateObjectLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"No object lock", Config{}, false},
+		{"Legal hold only", Config{LegalHold: true}, false},
+		{"Compliance", Config{ObjectLockMode: "COMPLIANCE", ObjectLockRetain: "7 years", ObjectLockFrom: ObjectLockFromUpload}, false},
+		{"Governance from file date", Config{ObjectLockMode: "GOVERNANCE", ObjectLockRetain: "90 days", ObjectLockFrom: ObjectLockFromFileDate}, false},
+		{"Invalid mode", Config{ObjectLockMode: "WORM", ObjectLockRetain: "7 years", ObjectLockFrom: ObjectLockFromUpload}, true},
+		{"Mode without retain", Config{ObjectLockMode: "COMPLIANCE", ObjectLockFrom: ObjectLockFromUpload}, true},
+		{"Retain without mode", Config{ObjectLockRetain: "7 years"}, true},
+		{"Invalid retain", Config{ObjectLockMode: "COMPLIANCE", ObjectLockRetain: "forever", ObjectLockFrom: ObjectLockFromUpload}, true},
+		{"Invalid from", Config{ObjectLockMode: "COMPLIANCE", ObjectLockRetain: "7 years", ObjectLockFrom: "mtime"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateObjectLock(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateObjectLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestAddPeriod tests calendar arithmetic for retention periods
+func TestAddPeriod(t *testing.T) {
+	base := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		period string
+		want   time.Time
+	}{
+		{"30 days", time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)},
+		{"1 month", time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)},
+		{"4 years", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := addPeriod(base, tt.period)
+		if err != nil {
+			t.Fatalf("addPeriod(%s) error = %v", tt.period, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("addPeriod(%s) = %v, want %v", tt.period, got, tt.want)
+		}
+	}
+
+	if _, err := addPeriod(base, "forever"); err == nil {
+		t.Error("addPeriod() should reject an invalid period")
+	}
+}
+
+// TestObjectLockRetainUntil tests the retention base for upload and file-date modes
+func TestObjectLockRetainUntil(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	bt := &BackupTool{config: Config{ObjectLockMode: "COMPLIANCE", ObjectLockRetain: "7 years", ObjectLockFrom: ObjectLockFromUpload}}
+
+	got, err := bt.objectLockRetainUntil("logs/app20241215.log", "/var/log/app20241215.log", now)
+	if err != nil {
+		t.Fatalf("objectLockRetainUntil() error = %v", err)
+	}
+	if want := time.Date(2032, 1, 10, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("objectLockRetainUntil(upload) = %v, want %v", got, want)
+	}
+
+	bt.config.ObjectLockFrom = ObjectLockFromFileDate
+	got, _ = bt.objectLockRetainUntil("logs/app20241215.log", "/var/log/app20241215.log", now)
+	if want := time.Date(2031, 12, 15, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("objectLockRetainUntil(file-date) = %v, want %v", got, want)
+	}
+
+	// Falls back to the key, then to the upload time
+	got, _ = bt.objectLockRetainUntil("logs/2024/11/01/app.log", "", now)
+	if want := time.Date(2031, 11, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("objectLockRetainUntil(key date) = %v, want %v", got, want)
+	}
+	got, _ = bt.objectLockRetainUntil("logs/app.log", "", now)
+	if want := time.Date(2032, 1, 10, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("objectLockRetainUntil(no date) = %v, want %v", got, want)
+	}
+}
+
+// TestApplyObjectLock tests the Object Lock fields set on PutObject requests
+func TestApplyObjectLock(t *testing.T) {
+	var buf bytes.Buffer
+	bt := &BackupTool{
+		config: Config{
+			S3Bucket:         "audit-logs",
+			ObjectLockMode:   "COMPLIANCE",
+			ObjectLockRetain: "7 years",
+			ObjectLockFrom:   ObjectLockFromFileDate,
+			LegalHold:        true,
+		},
+		logger: slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+
+	input := &PutRequest{Key: "audit/app20241215.log"}
+	if err := bt.applyObjectLock(input, "/var/log/app20241215.log"); err != nil {
+		t.Fatalf("applyObjectLock() error = %v", err)
+	}
+	if input.ObjectLockMode != "COMPLIANCE" {
+		t.Errorf("ObjectLockMode = %s, want COMPLIANCE", input.ObjectLockMode)
+	}
+	if input.RetainUntil.Year() != 2031 {
+		t.Errorf("RetainUntil = %v, want 2031-12-15", input.RetainUntil)
+	}
+	if !input.LegalHold {
+		t.Error("LegalHold = false, want true")
+	}
+
+	// A file already past its retention fails unless allowed explicitly,
+	// then it is uploaded without a retention date
+	bt.config.ObjectLockRetain = "1 day"
+	input = &PutRequest{Key: "audit/app20200101.log"}
+	if err := bt.applyObjectLock(input, "/var/log/app20200101.log"); err == nil || !strings.Contains(err.Error(), "-object-lock-allow-expired") {
+		t.Fatalf("applyObjectLock() error = %v, want an expired retention error", err)
+	}
+	bt.config.ObjectLockAllowExpired = true
+	input = &PutRequest{Key: "audit/app20200101.log"}
+	if err := bt.applyObjectLock(input, "/var/log/app20200101.log"); err != nil {
+		t.Fatalf("applyObjectLock() error = %v", err)
+	}
+	if input.ObjectLockMode != "" || !input.RetainUntil.IsZero() {
+		t.Errorf("Expected no retention for expired file, got %s until %v", input.ObjectLockMode, input.RetainUntil)
+	}
+	if !strings.Contains(buf.String(), "retention already expired") {
+		t.Errorf("Expected expiry log, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	LayoutHive = "hive"
+
+	PartitionsFormatDDL  = "ddl"
+	PartitionsFormatJSON = "json"
+)
+
+// hivePartitionTemplate is appended to the prefix by -layout hive
+const hivePartitionTemplate = "year={year}/month={month}/day={day}/host={host}"
+
+// partition is one Hive-style partition written during a run
+type partition struct {
+	Columns  []string `json:"columns"`
+	Values   []string `json:"values"`
+	Location string   `json:"location"`
+}
+
+// partitionReport is the JSON description of the partitions of a run
+type partitionReport struct {
+	Table      string      `json:"table,omitempty"`
+	Partitions []partition `json:"partitions"`
+}
+
+// validateLayout checks the -layout preset against the prefix
+func validateLayout(config Config) error {
+	switch config.Layout {
+	case "":
+	case LayoutHive:
+		tmpl, err := parsePrefixTemplate(config.S3Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid prefix '%s': %w", config.S3Prefix, err)
+		}
+		if len(tmpl.variables()) > 0 {
+			return fmt.Errorf("-layout hive uses the prefix as the table location; it must not contain template variables")
+		}
+	default:
+		return fmt.Errorf("invalid layout: %s (supported: %s)", config.Layout, LayoutHive)
+	}
+	return nil
+}
+
+// validatePartitionOptions checks the partition output options
+func validatePartitionOptions(config Config) error {
+	switch config.PartitionsFormat {
+	case PartitionsFormatJSON:
+	case PartitionsFormatDDL:
+		if config.PartitionsOutput != "" && config.AthenaTable == "" {
+			return fmt.Errorf("-partitions-format ddl requires -athena-table")
+		}
+	default:
+		return fmt.Errorf("invalid partitions format: %s (supported: %s, %s)", config.PartitionsFormat, PartitionsFormatDDL, PartitionsFormatJSON)
+	}
+	return nil
+}
+
+// layoutPrefix returns the prefix template for the layout preset
+func layoutPrefix(layout, prefix string) string {
+	if layout != LayoutHive {
+		return prefix
+	}
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix == "" {
+		return hivePartitionTemplate
+	}
+	return prefix + "/" + hivePartitionTemplate
+}
+
+// partitionFromKey returns the Hive partition an object key belongs to. The
+// partition columns are the name=value directory segments of the key.
+func partitionFromKey(bucket, key string) (partition, bool) {
+	dir := path.Dir(key)
+	if dir == "." {
+		return partition{}, false
+	}
+
+	var p partition
+	for _, segment := range strings.Split(dir, "/") {
+		name, value, ok := strings.Cut(segment, "=")
+		if !ok || name == "" {
+			if len(p.Columns) > 0 {
+				// Only a contiguous run of name=value segments forms the partition
+				break
+			}
+			continue
+		}
+		p.Columns = append(p.Columns, name)
+		p.Values = append(p.Values, value)
+	}
+	if len(p.Columns) == 0 {
+		return partition{}, false
+	}
+	p.Location = fmt.Sprintf("s3://%s/%s/", bucket, dir)
+	return p, true
+}
+
+// runPartitions returns the partitions of the objects uploaded in this run,
+// sorted by location
+func (bt *BackupTool) runPartitions() []partition {
+	seen := make(map[string]bool)
+	var partitions []partition
+	for _, result := range bt.results {
+		if result.Outcome != OutcomeUploaded && result.Outcome != OutcomeDryRun {
+			continue
+		}
+		p, ok := partitionFromKey(bt.config.S3Bucket, result.Key)
+		if !ok || seen[p.Location] {
+			continue
+		}
+		seen[p.Location] = true
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Location < partitions[j].Location })
+	return partitions
+}
+
+// writePartitions writes the partitions as Athena DDL or JSON
+func writePartitions(w io.Writer, partitions []partition, format, table string) error {
+	if format == PartitionsFormatJSON {
+		if partitions == nil {
+			partitions = []partition{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(partitionReport{Table: table, Partitions: partitions})
+	}
+
+	if len(partitions) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "ALTER TABLE %s ADD IF NOT EXISTS\n", table); err != nil {
+		return err
+	}
+	for i, p := range partitions {
+		specs := make([]string, len(p.Columns))
+		for j, column := range p.Columns {
+			specs[j] = fmt.Sprintf("%s = '%s'", column, sqlQuote(p.Values[j]))
+		}
+		end := ""
+		if i == len(partitions)-1 {
+			end = ";"
+		}
+		if _, err := fmt.Fprintf(w, "  PARTITION (%s) LOCATION '%s'%s\n", strings.Join(specs, ", "), sqlQuote(p.Location), end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlQuote escapes single quotes in a SQL string literal
+func sqlQuote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+// writePartitionsOutput writes the partitions of the run to -partitions-output
+func (bt *BackupTool) writePartitionsOutput() error {
+	partitions := bt.runPartitions()
+
+	if bt.config.PartitionsOutput == "-" {
+		if err := writePartitions(os.Stdout, partitions, bt.config.PartitionsFormat, bt.config.AthenaTable); err != nil {
+			return fmt.Errorf("failed to write partitions: %w", err)
+		}
+	} else {
+		file, err := os.Create(bt.config.PartitionsOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create partitions output: %w", err)
+		}
+		err = writePartitions(file, partitions, bt.config.PartitionsFormat, bt.config.AthenaTable)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write partitions: %w", err)
+		}
+	}
+	bt.logger.Info("Wrote partitions", "count", len(partitions), LogFieldFile, bt.config.PartitionsOutput)
+	return nil
+}
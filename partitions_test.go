@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestValidateLayout tests validation of -layout against the prefix
+func TestValidateLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"No layout", Config{S3Prefix: "logs/{year}"}, false},
+		{"Hive with static prefix", Config{S3Prefix: "tables/app", Layout: LayoutHive}, false},
+		{"Hive with templated prefix", Config{S3Prefix: "tables/{year}", Layout: LayoutHive}, true},
+		{"Hive with legacy tokens", Config{S3Prefix: "tables/YYYY", Layout: LayoutHive}, true},
+		{"Unknown layout", Config{S3Prefix: "tables/app", Layout: "flat"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLayout(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestValidatePartitionOptions tests validation of the partition output options
+func TestValidatePartitionOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"DDL with table", Config{PartitionsFormat: PartitionsFormatDDL, PartitionsOutput: "-", AthenaTable: "db.logs"}, false},
+		{"DDL without table", Config{PartitionsFormat: PartitionsFormatDDL, PartitionsOutput: "-"}, true},
+		{"DDL without output", Config{PartitionsFormat: PartitionsFormatDDL}, false},
+		{"JSON without table", Config{PartitionsFormat: PartitionsFormatJSON, PartitionsOutput: "-"}, false},
+		{"Unknown format", Config{PartitionsFormat: "csv"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePartitionOptions(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePartitionOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLayoutPrefix tests building the prefix template for a layout
+func TestLayoutPrefix(t *testing.T) {
+	tests := []struct {
+		layout string
+		prefix string
+		want   string
+	}{
+		{"", "logs/{year}", "logs/{year}"},
+		{LayoutHive, "tables/app", "tables/app/year={year}/month={month}/day={day}/host={host}"},
+		{LayoutHive, "tables/app/", "tables/app/year={year}/month={month}/day={day}/host={host}"},
+	}
+
+	for _, tt := range tests {
+		if got := layoutPrefix(tt.layout, tt.prefix); got != tt.want {
+			t.Errorf("layoutPrefix(%q, %q) = %q, want %q", tt.layout, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// TestPartitionFromKey tests reading partition columns from object keys
+func TestPartitionFromKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   partition
+		wantOK bool
+	}{
+		{
+			key: "tables/app/year=2024/month=12/day=15/host=web01/app-20241215.log.gz",
+			want: partition{
+				Columns:  []string{"year", "month", "day", "host"},
+				Values:   []string{"2024", "12", "15", "web01"},
+				Location: "s3://bucket/tables/app/year=2024/month=12/day=15/host=web01/",
+			},
+			wantOK: true,
+		},
+		{
+			key: "logs/dt=2024-12-15/nginx/app.log",
+			want: partition{
+				Columns:  []string{"dt"},
+				Values:   []string{"2024-12-15"},
+				Location: "s3://bucket/logs/dt=2024-12-15/nginx/",
+			},
+			wantOK: true,
+		},
+		{key: "logs/2024/12/app.log", wantOK: false},
+		{key: "app.log", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := partitionFromKey("bucket", tt.key)
+		if ok != tt.wantOK {
+			t.Errorf("partitionFromKey(%s) ok = %v, want %v", tt.key, ok, tt.wantOK)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("partitionFromKey(%s) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestRunPartitions tests collecting the partitions of a run
+func TestRunPartitions(t *testing.T) {
+	bt := &BackupTool{
+		config: Config{S3Bucket: "bucket"},
+		results: []*fileResult{
+			{Key: "t/year=2024/month=12/day=16/host=a/app-20241216.log", Outcome: OutcomeUploaded},
+			{Key: "t/year=2024/month=12/day=15/host=a/app-20241215.log", Outcome: OutcomeUploaded},
+			{Key: "t/year=2024/month=12/day=15/host=a/db-20241215.log", Outcome: OutcomeUploaded},
+			{Key: "t/year=2024/month=12/day=14/host=a/app-20241214.log", Outcome: OutcomeFailed},
+			{Key: "t/year=2024/month=12/day=13/host=a/app-20241213.log", Outcome: OutcomeAlreadyArchived},
+		},
+	}
+
+	partitions := bt.runPartitions()
+	if len(partitions) != 2 {
+		t.Fatalf("Expected 2 partitions, got %+v", partitions)
+	}
+	if got := partitions[0].Values[2]; got != "15" {
+		t.Errorf("Expected partitions sorted by location, first day = %s", got)
+	}
+}
+
+// TestWritePartitions tests the DDL and JSON partition output
+func TestWritePartitions(t *testing.T) {
+	partitions := []partition{
+		{Columns: []string{"year", "host"}, Values: []string{"2024", "web01"}, Location: "s3://b/t/year=2024/host=web01/"},
+		{Columns: []string{"year", "host"}, Values: []string{"2024", "o'brien"}, Location: "s3://b/t/year=2024/host=o'brien/"},
+	}
+
+	var ddl bytes.Buffer
+	if err := writePartitions(&ddl, partitions, PartitionsFormatDDL, "db.logs"); err != nil {
+		t.Fatalf("writePartitions() error = %v", err)
+	}
+	want := "ALTER TABLE db.logs ADD IF NOT EXISTS\n" +
+		"  PARTITION (year = '2024', host = 'web01') LOCATION 's3://b/t/year=2024/host=web01/'\n" +
+		"  PARTITION (year = '2024', host = 'o''brien') LOCATION 's3://b/t/year=2024/host=o''brien/';\n"
+	if ddl.String() != want {
+		t.Errorf("DDL output =\n%s\nwant\n%s", ddl.String(), want)
+	}
+
+	var empty bytes.Buffer
+	if err := writePartitions(&empty, nil, PartitionsFormatDDL, "db.logs"); err != nil || empty.Len() != 0 {
+		t.Errorf("Expected no DDL without partitions, got %q (%v)", empty.String(), err)
+	}
+
+	var out bytes.Buffer
+	if err := writePartitions(&out, partitions[:1], PartitionsFormatJSON, "db.logs"); err != nil {
+		t.Fatalf("writePartitions() error = %v", err)
+	}
+	var report partitionReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %v", err)
+	}
+	if report.Table != "db.logs" || len(report.Partitions) != 1 || report.Partitions[0].Location != partitions[0].Location {
+		t.Errorf("Unexpected JSON report: %+v", report)
+	}
+}
+
+// TestPartitionsToStdoutMovesLogs tests that log lines leave stdout when it
+// carries the partitions, so the DDL can be piped into Athena
+func TestPartitionsToStdoutMovesLogs(t *testing.T) {
+	if got := consoleOutput(Config{PartitionsOutput: "-"}); got != os.Stderr {
+		t.Error("Expected logs on stderr with -partitions-output -")
+	}
+	if got := consoleOutput(Config{PartitionsOutput: "partitions.sql"}); got != os.Stdout {
+		t.Error("Expected logs on stdout when the partitions go to a file")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ioprio_set(2) constants
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioIdleLevel  = 7
+)
+
+// setNice sets the nice value of every thread of the process. Linux keeps
+// the nice value per thread and new threads inherit it from their creator,
+// so threads the Go runtime already started must be changed one by one.
+func setNice(nice int) error {
+	return forEachThread(func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// setIOPriority sets the IO scheduling class and level of every thread
+func setIOPriority(class string, level int) error {
+	prio := ioprioClassBE<<ioprioClassShift | level
+	if class == IOClassIdle {
+		prio = ioprioClassIdle<<ioprioClassShift | ioprioIdleLevel
+	}
+	return forEachThread(func(tid int) error {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// forEachThread calls fn with the ID of every thread of the process
+func forEachThread(fn func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fn(0)
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// A thread may exit while we iterate
+		if err := fn(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// setNice is only implemented on Linux
+func setNice(nice int) error {
+	return errPriorityUnsupported
+}
+
+// setIOPriority is only implemented on Linux
+func setIOPriority(class string, level int) error {
+	return errPriorityUnsupported
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	PruneActionDelete     = "delete"
+	PruneActionTransition = "transition"
+
+	AgeFromKey      = "key"
+	AgeFromMetadata = "metadata"
+
+	DefaultPruneMaxObjects = 1000
+
+	// deleteObjectsBatchSize is the maximum number of keys per DeleteObjects request
+	deleteObjectsBatchSize = 1000
+)
+
+// PruneOptions holds the options for the prune-remote subcommand
+type PruneOptions struct {
+	OlderThan    string
+	Action       string
+	StorageClass string
+	AgeFrom      string
+	Filter       string
+	MaxObjects   int
+}
+
+// pruneStats holds the statistics for a prune operation
+type pruneStats struct {
+	Candidates   int
+	Deleted      int
+	Transitioned int
+	Capped       int
+	Skipped      int
+	Errors       int
+}
+
+// parsePruneFlags parses the arguments of the prune-remote subcommand
+func parsePruneFlags(args []string) (Config, PruneOptions, error) {
+	var config Config
+	var opts PruneOptions
+
+	fs := flag.NewFlagSet("prune-remote", flag.ContinueOnError)
+	fs.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required with -backend s3)")
+	fs.StringVar(&config.S3Prefix, "prefix", "", "S3 prefix template the logs were uploaded with (e.g. logs/{year}/{month}) (required)")
+	fs.StringVar(&config.OutputFile, "output", "", "Output log file path (outputs to stdout if not specified)")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "Only report the objects that would be pruned")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Verbose logging")
+	fs.StringVar(&opts.OlderThan, "older-than", "", "Remote retention period; objects older than this are pruned (e.g. \"1 year\") (required)")
+	fs.StringVar(&opts.Action, "action", PruneActionDelete, "What to do with expired objects (delete, transition)")
+	fs.StringVar(&opts.StorageClass, "transition-storage-class", "", "Storage class to transition expired objects to (required with -action transition)")
+	fs.StringVar(&opts.AgeFrom, "age-from", AgeFromKey, "Where to read an object's date from (key, metadata)")
+	fs.StringVar(&opts.Filter, "filter", "", "Only prune files whose name matches this glob (e.g. \"nginx-*\")")
+	fs.IntVar(&opts.MaxObjects, "max-objects", DefaultPruneMaxObjects, "Maximum number of objects one run may delete or transition")
+	addLogFlags(fs, &config)
+	addStoreFlags(fs, &config)
+	addAWSFlags(fs, &config)
+	fs.Usage = func() { showPruneUsage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return config, opts, err
+	}
+
+	var errs []string
+	if err := applyDestination(&config); err != nil {
+		errs = append(errs, err.Error())
+	} else if err := validateStore(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if config.S3Prefix == "" && config.Destination == "" {
+		errs = append(errs, "S3 prefix is required (use -prefix flag or -dest)")
+	} else if _, err := parsePrefixTemplate(config.S3Prefix); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid prefix '%s': %v", config.S3Prefix, err))
+	} else if staticPrefix(config.S3Prefix) == "" {
+		// Otherwise the whole bucket would be listed and pruned
+		errs = append(errs, fmt.Sprintf("invalid prefix '%s': it must start with a fixed path segment (e.g. logs/YYYY/MM)", config.S3Prefix))
+	}
+	if opts.OlderThan == "" {
+		errs = append(errs, "remote retention period is required (use -older-than flag)")
+	} else if _, err := parsePeriod(opts.OlderThan); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid -older-than '%s': %v", opts.OlderThan, err))
+	}
+	switch opts.Action {
+	case PruneActionDelete:
+	case PruneActionTransition:
+		if opts.StorageClass == "" {
+			errs = append(errs, "-transition-storage-class is required with -action transition")
+		}
+		if !isS3Backend(config) {
+			errs = append(errs, "-action transition is only supported with -backend s3")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("invalid action: %s (supported: delete, transition)", opts.Action))
+	}
+	if opts.AgeFrom != AgeFromKey && opts.AgeFrom != AgeFromMetadata {
+		errs = append(errs, fmt.Sprintf("invalid -age-from: %s (supported: key, metadata)", opts.AgeFrom))
+	}
+	if opts.MaxObjects < 1 {
+		errs = append(errs, "-max-objects must be at least 1")
+	}
+
+	if len(errs) > 0 {
+		return config, opts, errors.New(strings.Join(errs, "\n"))
+	}
+	return config, opts, nil
+}
+
+func showPruneUsage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `Usage: %s prune-remote [OPTIONS]
+
+Deletes or transitions archived objects under the prefix that are older than
+the remote retention period. An object's age comes from the date in its key
+(file name or YYYY/MM/DD prefix) or from its backup-date metadata. Only keys
+laid out by the prefix template are pruned, and the prefix must start with a
+fixed path segment. At most -max-objects objects are pruned per run, oldest
+first.
+
+OPTIONS:
+`, os.Args[0])
+	fs.PrintDefaults()
+	fmt.Fprintf(fs.Output(), `
+EXAMPLES:
+  %s prune-remote -bucket my-logs -prefix "logs/YYYY/MM" -older-than "1 year" -dry-run
+  %s prune-remote -bucket my-logs -prefix logs -older-than "90 days" -action transition -transition-storage-class DEEP_ARCHIVE
+`, os.Args[0], os.Args[0])
+}
+
+// runPrune is the entry point of the prune-remote subcommand
+func runPrune(args []string) error {
+	config, opts, err := parsePruneFlags(args)
+	if err != nil {
+		return &configError{err}
+	}
+
+	logger, err := newLogger(config)
+	if err != nil {
+		return err
+	}
+	cutoffTime, err := calculateCutoffTime(opts.OlderThan)
+	if err != nil {
+		return fmt.Errorf("invalid -older-than '%s': %w", opts.OlderThan, err)
+	}
+	bt := &BackupTool{config: config, logger: logger, cutoffTime: cutoffTime}
+
+	ctx := context.Background()
+	if err := bt.initStore(ctx); err != nil {
+		return err
+	}
+	defer bt.closeStore()
+	return bt.PruneRemote(ctx, opts)
+}
+
+// objectDateFromKey returns the date encoded in the object's file name or,
+// failing that, in its date-templated key prefix
+func objectDateFromKey(key string) (time.Time, bool) {
+	if date, err := extractDateFromFilename(path.Base(key)); err == nil {
+		return date, true
+	}
+	if date, err := extractDateFromFilename(key); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// PruneRemote deletes or transitions objects older than the cutoff
+func (bt *BackupTool) PruneRemote(ctx context.Context, opts PruneOptions) error {
+	started := time.Now()
+	bt.logger.Info("Remote prune process started", "cutoff", bt.cutoffTime.Format("2006-01-02 15:04:05"))
+
+	objects, err := bt.listArchivedObjects(ctx, time.Time{}, time.Time{}, opts.Filter)
+	if err != nil {
+		return err
+	}
+	objects, err = bt.matchPrefixTemplate(objects)
+	if err != nil {
+		return err
+	}
+
+	stats := &pruneStats{}
+	expired := bt.selectExpiredObjects(ctx, objects, opts.AgeFrom, stats)
+	stats.Candidates = len(expired)
+
+	// Oldest first, so a capped run always makes progress on the backlog
+	sort.SliceStable(expired, func(i, j int) bool { return expired[i].Date.Before(expired[j].Date) })
+	if len(expired) > opts.MaxObjects {
+		stats.Capped = len(expired) - opts.MaxObjects
+		bt.logger.Warn("Safety cap reached (raise -max-objects to prune more per run)", "count", opts.MaxObjects, "expired", len(expired))
+		expired = expired[:opts.MaxObjects]
+	}
+
+	switch opts.Action {
+	case PruneActionTransition:
+		bt.transitionObjects(ctx, expired, opts.StorageClass, stats)
+	default:
+		bt.deleteObjects(ctx, expired, stats)
+	}
+
+	bt.logger.Info("Prune summary",
+		"expired", stats.Candidates,
+		"deleted", stats.Deleted,
+		"transitioned", stats.Transitioned,
+		"capped", stats.Capped,
+		"skipped", stats.Skipped,
+		"errors", stats.Errors,
+	)
+
+	if stats.Errors > 0 {
+		return fmt.Errorf("prune completed with %d errors", stats.Errors)
+	}
+	bt.logger.Info("Remote prune process completed successfully", durationAttr(time.Since(started)))
+	return nil
+}
+
+// matchPrefixTemplate keeps the objects whose key is an expansion of the
+// prefix template followed by a file name, so that objects of other tools
+// sharing the static prefix are never pruned
+func (bt *BackupTool) matchPrefixTemplate(objects []archivedObject) ([]archivedObject, error) {
+	tmpl, err := parsePrefixTemplate(bt.config.S3Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix '%s': %w", bt.config.S3Prefix, err)
+	}
+	pattern := tmpl.keyPattern()
+
+	var matched []archivedObject
+	for _, obj := range objects {
+		if !pattern.MatchString(obj.Key) {
+			bt.logger.Debug("Ignored (key does not match the prefix)", LogFieldKey, bt.objectURL(obj.Key))
+			continue
+		}
+		matched = append(matched, obj)
+	}
+	return matched, nil
+}
+
+// selectExpiredObjects returns the objects whose date is before the cutoff
+func (bt *BackupTool) selectExpiredObjects(ctx context.Context, objects []archivedObject, ageFrom string, stats *pruneStats) []archivedObject {
+	var expired []archivedObject
+	for _, obj := range objects {
+		date, ok := time.Time{}, false
+		if ageFrom == AgeFromKey {
+			date, ok = objectDateFromKey(obj.Key)
+		}
+		if !ok {
+			var err error
+			date, ok, err = bt.objectBackupDate(ctx, obj.Key)
+			if err != nil {
+				bt.logger.Error("Failed to read metadata", LogFieldKey, bt.objectURL(obj.Key), errAttr(err))
+				stats.Errors++
+				continue
+			}
+		}
+		if !ok {
+			bt.logger.Warn("Skipped (no date in key or metadata)", LogFieldKey, bt.objectURL(obj.Key))
+			stats.Skipped++
+			continue
+		}
+
+		dateNormalized := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, bt.cutoffTime.Location())
+		if dateNormalized.Before(bt.cutoffTime) {
+			obj.Date = date
+			expired = append(expired, obj)
+		}
+	}
+	return expired
+}
+
+// objectBackupDate reads the backup-date metadata of an object
+func (bt *BackupTool) objectBackupDate(ctx context.Context, key string) (time.Time, bool, error) {
+	head, err := bt.store.Head(ctx, key)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	value, ok := head.Metadata["backup-date"]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, nil
+	}
+	return date, true, nil
+}
+
+// deleteObjects deletes the objects in batches of up to 1000 keys
+func (bt *BackupTool) deleteObjects(ctx context.Context, objects []archivedObject, stats *pruneStats) {
+	for start := 0; start < len(objects); start += deleteObjectsBatchSize {
+		end := start + deleteObjectsBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		batch := objects[start:end]
+
+		if bt.config.DryRun {
+			for _, obj := range batch {
+				bt.logger.Info("DRY RUN: Would delete", LogFieldKey, bt.objectURL(obj.Key), "date", obj.Date.Format("2006-01-02"))
+			}
+			stats.Deleted += len(batch)
+			continue
+		}
+
+		keys := make([]string, len(batch))
+		for i, obj := range batch {
+			keys[i] = obj.Key
+		}
+		failed, err := bt.store.Delete(ctx, keys)
+		if err != nil {
+			bt.logger.Error("Delete failed", "count", len(batch), errAttr(err))
+			stats.Errors += len(batch)
+			continue
+		}
+
+		for _, key := range keys {
+			if err, ok := failed[key]; ok {
+				bt.logger.Error("Delete failed", LogFieldKey, bt.objectURL(key), errAttr(err))
+			}
+		}
+		stats.Errors += len(failed)
+		stats.Deleted += len(batch) - len(failed)
+		bt.logger.Info("Deleted objects", "count", len(batch)-len(failed))
+	}
+}
+
+// copySource returns the URL-encoded bucket/key for CopyObject, keeping the
+// slashes between key segments
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// transitionObjects copies each object onto itself with a new storage class
+func (bt *BackupTool) transitionObjects(ctx context.Context, objects []archivedObject, storageClass string, stats *pruneStats) {
+	store, ok := bt.store.(*s3Store)
+	if !ok {
+		bt.logger.Error("Storage class transitions are only supported with -backend s3")
+		stats.Errors += len(objects)
+		return
+	}
+	if len(objects) == 0 {
+		return
+	}
+	// A copy is a new object version without the retention or legal hold of
+	// the original, and the locked version could not be removed anyway
+	if locked, err := store.objectLockEnabled(ctx); err != nil || locked {
+		if err == nil {
+			err = errors.New("the bucket has Object Lock enabled; use a lifecycle rule instead")
+		}
+		bt.logger.Error("Storage class transitions are not supported", errAttr(err))
+		stats.Errors += len(objects)
+		return
+	}
+	for _, obj := range objects {
+		if obj.StorageClass == storageClass {
+			stats.Skipped++
+			continue
+		}
+		if bt.config.DryRun {
+			bt.logger.Info("DRY RUN: Would transition", LogFieldKey, bt.objectURL(obj.Key), "from", obj.StorageClass, "to", storageClass)
+			stats.Transitioned++
+			continue
+		}
+
+		if err := store.setStorageClass(ctx, obj.Key, storageClass); err != nil {
+			bt.logger.Error("Transition failed", LogFieldKey, bt.objectURL(obj.Key), errAttr(err))
+			stats.Errors++
+			continue
+		}
+		bt.logger.Info("Transitioned", LogFieldKey, bt.objectURL(obj.Key), "storage_class", storageClass)
+		stats.Transitioned++
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// TestParsePruneFlags tests parsing and validation of prune-remote options
+func TestParsePruneFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{
+			name: "Valid delete",
+			args: []string{"-bucket", "my-logs", "-prefix", "logs/YYYY/MM", "-older-than", "1 year", "-dry-run"},
+		},
+		{
+			name: "Valid transition",
+			args: []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "90 days", "-action", "transition", "-transition-storage-class", "DEEP_ARCHIVE"},
+		},
+		{
+			name:    "Missing older-than",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid older-than",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "forever"},
+			wantErr: true,
+		},
+		{
+			name:    "Transition without storage class",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "1 year", "-action", "transition"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid action",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "1 year", "-action", "archive"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid age-from",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "1 year", "-age-from", "mtime"},
+			wantErr: true,
+		},
+		{
+			name:    "Prefix starting with a date",
+			args:    []string{"-bucket", "my-logs", "-prefix", "YYYY/MM", "-older-than", "1 year"},
+			wantErr: true,
+		},
+		{
+			name:    "Prefix starting with a variable",
+			args:    []string{"-bucket", "my-logs", "-prefix", "dt={date}", "-older-than", "1 year"},
+			wantErr: true,
+		},
+		{
+			name:    "Zero max-objects",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-older-than", "1 year", "-max-objects", "0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parsePruneFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePruneFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestObjectDateFromKey tests reading dates from file names and key prefixes
+func TestObjectDateFromKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{"logs/2024/12/15/app20241215.log.gz", "2024-12-15", true},
+		{"logs/host/app-2024-11-01.log", "2024-11-01", true},
+		{"logs/2024/10/03/app.log", "2024-10-03", true},
+		{"logs/app.log", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := objectDateFromKey(tt.key)
+		if ok != tt.wantOK {
+			t.Errorf("objectDateFromKey(%s) ok = %v, want %v", tt.key, ok, tt.wantOK)
+			continue
+		}
+		if ok && got.Format("2006-01-02") != tt.want {
+			t.Errorf("objectDateFromKey(%s) = %s, want %s", tt.key, got.Format("2006-01-02"), tt.want)
+		}
+	}
+}
+
+// TestCopySource tests URL encoding of the CopyObject source
+func TestCopySource(t *testing.T) {
+	got := copySource("my-logs", "logs/2024/12/15/app 20241215+1.log")
+	want := "my-logs/logs/2024/12/15/app%2020241215+1.log"
+	if got != want {
+		t.Errorf("copySource() = %s, want %s", got, want)
+	}
+}
+
+// TestSelectExpiredObjects tests selecting expired objects by key date and
+// deleting them in dry-run mode
+func TestSelectExpiredObjects(t *testing.T) {
+	var buf bytes.Buffer
+	bt := &BackupTool{
+		config:     Config{S3Bucket: "my-logs", DryRun: true},
+		logger:     slog.New(slog.NewTextHandler(&buf, nil)),
+		cutoffTime: time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local),
+	}
+
+	objects := []archivedObject{
+		{Key: "logs/2024/11/20/app20241120.log"},
+		{Key: "logs/2024/10/05/app20241005.log"},
+		{Key: "logs/2024/12/01/app20241201.log"},
+		{Key: "logs/2024/11/30/app20241130.log"},
+	}
+
+	stats := &pruneStats{}
+	expired := bt.selectExpiredObjects(context.Background(), objects, AgeFromKey, stats)
+	if len(expired) != 3 {
+		t.Fatalf("Expected 3 expired objects, got %d", len(expired))
+	}
+	for _, obj := range expired {
+		if obj.Date.IsZero() {
+			t.Errorf("Expected date to be set for %s", obj.Key)
+		}
+	}
+
+	// Dry-run deletion must not touch S3 and should report each object
+	bt.deleteObjects(context.Background(), expired[:2], stats)
+	if stats.Deleted != 2 {
+		t.Errorf("Expected 2 dry-run deletions, got %d", stats.Deleted)
+	}
+	if !strings.Contains(buf.String(), `msg="DRY RUN: Would delete" key=s3://my-logs/logs/2024/11/20/app20241120.log`) {
+		t.Errorf("Expected dry-run log, got: %s", buf.String())
+	}
+}
+
+// TestMatchPrefixTemplate tests that only keys laid out by the prefix
+// template are pruned, not other dated objects under the static prefix
+func TestMatchPrefixTemplate(t *testing.T) {
+	bt := &BackupTool{
+		config: Config{S3Bucket: "my-logs", S3Prefix: "logs/{host}/YYYY/MM"},
+		logger: slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+	}
+	objects := []archivedObject{
+		{Key: "logs/web1/2024/11/app20241120.log"},
+		{Key: "logs/web1/2024/11/nested/app20241120.log"},
+		{Key: "logs/backups/db-20200101.sql.gz"},
+		{Key: "logs/web1/2024/Nov/app20241120.log"},
+		{Key: "logs/web1/2024/11/"},
+	}
+
+	matched, err := bt.matchPrefixTemplate(objects)
+	if err != nil {
+		t.Fatalf("matchPrefixTemplate() error = %v", err)
+	}
+	if len(matched) != 1 || matched[0].Key != objects[0].Key {
+		t.Errorf("matchPrefixTemplate() = %+v, want only %s", matched, objects[0].Key)
+	}
+}
+
+// TestSetStorageClass tests that a transition keeps the KMS key of the
+// object, and that objects over 5 GiB are copied in parts with their tags
+func TestSetStorageClass(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want []string
+	}{
+		{
+			name: "CopyObject",
+			size: 1 << 20,
+			want: []string{"HEAD", "PUT kms-key"},
+		},
+		{
+			name: "Multipart copy",
+			size: 6<<30 + 1,
+			want: []string{
+				"HEAD", "GET tagging=", "POST uploads= team=ops kms-key",
+				"PUT partNumber=1&uploadId=u1 bytes=0-1073741823",
+				"PUT partNumber=2&uploadId=u1 bytes=1073741824-2147483647",
+				"PUT partNumber=3&uploadId=u1 bytes=2147483648-3221225471",
+				"PUT partNumber=4&uploadId=u1 bytes=3221225472-4294967295",
+				"PUT partNumber=5&uploadId=u1 bytes=4294967296-5368709119",
+				"PUT partNumber=6&uploadId=u1 bytes=5368709120-6442450943",
+				"PUT partNumber=7&uploadId=u1 bytes=6442450944-6442450944",
+				"POST uploadId=u1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			client := s3.New(s3.Options{
+				Region:      "us-east-1",
+				Credentials: aws.AnonymousCredentials{},
+				HTTPClient: smithyhttp.ClientDoFunc(func(req *http.Request) (*http.Response, error) {
+					query := req.URL.Query()
+					query.Del("x-id")
+					fields := []string{req.Method}
+					if query := query.Encode(); query != "" {
+						fields = append(fields, query)
+					}
+					for _, name := range []string{"X-Amz-Copy-Source-Range", "X-Amz-Tagging", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"} {
+						if value := req.Header.Get(name); value != "" {
+							fields = append(fields, value)
+						}
+					}
+					got = append(got, strings.Join(fields, " "))
+
+					header := http.Header{}
+					body := ""
+					switch {
+					case req.Method == http.MethodHead:
+						header.Set("Content-Length", strconv.FormatInt(tt.size, 10))
+						header.Set("ETag", `"abc"`)
+						header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+						header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", "kms-key")
+					case query.Has("tagging"):
+						body = "<Tagging><TagSet><Tag><Key>team</Key><Value>ops</Value></Tag></TagSet></Tagging>"
+					case query.Has("uploads"):
+						body = "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>"
+					case query.Has("partNumber"):
+						body = `<CopyPartResult><ETag>"part"</ETag></CopyPartResult>`
+					case query.Has("uploadId"):
+						body = "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"
+					default:
+						body = `<CopyObjectResult><ETag>"abc"</ETag></CopyObjectResult>`
+					}
+					return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+				}),
+			})
+
+			store := newS3Store(client, "my-logs")
+			if err := store.setStorageClass(context.Background(), "logs/app-20241215.log", "GLACIER"); err != nil {
+				t.Fatalf("setStorageClass() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Requests = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// DestinationStats holds the upload results of one destination when files
+// are replicated
+type DestinationStats struct {
+	Destination string
+	Required    bool
+	Uploaded    int
+	Errors      int
+}
+
+// replica is an additional destination every uploaded file is copied to. It
+// uploads through its own BackupTool so keys, labels and Object Lock settings
+// are applied exactly as for the primary destination.
+type replica struct {
+	tool     *BackupTool
+	required bool
+	// err is set when an optional destination could not be initialized
+	err error
+}
+
+// replicaConfig derives the configuration of a -replicate destination from
+// the main configuration. The URL has the -dest syntax and sets its own
+// backend, prefix, endpoint and storage class; required=false makes it
+// optional. Other settings such as the region, tags and Object Lock options
+// are inherited.
+func replicaConfig(base Config, raw string) (Config, bool, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Config{}, false, fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+	}
+
+	required := true
+	query := u.Query()
+	if query.Has("required") {
+		required, err = strconv.ParseBool(query.Get("required"))
+		if err != nil {
+			return Config{}, false, fmt.Errorf("invalid replica destination '%s': required must be true or false", raw)
+		}
+		query.Del("required")
+		u.RawQuery = query.Encode()
+	}
+
+	config := base
+	config.Destination = u.String()
+	config.Backend = ""
+	config.S3Bucket = ""
+	config.S3Prefix = ""
+	config.LocalDir = ""
+	config.SFTPHost = ""
+	config.SFTPUser = ""
+	config.SFTPDir = ""
+	config.EndpointURL = ""
+	config.Replicas = nil
+	config.PartitionsOutput = ""
+	config.Manifest = false
+	config.DestinationBandwidth = ""
+
+	if err := applyDestination(&config); err != nil {
+		return Config{}, false, fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+	}
+	if err := validateStore(config); err != nil {
+		return Config{}, false, fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+	}
+	if err := validateLayout(config); err != nil {
+		return Config{}, false, fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+	}
+	if config.DestinationBandwidth != "" {
+		if _, err := parseBandwidth(config.DestinationBandwidth); err != nil {
+			return Config{}, false, fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+		}
+	}
+	config.S3Prefix = layoutPrefix(config.Layout, config.S3Prefix)
+	return config, required, nil
+}
+
+// validateReplicas checks the -replicate destinations before any upload
+func validateReplicas(config Config, globPattern string) error {
+	if len(config.Replicas) == 0 {
+		return nil
+	}
+	if config.Bundle != "" {
+		return fmt.Errorf("-replicate cannot be used with -bundle")
+	}
+	for _, raw := range config.Replicas {
+		rc, _, err := replicaConfig(config, raw)
+		if err != nil {
+			return err
+		}
+		if err := validatePrefixTemplate(rc, globPattern); err != nil {
+			return fmt.Errorf("invalid replica destination '%s': %w", raw, err)
+		}
+	}
+	return nil
+}
+
+// newReplicas creates the replicas and the per-destination statistics, with
+// the primary destination first
+func (bt *BackupTool) newReplicas() error {
+	if len(bt.config.Replicas) == 0 {
+		return nil
+	}
+
+	bt.stats.Destinations = []DestinationStats{{Destination: bt.destinationName(), Required: true}}
+	for _, raw := range bt.config.Replicas {
+		config, required, err := replicaConfig(bt.config, raw)
+		if err != nil {
+			return err
+		}
+		// Replicas share the global bandwidth limit and have their own
+		destBandwidth, err := newBandwidthLimiter(config.DestinationBandwidth)
+		if err != nil {
+			return err
+		}
+		tool := &BackupTool{config: config, logger: bt.logger, bandwidth: bt.bandwidth, destBandwidth: destBandwidth}
+		r := &replica{tool: tool, required: required}
+		bt.replicas = append(bt.replicas, r)
+		bt.stats.Destinations = append(bt.stats.Destinations, DestinationStats{Destination: r.tool.destinationName(), Required: required})
+	}
+	return nil
+}
+
+// destinationName identifies the destination in logs and statistics
+func (bt *BackupTool) destinationName() string {
+	return bt.objectURL(bt.config.S3Prefix)
+}
+
+// initReplicas connects to the replica destinations. A required destination
+// that cannot be reached stops the run; an optional one is skipped.
+func (bt *BackupTool) initReplicas(ctx context.Context) error {
+	for _, r := range bt.replicas {
+		if err := r.tool.initStore(ctx); err != nil {
+			if r.required {
+				return fmt.Errorf("replica %s: %w", r.tool.destinationName(), err)
+			}
+			bt.logger.Warn("Optional destination unavailable, skipping", "destination", r.tool.destinationName(), errAttr(err))
+			r.err = err
+		}
+	}
+	return nil
+}
+
+// closeReplicas releases the connections of the replica stores
+func (bt *BackupTool) closeReplicas() {
+	for _, r := range bt.replicas {
+		if r.tool.store != nil {
+			r.tool.closeStore()
+		}
+	}
+}
+
+// uploadToDestinations uploads a file to the primary destination and every
+// replica. All destinations are attempted even if one fails; the returned
+// error lists the required destinations that failed, so the caller keeps
+// the local file unless every required copy exists. The ETag is the one
+// reported by the primary destination.
+func (bt *BackupTool) uploadToDestinations(ctx context.Context, filePath string) (string, error) {
+	if len(bt.replicas) == 0 {
+		return bt.uploadToS3(ctx, filePath)
+	}
+
+	var failed []error
+	etag, err := bt.uploadToS3(ctx, filePath)
+	if err != nil {
+		bt.stats.Destinations[0].Errors++
+		failed = append(failed, fmt.Errorf("%s: %w", bt.stats.Destinations[0].Destination, err))
+	} else {
+		bt.stats.Destinations[0].Uploaded++
+	}
+
+	for i, r := range bt.replicas {
+		stats := &bt.stats.Destinations[i+1]
+		err := r.err
+		if err == nil {
+			r.tool.captures = bt.captures
+			_, err = r.tool.uploadToS3(ctx, filePath)
+		}
+		if err == nil {
+			stats.Uploaded++
+			continue
+		}
+		stats.Errors++
+		if !r.required {
+			bt.logger.Warn("Optional replication failed", LogFieldFile, filePath, "destination", stats.Destination, errAttr(err))
+			continue
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", stats.Destination, err))
+	}
+	return etag, errors.Join(failed...)
+}
+
+// replicateArchived copies a file the primary destination already holds to
+// the replicas that lack it or hold a different copy. With -retention this
+// retries replicas that failed in an earlier run, so the local file is only
+// deleted once every required destination has it. The returned error lists
+// the required replicas that still lack the file.
+func (bt *BackupTool) replicateArchived(ctx context.Context, filePath string) error {
+	var failed []error
+	for i, r := range bt.replicas {
+		stats := &bt.stats.Destinations[i+1]
+		err := r.err
+		if err == nil {
+			r.tool.captures = bt.captures
+			entry := r.tool.verifyFile(ctx, filePath)
+			switch entry.Status {
+			case VerifyArchived:
+				continue
+			case VerifyMissing, VerifySizeMismatch, VerifyChecksumMismatch:
+				bt.logger.Info("Replica lacks archived file, uploading", LogFieldFile, filePath, "destination", stats.Destination, "status", entry.Status)
+				if err = bt.waitForUploadWindow(ctx); err == nil {
+					_, err = r.tool.uploadToS3(ctx, filePath)
+				}
+			default:
+				err = fmt.Errorf("failed to check for %s: %s", filePath, entry.Error)
+			}
+		}
+		if err == nil {
+			stats.Uploaded++
+			continue
+		}
+		stats.Errors++
+		if !r.required {
+			bt.logger.Warn("Optional replication failed", LogFieldFile, filePath, "destination", stats.Destination, errAttr(err))
+			continue
+		}
+		failed = append(failed, fmt.Errorf("%s: %w", stats.Destination, err))
+	}
+	return errors.Join(failed...)
+}
+
+// logDestinationSummary logs the per-destination results of a replicated run
+func (bt *BackupTool) logDestinationSummary() {
+	for _, dest := range bt.stats.Destinations {
+		bt.logger.Info("Destination summary", "destination", dest.Destination, "required", dest.Required, "uploaded", dest.Uploaded, "errors", dest.Errors)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReplicaConfig tests deriving a replica's configuration from the main one
+func TestReplicaConfig(t *testing.T) {
+	base := Config{
+		S3Bucket:     "primary-logs",
+		S3Prefix:     "logs/{year}",
+		AWSRegion:    "ap-northeast-1",
+		EndpointURL:  "http://minio:9000",
+		StorageClass: DefaultStorageClass,
+		ObjectTags:   []string{"env=production"},
+		Replicas:     []string{"s3://dr-logs/dr/{year}"},
+	}
+
+	config, required, err := replicaConfig(base, "s3://dr-logs/dr/{year}/{month}?region=us-west-2&storage_class=DEEP_ARCHIVE")
+	if err != nil {
+		t.Fatalf("replicaConfig() error = %v", err)
+	}
+	if !required {
+		t.Error("Replicas should be required by default")
+	}
+	if config.S3Bucket != "dr-logs" || config.S3Prefix != "dr/{year}/{month}" {
+		t.Errorf("replicaConfig() bucket = %s, prefix = %s", config.S3Bucket, config.S3Prefix)
+	}
+	if config.AWSRegion != "us-west-2" || config.StorageClass != "DEEP_ARCHIVE" || config.EndpointURL != "" {
+		t.Errorf("replicaConfig() region = %s, storage class = %s, endpoint = %s", config.AWSRegion, config.StorageClass, config.EndpointURL)
+	}
+	if len(config.ObjectTags) != 1 || len(config.Replicas) != 0 {
+		t.Errorf("replicaConfig() tags = %v, replicas = %v", config.ObjectTags, config.Replicas)
+	}
+
+	config, required, err = replicaConfig(base, "file:///mnt/nas/logs/{year}?required=false")
+	if err != nil {
+		t.Fatalf("replicaConfig() error = %v", err)
+	}
+	if required || config.Backend != BackendLocal || config.LocalDir != "/mnt/nas" {
+		t.Errorf("replicaConfig() required = %v, backend = %s, dir = %s", required, config.Backend, config.LocalDir)
+	}
+
+	for _, raw := range []string{"s3://dr-logs", "file:///mnt/nas/logs?required=maybe", "ftp://nas/logs"} {
+		if _, _, err := replicaConfig(base, raw); err == nil {
+			t.Errorf("replicaConfig(%s) should fail", raw)
+		}
+	}
+}
+
+// TestValidateReplicas tests validation of the -replicate option
+func TestValidateReplicas(t *testing.T) {
+	base := Config{S3Bucket: "primary-logs", S3Prefix: "logs"}
+	glob := "/var/log/{service}/{service}-YYYYMMDD.log.gz"
+
+	tests := []struct {
+		name     string
+		replicas []string
+		bundle   string
+		wantErr  bool
+	}{
+		{"No replicas", nil, "", false},
+		{"Glob placeholder in replica prefix", []string{"s3://dr-logs/{service}/{year}"}, "", false},
+		{"Unknown variable", []string{"s3://dr-logs/{unknown}"}, "", true},
+		{"With bundle", []string{"s3://dr-logs/logs"}, BundleByDate, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			config.Replicas = tt.replicas
+			config.Bundle = tt.bundle
+			err := validateReplicas(config, glob)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateReplicas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestUploadToDestinations tests that files are copied to every destination
+// and that only required destinations decide whether the upload succeeded
+func TestUploadToDestinations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	primaryDir, replicaDir, optionalDir := t.TempDir(), t.TempDir(), t.TempDir()
+	// A regular file in place of the archive directory makes uploads fail
+	brokenDir := filepath.Join(t.TempDir(), "broken")
+	if err := os.WriteFile(brokenDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newTool := func(replicas ...string) *BackupTool {
+		var buf bytes.Buffer
+		bt := &BackupTool{
+			config: Config{Backend: BackendLocal, LocalDir: primaryDir, S3Prefix: "logs/{year}", Replicas: replicas},
+			logger: slog.New(slog.NewTextHandler(&buf, nil)),
+			store:  newLocalStore(primaryDir),
+		}
+		if err := bt.newReplicas(); err != nil {
+			t.Fatalf("newReplicas() error = %v", err)
+		}
+		for _, r := range bt.replicas {
+			r.tool.store = newLocalStore(r.tool.config.LocalDir)
+		}
+		return bt
+	}
+
+	t.Run("Optional failure", func(t *testing.T) {
+		bt := newTool("file://"+replicaDir+"/dr/{year}/{month}", "file://"+brokenDir+"/logs?required=false")
+		if _, err := bt.uploadToDestinations(context.Background(), filePath); err != nil {
+			t.Fatalf("uploadToDestinations() error = %v", err)
+		}
+		for _, path := range []string{
+			filepath.Join(primaryDir, "logs", "2024", "app-20241215.log"),
+			filepath.Join(replicaDir, "dr", "2024", "12", "app-20241215.log"),
+		} {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("Replicated file not found: %v", err)
+			}
+		}
+
+		got := bt.stats.Destinations
+		if len(got) != 3 || got[0].Uploaded != 1 || got[1].Uploaded != 1 || got[2].Errors != 1 || got[2].Required {
+			t.Errorf("Destinations = %+v", got)
+		}
+	})
+
+	t.Run("Required failure", func(t *testing.T) {
+		bt := newTool("file://"+brokenDir+"/logs", "file://"+optionalDir+"/logs?required=false")
+		_, err := bt.uploadToDestinations(context.Background(), filePath)
+		if err == nil || !strings.Contains(err.Error(), "file://"+brokenDir) {
+			t.Fatalf("uploadToDestinations() error = %v, want failure of %s", err, brokenDir)
+		}
+		// The remaining destinations are still attempted
+		if _, err := os.Stat(filepath.Join(optionalDir, "logs", "app-20241215.log")); err != nil {
+			t.Errorf("Optional replica not written: %v", err)
+		}
+
+		var buf bytes.Buffer
+		bt.logger = slog.New(slog.NewTextHandler(&buf, nil))
+		bt.logSummary("app-YYYYMMDD.log")
+		if !strings.Contains(buf.String(), "destination=file://"+brokenDir+"/logs required=true uploaded=0 errors=1") {
+			t.Errorf("logSummary() output:\n%s", buf.String())
+		}
+	})
+
+	t.Run("Without replicas", func(t *testing.T) {
+		bt := newTool()
+		bt.logger = slog.New(slog.DiscardHandler)
+		if _, err := bt.uploadToDestinations(context.Background(), filePath); err != nil {
+			t.Fatalf("uploadToDestinations() error = %v", err)
+		}
+		if bt.stats.Destinations != nil {
+			t.Errorf("Destinations = %+v, want none", bt.stats.Destinations)
+		}
+	})
+}
+
+// TestRetentionRetriesReplicas tests that with -retention a file already on
+// the primary destination is copied to a replica that lacks it before the
+// local file is deleted, and kept while a required replica fails
+func TestRetentionRetriesReplicas(t *testing.T) {
+	sourceDir, primaryDir, replicaDir := t.TempDir(), t.TempDir(), t.TempDir()
+	filePath := filepath.Join(sourceDir, "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pattern := filepath.Join(sourceDir, "app-YYYYMMDD.log")
+	config := localTestConfig(t, Config{LocalDir: primaryDir, Retention: "2 days"})
+	run := func(config Config) *BackupTool {
+		bt, err := NewBackupTool(config)
+		if err != nil {
+			t.Fatalf("NewBackupTool() error = %v", err)
+		}
+		// Failed files are reported in the stats
+		bt.Run(context.Background(), pattern)
+		return bt
+	}
+
+	// An earlier run archived the file to the primary destination only and
+	// kept it within a longer retention period
+	long := config
+	long.Retention = "36500 days"
+	if bt := run(long); bt.stats.Uploaded != 1 || bt.stats.Retained != 1 {
+		t.Fatalf("Stats = %+v, want the file uploaded and retained", bt.stats)
+	}
+
+	// A regular file in place of the prefix directory makes uploads fail
+	if err := os.WriteFile(filepath.Join(replicaDir, "dr"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.Replicas = []string{"file://" + replicaDir + "/dr"}
+	bt := run(config)
+	if bt.stats.AlreadyArchived != 1 || bt.stats.Errors != 1 || bt.stats.Deleted != 0 {
+		t.Errorf("Stats = %+v, want the file kept while the replica fails", bt.stats)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Fatalf("Expected the local file to be kept: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(replicaDir, "dr")); err != nil {
+		t.Fatal(err)
+	}
+	bt = run(config)
+	if bt.stats.AlreadyArchived != 1 || bt.stats.Errors != 0 || bt.stats.Deleted != 1 {
+		t.Errorf("Stats = %+v, want the file replicated and deleted", bt.stats)
+	}
+	if got := bt.stats.Destinations; len(got) != 2 || got[1].Uploaded != 1 {
+		t.Errorf("Destinations = %+v, want the replica uploaded", got)
+	}
+	if _, err := os.Stat(filepath.Join(replicaDir, "dr", "app-20241215.log")); err != nil {
+		t.Errorf("Replica not written: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Run statuses recorded in the run report
+const (
+	ReportSuccess = "success"
+	ReportPartial = "partial"
+	ReportFailed  = "failed"
+)
+
+// Error classes of runs that stopped before processing files
+const (
+	ErrorClassConfig = "config"
+	ErrorClassLocked = "locked"
+)
+
+// runReport is the machine-readable result of a run written to -report. It
+// holds everything in the run manifest plus the outcome of the whole run.
+type runReport struct {
+	Status     string  `json:"status"`
+	ExitCode   int     `json:"exit_code"`
+	Error      string  `json:"error,omitempty"`
+	ErrorClass string  `json:"error_class,omitempty"`
+	Duration   float64 `json:"duration"`
+	runManifest
+}
+
+// newRunReport creates the report of a run that ended with runErr
+func newRunReport(manifest runManifest, runErr error) runReport {
+	report := runReport{
+		ExitCode:    exitCode(runErr),
+		Duration:    seconds(manifest.Finished.Sub(manifest.Started)),
+		runManifest: manifest,
+	}
+	report.Status = runStatus(report.ExitCode)
+
+	if runErr != nil {
+		report.Error = runErr.Error()
+		report.ErrorClass = runErrorClass(runErr)
+	}
+	return report
+}
+
+// runStatus returns the run status for an exit code
+func runStatus(code int) string {
+	switch code {
+	case ExitOK:
+		return ReportSuccess
+	case ExitPartial:
+		return ReportPartial
+	}
+	return ReportFailed
+}
+
+// runErrorClass returns the error class of the error that ended a run
+func runErrorClass(err error) string {
+	var cfgErr *configError
+	var failure *runFailure
+	switch {
+	case errors.Is(err, errLocked):
+		return ErrorClassLocked
+	case errors.As(err, &cfgErr):
+		return ErrorClassConfig
+	case errors.As(err, &failure):
+		return failure.Class
+	}
+	return classifyError(err)
+}
+
+// failureClass returns the error class shared by every failed file, or ""
+// if the failures differ
+func failureClass(results []*fileResult) string {
+	class := ""
+	for _, result := range results {
+		if result.ErrorClass == "" {
+			continue
+		}
+		if class != "" && class != result.ErrorClass {
+			return ""
+		}
+		class = result.ErrorClass
+	}
+	return class
+}
+
+// writeReport writes the report of the current run to -report
+func (bt *BackupTool) writeReport(globPattern string, runErr error) error {
+	hostname, _ := os.Hostname()
+	return writeRunReport(bt.config.Report, newRunReport(bt.buildRunManifest(globPattern, hostname), runErr))
+}
+
+// writeStartupReport writes the report of a run that failed before it
+// started, e.g. because of an invalid configuration
+func writeStartupReport(config Config, globPattern string, runErr error) error {
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+	manifest := runManifest{
+		Host:        hostname,
+		Version:     Version,
+		ConfigHash:  configHash(config),
+		GlobPattern: globPattern,
+		Started:     now,
+		Finished:    now,
+		DryRun:      config.DryRun,
+		Files:       []*fileResult{},
+	}
+	return writeRunReport(config.Report, newRunReport(manifest, runErr))
+}
+
+// writeRunReport writes a report as YAML if the path ends in .yaml or .yml
+// and as JSON otherwise. The file is replaced atomically so a scheduler
+// polling for it never reads a partial report.
+func writeRunReport(path string, report runReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run report: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if data, err = jsonToYAML(data); err != nil {
+			return fmt.Errorf("failed to encode run report: %w", err)
+		}
+	default:
+		data = append(data, '\n')
+	}
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
+
+// jsonToYAML converts JSON to block style YAML. Going through JSON keeps
+// the field names and order of both formats identical.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var clearStyle func(*yaml.Node)
+	clearStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, child := range n.Content {
+			clearStyle(child)
+		}
+	}
+	clearStyle(&node)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
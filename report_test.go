@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestWriteRunReport tests the JSON and YAML report formats
+func TestWriteRunReport(t *testing.T) {
+	started := time.Date(2024, 12, 16, 3, 0, 0, 0, time.UTC)
+	manifest := runManifest{
+		Host:     "web01",
+		Started:  started,
+		Finished: started.Add(1500 * time.Millisecond),
+		Stats:    Stats{TotalFiles: 2, Uploaded: 1, Errors: 1},
+		Files: []*fileResult{
+			{Path: "/var/log/app-20241214.log", Key: "logs/app-20241214.log", Size: 10, Outcome: OutcomeUploaded, ETag: "abc123", Duration: 0.25},
+			{Path: "/var/log/app-20241215.log", Outcome: OutcomeFailed, Error: "access denied", ErrorClass: ErrorClassAuth},
+		},
+	}
+	runErr := &runFailure{Errors: 1, Partial: true, Class: failureClass(manifest.Files)}
+
+	for _, name := range []string{"report.json", "report.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := writeRunReport(path, newRunReport(manifest, runErr)); err != nil {
+				t.Fatalf("writeRunReport() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var report struct {
+				Status     string  `json:"status" yaml:"status"`
+				ExitCode   int     `json:"exit_code" yaml:"exit_code"`
+				ErrorClass string  `json:"error_class" yaml:"error_class"`
+				Duration   float64 `json:"duration" yaml:"duration"`
+				Files      []struct {
+					Outcome    string  `json:"outcome" yaml:"outcome"`
+					ETag       string  `json:"etag" yaml:"etag"`
+					Duration   float64 `json:"duration" yaml:"duration"`
+					ErrorClass string  `json:"error_class" yaml:"error_class"`
+				} `json:"files" yaml:"files"`
+			}
+			if filepath.Ext(name) == ".yaml" {
+				err = yaml.Unmarshal(data, &report)
+			} else {
+				err = json.Unmarshal(data, &report)
+			}
+			if err != nil {
+				t.Fatalf("Invalid report: %v\n%s", err, data)
+			}
+
+			if report.Status != ReportPartial || report.ExitCode != ExitPartial || report.ErrorClass != ErrorClassAuth || report.Duration != 1.5 {
+				t.Errorf("report = %+v, want partial with exit code %d", report, ExitPartial)
+			}
+			if len(report.Files) != 2 || report.Files[0].ETag != "abc123" || report.Files[0].Duration != 0.25 || report.Files[1].ErrorClass != ErrorClassAuth {
+				t.Errorf("report files = %+v", report.Files)
+			}
+		})
+	}
+}
+
+// TestRunReportFromRun tests the report of a successful and a locked run
+func TestRunReportFromRun(t *testing.T) {
+	sourceDir := t.TempDir()
+	filePath := filepath.Join(sourceDir, "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	config := Config{
+		Backend:  BackendLocal,
+		LocalDir: t.TempDir(),
+		S3Prefix: "logs",
+		Period:   "1 day",
+		LockFile: filepath.Join(t.TempDir(), "backup.lock"),
+		Report:   reportPath,
+		LogLevel: "error",
+	}
+	pattern := filepath.Join(sourceDir, "app-YYYYMMDD.log")
+
+	readReport := func() runReport {
+		data, err := os.ReadFile(reportPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report runReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			t.Fatalf("Invalid report: %v", err)
+		}
+		return report
+	}
+
+	bt, err := NewBackupTool(config)
+	if err != nil {
+		t.Fatalf("NewBackupTool() error = %v", err)
+	}
+	runErr := bt.Run(context.Background(), pattern)
+	if err := bt.writeReport(pattern, runErr); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+	report := readReport()
+	if runErr != nil || report.Status != ReportSuccess || report.ExitCode != ExitOK {
+		t.Fatalf("Run() error = %v, report status %s (%d)", runErr, report.Status, report.ExitCode)
+	}
+	if len(report.Files) != 1 || report.Files[0].Outcome != OutcomeUploaded || report.Files[0].Key != "logs/app-20241215.log" {
+		t.Errorf("report files = %+v", report.Files)
+	}
+
+	// A second instance finds the lock held
+	if err := os.WriteFile(config.LockFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bt, _ = NewBackupTool(config)
+	runErr = bt.Run(context.Background(), pattern)
+	if exitCode(runErr) != ExitLocked {
+		t.Fatalf("exitCode(%v) = %d, want %d", runErr, exitCode(runErr), ExitLocked)
+	}
+	if err := bt.writeReport(pattern, runErr); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+	if report := readReport(); report.Status != ReportFailed || report.ErrorClass != ErrorClassLocked {
+		t.Errorf("report = %s (%s), want %s (%s)", report.Status, report.ErrorClass, ReportFailed, ErrorClassLocked)
+	}
+}
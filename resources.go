@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// IO scheduling classes of -ionice
+const (
+	IOClassIdle       = "idle"
+	IOClassBestEffort = "best-effort"
+)
+
+// gcsChunkAlign is the unit GCS rounds upload chunk sizes to
+const gcsChunkAlign = 256 << 10
+
+// azureMinBlockSize is the smallest block Azure uploads in
+const azureMinBlockSize = 1 << 20
+
+// errPriorityUnsupported is returned where -nice and -ionice are not
+// available
+var errPriorityUnsupported = errors.New("not supported on this platform")
+
+// byteUnits are the multipliers of size suffixes, in binary units like
+// rsync --bwlimit
+var byteUnits = map[string]float64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+}
+
+// parseByteSize parses a positive size such as "512K", "64M" or "1.5G"
+func parseByteSize(s string) (float64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(value)
+	}
+	number, unit := value[:i], strings.TrimSpace(value[i:])
+	multiplier, ok := byteUnits[unit]
+	n, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size '%s' (e.g. 512K, 64M or 1G)", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseIONice parses -ionice as idle or best-effort[:level], level 0 (high)
+// to 7 (low). best-effort without a level is level 7.
+func parseIONice(s string) (string, int, error) {
+	class, rawLevel, hasLevel := strings.Cut(s, ":")
+	switch {
+	case class == IOClassIdle && !hasLevel:
+		return class, 0, nil
+	case class == IOClassBestEffort && !hasLevel:
+		return class, 7, nil
+	case class == IOClassBestEffort:
+		level, err := strconv.Atoi(rawLevel)
+		if err == nil && level >= 0 && level <= 7 {
+			return class, level, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid -ionice '%s' (use %s or %s[:0-7])", s, IOClassIdle, IOClassBestEffort)
+}
+
+// addResourceFlags registers the priority and memory options shared by the
+// backup, restore and verify commands
+func addResourceFlags(fs *flag.FlagSet, config *Config) {
+	fs.IntVar(&config.Nice, "nice", 0, "Lower the CPU priority to this nice value (0-19)")
+	fs.StringVar(&config.IONice, "ionice", "", "Lower the IO priority (idle, best-effort[:0-7]; Linux only)")
+	fs.StringVar(&config.BufferSize, "buffer-size", "", "Upload and compression buffer size, e.g. 1M (default: the backend defaults)")
+	fs.StringVar(&config.MemoryLimit, "memory-limit", "", "Soft memory limit of the process, e.g. 256M")
+}
+
+// validateResources checks the priority and memory options
+func validateResources(config Config) error {
+	if config.Nice < 0 || config.Nice > 19 {
+		return fmt.Errorf("-nice must be between 0 and 19")
+	}
+	if config.IONice != "" {
+		if _, _, err := parseIONice(config.IONice); err != nil {
+			return err
+		}
+	}
+	if config.BufferSize != "" {
+		if _, err := parseByteSize(config.BufferSize); err != nil {
+			return fmt.Errorf("invalid -buffer-size: %w", err)
+		}
+	}
+	if config.MemoryLimit != "" {
+		if _, err := parseByteSize(config.MemoryLimit); err != nil {
+			return fmt.Errorf("invalid -memory-limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyResourceLimits lowers the CPU and IO priority of the process and
+// sets the memory limit of the Go runtime. Hook commands started later
+// inherit the priorities. Priorities that cannot be set are logged, since
+// the backup itself still works.
+func applyResourceLimits(config Config, logger *slog.Logger) {
+	if config.Nice > 0 {
+		if err := setNice(config.Nice); err != nil {
+			logger.Warn("Could not lower the CPU priority", "nice", config.Nice, errAttr(err))
+		}
+	}
+	if config.IONice != "" {
+		class, level, _ := parseIONice(config.IONice)
+		if err := setIOPriority(class, level); err != nil {
+			logger.Warn("Could not lower the IO priority", "ionice", config.IONice, errAttr(err))
+		}
+	}
+	if config.MemoryLimit != "" {
+		limit, _ := parseByteSize(config.MemoryLimit)
+		debug.SetMemoryLimit(int64(limit))
+	}
+}
+
+// bufferSize returns the -buffer-size in bytes, or 0 for the defaults
+func bufferSize(config Config) int64 {
+	if config.BufferSize == "" {
+		return 0
+	}
+	size, _ := parseByteSize(config.BufferSize)
+	return int64(math.Min(size, math.MaxInt32))
+}
+
+// gcsChunkSize returns the GCS upload chunk for a buffer size, which must
+// be a multiple of 256 KiB. 0 keeps the default of 16 MiB.
+func gcsChunkSize(size int64) int {
+	if size == 0 {
+		return 0
+	}
+	return int(max(size/gcsChunkAlign, 1) * gcsChunkAlign)
+}
+
+// azureBlockSize returns the Azure upload block for a buffer size. 0 keeps
+// the default of 1 MiB.
+func azureBlockSize(size int64) int64 {
+	if size == 0 {
+		return 0
+	}
+	return max(size, azureMinBlockSize)
+}
+
+// zstdEncoderOptions bound the memory of bundle compression. With a buffer
+// size the encoder runs on one goroutine with a window of at most half the
+// buffer, instead of one per CPU with an 8 MiB window.
+func zstdEncoderOptions(size int64) []zstd.EOption {
+	if size == 0 {
+		return nil
+	}
+	window := zstd.MinWindowSize
+	for window*2 <= int(size/2) && window*2 <= 8<<20 {
+		window *= 2
+	}
+	return []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(window), zstd.WithLowerEncoderMem(true)}
+}
+
+// zstdDecoderOptions bound the memory of bundle verification
+func zstdDecoderOptions(size int64) []zstd.DOption {
+	if size == 0 {
+		return nil
+	}
+	return []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestParseByteSize tests parsing -buffer-size and -memory-limit sizes
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"4096", 4096, false},
+		{"512K", 512 << 10, false},
+		{"64mb", 64 << 20, false},
+		{"1.5G", 1.5 * (1 << 30), false},
+		{" 2 MiB ", 2 << 20, false},
+		{"", 0, true},
+		{"0", 0, true},
+		{"1T", 0, true},
+		{"M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestValidateResources tests the -nice, -ionice and size options
+func TestValidateResources(t *testing.T) {
+	valid := []Config{
+		{},
+		{Nice: 19, IONice: "idle"},
+		{IONice: "best-effort"},
+		{IONice: "best-effort:0", BufferSize: "1M", MemoryLimit: "256M"},
+	}
+	for _, config := range valid {
+		if err := validateResources(config); err != nil {
+			t.Errorf("validateResources(%+v) error = %v", config, err)
+		}
+	}
+
+	invalid := []Config{
+		{Nice: -1},
+		{Nice: 20},
+		{IONice: "realtime"},
+		{IONice: "idle:3"},
+		{IONice: "best-effort:8"},
+		{BufferSize: "big"},
+		{MemoryLimit: "0"},
+	}
+	for _, config := range invalid {
+		if err := validateResources(config); err == nil {
+			t.Errorf("validateResources(%+v) should fail", config)
+		}
+	}
+
+	if class, level, _ := parseIONice("best-effort"); class != IOClassBestEffort || level != 7 {
+		t.Errorf("parseIONice(best-effort) = %s, %d; want the lowest level", class, level)
+	}
+}
+
+// TestResourceFlagsSubcommands tests that restore and verify take and check
+// the resource options too
+func TestResourceFlagsSubcommands(t *testing.T) {
+	restoreArgs := []string{"-bucket", "my-logs", "-prefix", "logs", "-target", t.TempDir()}
+	verifyArgs := []string{"-bucket", "my-logs", "-prefix", "logs", "1 day", "*YYYYMMDD.log"}
+
+	config, _, err := parseRestoreFlags(append([]string{"-nice", "10", "-ionice", "idle"}, restoreArgs...))
+	if err != nil || config.Nice != 10 || config.IONice != IOClassIdle {
+		t.Errorf("parseRestoreFlags() = %+v, %v; want -nice 10 -ionice idle", config, err)
+	}
+	if _, _, err := parseRestoreFlags(append([]string{"-nice", "20"}, restoreArgs...)); err == nil {
+		t.Error("parseRestoreFlags() should reject -nice 20")
+	}
+
+	config, _, _, err = parseVerifyFlags(append([]string{"-memory-limit", "256M"}, verifyArgs...))
+	if err != nil || config.MemoryLimit != "256M" {
+		t.Errorf("parseVerifyFlags() = %+v, %v; want -memory-limit 256M", config, err)
+	}
+	if _, _, _, err := parseVerifyFlags(append([]string{"-ionice", "realtime"}, verifyArgs...)); err == nil {
+		t.Error("parseVerifyFlags() should reject -ionice realtime")
+	}
+}
+
+// TestBufferSizes tests how -buffer-size maps to the backend upload buffers
+func TestBufferSizes(t *testing.T) {
+	tests := []struct {
+		size      string
+		wantGCS   int
+		wantAzure int64
+	}{
+		{"", 0, 0},
+		{"100K", 256 << 10, 1 << 20},
+		{"1M", 1 << 20, 1 << 20},
+		{"1300K", 1280 << 10, 1300 << 10},
+		{"32M", 32 << 20, 32 << 20},
+	}
+	for _, tt := range tests {
+		size := bufferSize(Config{BufferSize: tt.size})
+		if got := gcsChunkSize(size); got != tt.wantGCS {
+			t.Errorf("gcsChunkSize(%s) = %d, want %d", tt.size, got, tt.wantGCS)
+		}
+		if got := azureBlockSize(size); got != tt.wantAzure {
+			t.Errorf("azureBlockSize(%s) = %d, want %d", tt.size, got, tt.wantAzure)
+		}
+	}
+}
+
+// TestBundleBoundedMemory tests that a bundle of a file larger than the
+// buffer is streamed with bounded memory and still verifies
+func TestBundleBoundedMemory(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "app-20241215.log")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sparse file, so the test does not need the disk space
+	if err := file.Truncate(64 << 20); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	archivePath := filepath.Join(dir, "bundle.tar.zst")
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	members, err := writeBundle(archivePath, []string{filePath}, "zstd", 1<<20)
+	if err != nil {
+		t.Fatalf("writeBundle() error = %v", err)
+	}
+	if err := verifyBundle(archivePath, members, "zstd", 1<<20); err != nil {
+		t.Fatalf("verifyBundle() error = %v", err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 32<<20 {
+		t.Errorf("Allocated %d bytes for a 64 MiB file, expected it to be streamed", allocated)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- only used to compare against S3 ETags
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	DefaultRestoreConcurrency  = 4
+	DefaultGlacierTier         = "Standard"
+	DefaultGlacierDays         = 1
+	DefaultRestorePollInterval = 5 * time.Minute
+	DefaultRestoreWaitTimeout  = 48 * time.Hour
+)
+
+var (
+	errAlreadyExists  = errors.New("local file already exists")
+	errRestorePending = errors.New("archive restore in progress")
+)
+
+// RestoreOptions holds the options for the restore subcommand
+type RestoreOptions struct {
+	From         time.Time
+	To           time.Time
+	Filter       string
+	TargetDir    string
+	Concurrency  int
+	Overwrite    bool
+	GlacierTier  string
+	GlacierDays  int
+	Wait         bool
+	PollInterval time.Duration
+	WaitTimeout  time.Duration
+}
+
+// restoreStats holds the statistics for a restore operation
+type restoreStats struct {
+	mu       sync.Mutex
+	Total    int
+	Restored int
+	Pending  int
+	Skipped  int
+	Errors   int
+	Bytes    int64
+}
+
+// parseRestoreFlags parses the arguments of the restore subcommand
+func parseRestoreFlags(args []string) (Config, RestoreOptions, error) {
+	var config Config
+	var opts RestoreOptions
+	var from, to, tier string
+
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required with -backend s3)")
+	fs.StringVar(&config.S3Prefix, "prefix", "", "S3 prefix template the logs were uploaded with (e.g. logs/{year}/{month}) (required)")
+	fs.StringVar(&config.OutputFile, "output", "", "Output log file path (outputs to stdout if not specified)")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "Only list the objects that would be restored")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Verbose logging")
+	fs.StringVar(&from, "from", "", "Restore logs dated on or after this date (YYYY-MM-DD)")
+	fs.StringVar(&to, "to", "", "Restore logs dated on or before this date (YYYY-MM-DD)")
+	fs.StringVar(&opts.Filter, "filter", "", "Only restore files whose name matches this glob (e.g. \"nginx-*\")")
+	fs.StringVar(&opts.TargetDir, "target", "", "Directory to restore files into (required)")
+	fs.IntVar(&opts.Concurrency, "concurrency", DefaultRestoreConcurrency, "Number of parallel downloads")
+	fs.BoolVar(&opts.Overwrite, "overwrite", false, "Overwrite files that already exist locally")
+	fs.StringVar(&tier, "glacier-tier", DefaultGlacierTier, "Retrieval tier for archived objects (Expedited, Standard, Bulk)")
+	fs.IntVar(&opts.GlacierDays, "glacier-days", DefaultGlacierDays, "Days to keep the temporary copy of archived objects")
+	fs.BoolVar(&opts.Wait, "wait", true, "Wait for archived objects to be restored before downloading")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", DefaultRestorePollInterval, "Interval between archive restore status checks")
+	fs.DurationVar(&opts.WaitTimeout, "wait-timeout", DefaultRestoreWaitTimeout, "Maximum time to wait for archive restores")
+	addLogFlags(fs, &config)
+	addStoreFlags(fs, &config)
+	addAWSFlags(fs, &config)
+	addResourceFlags(fs, &config)
+	fs.Usage = func() { showRestoreUsage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return config, opts, err
+	}
+
+	var errs []string
+	if err := applyDestination(&config); err != nil {
+		errs = append(errs, err.Error())
+	} else if err := validateStore(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if config.S3Prefix == "" && config.Destination == "" {
+		errs = append(errs, "S3 prefix is required (use -prefix flag or -dest)")
+	} else if _, err := parsePrefixTemplate(config.S3Prefix); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid prefix '%s': %v", config.S3Prefix, err))
+	}
+	if opts.TargetDir == "" {
+		errs = append(errs, "target directory is required (use -target flag)")
+	}
+	if opts.Concurrency < 1 {
+		errs = append(errs, "concurrency must be at least 1")
+	}
+	if err := validateResources(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	var err error
+	if opts.From, err = parseDateFlag(from); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if opts.To, err = parseDateFlag(to); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && opts.To.Before(opts.From) {
+		errs = append(errs, "-to must not be before -from")
+	}
+
+	switch strings.ToLower(tier) {
+	case "expedited", "standard", "bulk":
+		opts.GlacierTier = strings.ToUpper(tier[:1]) + strings.ToLower(tier[1:])
+	default:
+		errs = append(errs, fmt.Sprintf("invalid glacier tier: %s (supported: Expedited, Standard, Bulk)", tier))
+	}
+
+	if len(errs) > 0 {
+		return config, opts, errors.New(strings.Join(errs, "\n"))
+	}
+	return config, opts, nil
+}
+
+func showRestoreUsage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `Usage: %s restore [OPTIONS]
+
+Downloads archived logs back to disk. Objects are selected by prefix, date range
+and file name filter, and restored in parallel to their original paths below the
+target directory. Objects in GLACIER or DEEP_ARCHIVE are restored first.
+
+OPTIONS:
+`, os.Args[0])
+	fs.PrintDefaults()
+	fmt.Fprintf(fs.Output(), `
+EXAMPLES:
+  %s restore -bucket my-logs -prefix "logs/YYYY/MM/DD" -from 2024-12-01 -to 2024-12-15 -target /tmp/restore
+  %s restore -bucket my-logs -prefix logs -filter "nginx-*" -glacier-tier Bulk -target /srv/restore
+`, os.Args[0], os.Args[0])
+}
+
+// runRestore is the entry point of the restore subcommand
+func runRestore(args []string) error {
+	config, opts, err := parseRestoreFlags(args)
+	if err != nil {
+		return &configError{err}
+	}
+
+	logger, err := newLogger(config)
+	if err != nil {
+		return err
+	}
+	applyResourceLimits(config, logger)
+	bt := &BackupTool{config: config, logger: logger}
+
+	ctx := context.Background()
+	if err := bt.initStore(ctx); err != nil {
+		return err
+	}
+	defer bt.closeStore()
+	return bt.Restore(ctx, opts)
+}
+
+// Restore downloads the selected archived objects into the target directory
+func (bt *BackupTool) Restore(ctx context.Context, opts RestoreOptions) error {
+	started := time.Now()
+	bt.logger.Info("Log restore process started", "target", opts.TargetDir)
+
+	objects, err := bt.listArchivedObjects(ctx, opts.From, opts.To, opts.Filter)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		bt.logger.Info("No archived objects found", LogFieldKey, bt.objectURL(bt.config.S3Prefix))
+		bt.logger.Info("Log restore process completed", durationAttr(time.Since(started)))
+		return nil
+	}
+	bt.logger.Info("Found objects to restore", "count", len(objects))
+
+	stats := &restoreStats{Total: len(objects)}
+	queue := make(chan archivedObject)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range queue {
+				bt.restoreAndRecord(ctx, obj, opts, stats)
+			}
+		}()
+	}
+	for _, obj := range objects {
+		queue <- obj
+	}
+	close(queue)
+	wg.Wait()
+
+	bt.logger.Info("Restore summary",
+		"total", stats.Total,
+		"restored", stats.Restored,
+		LogFieldBytes, stats.Bytes,
+		"pending", stats.Pending,
+		"skipped", stats.Skipped,
+		"errors", stats.Errors,
+	)
+
+	if stats.Errors > 0 {
+		return fmt.Errorf("restore completed with %d errors", stats.Errors)
+	}
+	if stats.Pending > 0 {
+		bt.logger.Warn("Archive restores were initiated; run the command again once they complete", "pending", stats.Pending)
+	}
+	bt.logger.Info("Log restore process completed successfully", durationAttr(time.Since(started)))
+	return nil
+}
+
+// restoreAndRecord restores one object and updates the statistics
+func (bt *BackupTool) restoreAndRecord(ctx context.Context, obj archivedObject, opts RestoreOptions, stats *restoreStats) {
+	localPath, size, err := bt.restoreObject(ctx, obj, opts)
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	switch {
+	case err == nil:
+		stats.Restored++
+		stats.Bytes += size
+	case errors.Is(err, errAlreadyExists):
+		bt.logger.Info("Restore skipped (already exists)", LogFieldFile, localPath)
+		stats.Skipped++
+	case errors.Is(err, errRestorePending):
+		bt.logger.Warn("Archive restore pending", LogFieldKey, bt.objectURL(obj.Key))
+		stats.Pending++
+	default:
+		bt.logger.Error("Restore failed", LogFieldKey, bt.objectURL(obj.Key), errAttr(err))
+		stats.Errors++
+	}
+}
+
+// restoreObject downloads a single object and returns its local path
+func (bt *BackupTool) restoreObject(ctx context.Context, obj archivedObject, opts RestoreOptions) (string, int64, error) {
+	head, err := bt.store.Head(ctx, obj.Key)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read object metadata: %w", err)
+	}
+
+	localPath := restoreTargetPath(opts.TargetDir, head.Metadata["original-path"], obj.Key)
+	if !opts.Overwrite {
+		if _, err := os.Stat(localPath); err == nil {
+			return localPath, 0, errAlreadyExists
+		}
+	}
+
+	if bt.config.DryRun {
+		bt.logger.Info("DRY RUN: Would restore", LogFieldKey, bt.objectURL(obj.Key), LogFieldFile, localPath, "storage_class", obj.StorageClass)
+		return localPath, 0, nil
+	}
+
+	if needsArchiveRestore(types.StorageClass(head.StorageClass), types.ArchiveStatus(head.ArchiveStatus), head.Restore) {
+		if err := bt.waitForArchiveRestore(ctx, obj.Key, head, opts); err != nil {
+			return localPath, 0, err
+		}
+	}
+
+	started := time.Now()
+	size, err := bt.downloadObject(ctx, obj.Key, localPath)
+	if err != nil {
+		return localPath, 0, err
+	}
+	bt.logger.Info("Restored", LogFieldKey, bt.objectURL(obj.Key), LogFieldFile, localPath, LogFieldBytes, size, durationAttr(time.Since(started)))
+	return localPath, size, nil
+}
+
+// restoreTargetPath rebuilds the local path of an object below targetDir,
+// using its original-path metadata when available
+func restoreTargetPath(targetDir, originalPath, key string) string {
+	if originalPath == "" {
+		return filepath.Join(targetDir, path.Base(key))
+	}
+	// Cleaning as an absolute path keeps ".." from escaping targetDir
+	return filepath.Join(targetDir, filepath.Clean("/"+originalPath))
+}
+
+// needsArchiveRestore reports whether an object must be restored from an
+// archive tier before it can be downloaded
+func needsArchiveRestore(storageClass types.StorageClass, archiveStatus types.ArchiveStatus, restore string) bool {
+	if archiveStatus != "" {
+		return !restoreCompleted(restore)
+	}
+	switch storageClass {
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		return !restoreCompleted(restore)
+	}
+	return false
+}
+
+// restoreCompleted parses the x-amz-restore header and reports whether a
+// temporary copy of the object is available
+func restoreCompleted(restore string) bool {
+	return strings.Contains(restore, `ongoing-request="false"`)
+}
+
+// restoreInProgress reports whether a restore request is still running
+func restoreInProgress(restore string) bool {
+	return strings.Contains(restore, `ongoing-request="true"`)
+}
+
+// waitForArchiveRestore starts a restore request for an archived object if
+// none is running, then polls until the object is available
+func (bt *BackupTool) waitForArchiveRestore(ctx context.Context, key string, head *ObjectInfo, opts RestoreOptions) error {
+	store, ok := bt.store.(*s3Store)
+	if !ok {
+		return fmt.Errorf("archive restores are only supported with -backend s3")
+	}
+	if !restoreInProgress(head.Restore) {
+		// Intelligent-Tiering archive tiers reject an expiry
+		days := opts.GlacierDays
+		if head.ArchiveStatus != "" {
+			days = 0
+		}
+		if err := store.restoreArchived(ctx, key, opts.GlacierTier, days); err != nil {
+			return fmt.Errorf("failed to start archive restore: %w", err)
+		}
+		bt.logger.Info("Archive restore requested", LogFieldKey, bt.objectURL(key), "tier", opts.GlacierTier)
+	}
+
+	if !opts.Wait {
+		return errRestorePending
+	}
+
+	deadline := time.Now().Add(opts.WaitTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.PollInterval):
+		}
+
+		current, err := bt.store.Head(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check archive restore status: %w", err)
+		}
+		if restoreCompleted(current.Restore) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for archive restore", opts.WaitTimeout)
+		}
+	}
+}
+
+// downloadObject streams an object to localPath via a temporary file and
+// verifies its checksum before moving it into place
+func (bt *BackupTool) downloadObject(ctx context.Context, key, localPath string) (int64, error) {
+	body, info, err := bt.store.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download object: %w", err)
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory for %s: %w", localPath, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".restore-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	sha := sha256.New()
+	sum := md5.New() // #nosec G401 -- only used to compare against S3 ETags
+	size, err := io.Copy(tmp, io.TeeReader(body, io.MultiWriter(sha, sum)))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", localPath, err)
+	}
+
+	if err := verifyDownloadChecksum(info.ChecksumSHA256, info.ETag, info.Encryption, sha.Sum(nil), sum.Sum(nil)); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return 0, fmt.Errorf("failed to move restored file into place: %w", err)
+	}
+	return size, nil
+}
+
+// verifyDownloadChecksum compares downloaded content against the SHA-256
+// checksum stored with the object, falling back to the ETag for single-part
+// objects that are not KMS encrypted (whose ETag is the MD5 of the content)
+func verifyDownloadChecksum(checksumSHA256, etag, sse string, sha256Sum, md5Sum []byte) error {
+	switch checksumKind(checksumSHA256, etag, sse) {
+	case "sha256":
+		if got := base64.StdEncoding.EncodeToString(sha256Sum); got != checksumSHA256 {
+			return fmt.Errorf("checksum mismatch: expected SHA-256 %s, got %s", checksumSHA256, got)
+		}
+	case "md5":
+		etag = strings.Trim(etag, `"`)
+		if got := hex.EncodeToString(md5Sum); got != etag {
+			return fmt.Errorf("checksum mismatch: expected MD5 %s, got %s", etag, got)
+		}
+	}
+	return nil
+}
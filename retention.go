@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// validateRetention checks that the local retention period is valid and not
+// shorter than the upload period
+func validateRetention(config Config) error {
+	if config.Retention == "" {
+		return nil
+	}
+	retention, err := parsePeriod(config.Retention)
+	if err != nil {
+		return fmt.Errorf("invalid retention '%s': %w", config.Retention, err)
+	}
+	if config.DeleteAfterUpload {
+		return fmt.Errorf("-delete and -retention cannot be used together; -retention deletes files once the retention period has passed")
+	}
+	if config.Bundle != "" {
+		return fmt.Errorf("-retention cannot be used with -bundle")
+	}
+	if config.Period != "" {
+		if period, err := parsePeriod(config.Period); err == nil && retention < period {
+			return fmt.Errorf("retention '%s' must not be shorter than the upload period '%s'", config.Retention, config.Period)
+		}
+	}
+	return nil
+}
+
+// pastRetention reports whether the file's date is before the local
+// retention cutoff
+func (bt *BackupTool) pastRetention(filePath string) bool {
+	fileDate, err := extractDateFromFilename(filepath.Base(filePath))
+	if err != nil {
+		return false
+	}
+	fileDateNormalized := time.Date(fileDate.Year(), fileDate.Month(), fileDate.Day(), 0, 0, 0, 0, fileDate.Location())
+	return fileDateNormalized.Before(bt.retentionCutoff)
+}
+
+// processFilesWithRetention runs the two-phase lifecycle: files older than
+// the upload period are uploaded unless S3 already holds an identical copy,
+// and files older than the retention period are deleted locally once S3
+// confirms they are archived
+func (bt *BackupTool) processFilesWithRetention(ctx context.Context, files []string) error {
+	bt.logger.Info("Local retention cutoff", "cutoff", bt.retentionCutoff.Format("2006-01-02 15:04:05"))
+
+	for _, file := range files {
+		fileCtx, span := startFileSpan(ctx, file, bt.config.StorageClass)
+		endFileSpan(span, bt.processFileWithRetention(fileCtx, file))
+	}
+
+	return nil
+}
+
+// processFileWithRetention uploads a single file unless it is already
+// archived, and deletes it once the retention period has passed
+func (bt *BackupTool) processFileWithRetention(ctx context.Context, file string) *fileResult {
+	// Double-check file still exists
+	if _, err := os.Stat(file); err != nil {
+		bt.logger.Warn("File not found (may have been processed)", LogFieldFile, file)
+		bt.stats.Skipped++
+		result := &fileResult{Path: file, Outcome: OutcomeSkipped, Error: err.Error(), ErrorClass: classifyError(err)}
+		bt.results = append(bt.results, result)
+		return result
+	}
+
+	result := bt.newFileResult(ctx, file)
+	result.Key, _ = bt.objectKey(file)
+
+	entry := bt.verifyFile(ctx, file)
+	switch entry.Status {
+	case VerifyArchived:
+		bt.logger.Info("Already archived", LogFieldFile, file, LogFieldKey, bt.objectURL(entry.Key))
+		bt.stats.AlreadyArchived++
+		result.Outcome = OutcomeAlreadyArchived
+		if err := bt.replicateArchived(ctx, file); err != nil {
+			bt.logger.Error("Replication failed", LogFieldFile, file, errAttr(err))
+			bt.stats.Errors++
+			result.setError(err)
+			return result
+		}
+	case VerifyMissing, VerifySizeMismatch, VerifyChecksumMismatch:
+		if err := bt.waitForUploadWindow(ctx); err != nil {
+			bt.stats.Errors++
+			result.fail(err)
+			return result
+		}
+		if !bt.beforeUpload(ctx, result) {
+			return result
+		}
+		started := time.Now()
+		etag, err := bt.uploadToDestinations(ctx, file)
+		result.Duration = seconds(time.Since(started))
+		if err != nil {
+			bt.logger.Error("Upload failed", LogFieldFile, file, errAttr(err))
+			bt.stats.Errors++
+			result.fail(err)
+			bt.afterUpload(ctx, result, err)
+			return result
+		}
+		bt.stats.Uploaded++
+		result.Outcome = bt.uploadedOutcome()
+		result.ETag = etag
+
+		if !bt.config.DryRun {
+			if confirm := bt.verifyFile(ctx, file); confirm.Status != VerifyArchived {
+				err := fmt.Errorf("upload could not be confirmed (%s)", confirm.Status)
+				bt.logger.Error("Verification failed", LogFieldFile, file, errAttr(err))
+				bt.stats.Errors++
+				result.setError(err)
+				return result
+			}
+		}
+		if !bt.afterUpload(ctx, result, nil) {
+			return result
+		}
+	default:
+		err := fmt.Errorf("failed to check S3 for %s: %s", file, entry.Error)
+		bt.logger.Error("Archive check failed", LogFieldFile, file, errAttr(err))
+		bt.stats.Errors++
+		result.fail(err)
+		return result
+	}
+
+	// Keep the local copy until the retention period has passed
+	if !bt.pastRetention(file) {
+		bt.logger.Info("Retained locally (within retention)", LogFieldFile, file)
+		bt.stats.Retained++
+		return result
+	}
+
+	if err := bt.deleteArchivedFile(ctx, result); err != nil {
+		if errors.Is(err, errHookVeto) {
+			return result
+		}
+		bt.logger.Error("Delete failed", LogFieldFile, file, errAttr(err))
+		bt.stats.Errors++
+		result.setError(err)
+		return result
+	}
+	bt.stats.Deleted++
+	result.Deleted = !bt.config.DryRun
+	return result
+}
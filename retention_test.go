@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestValidateRetention tests validation of the local retention period
+func TestValidateRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"No retention", Config{Period: "1 day"}, false},
+		{"Retention longer than period", Config{Period: "1 day", Retention: "7 days"}, false},
+		{"Retention equal to period", Config{Period: "7 days", Retention: "7 days"}, false},
+		{"Retention shorter than period", Config{Period: "7 days", Retention: "1 day"}, true},
+		{"Invalid retention", Config{Period: "1 day", Retention: "forever"}, true},
+		{"Combined with delete", Config{Period: "1 day", Retention: "7 days", DeleteAfterUpload: true}, true},
+		{"Combined with bundle", Config{Period: "1 day", Retention: "7 days", Bundle: BundleByDate}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRetention(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPastRetention tests comparing file dates against the retention cutoff
+func TestPastRetention(t *testing.T) {
+	bt := &BackupTool{retentionCutoff: time.Date(2024, 12, 10, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"/var/log/app20241201.log", true},
+		{"/var/log/app20241209.log", true},
+		{"/var/log/app20241210.log", false},
+		{"/var/log/app20241215.log", false},
+		{"/var/log/nodate.log", false},
+	}
+
+	for _, tt := range tests {
+		if got := bt.pastRetention(tt.file); got != tt.want {
+			t.Errorf("pastRetention(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+// TestNewBackupToolRetention tests that the retention cutoff is calculated
+func TestNewBackupToolRetention(t *testing.T) {
+	tool, err := NewBackupTool(Config{Period: "1 day", Retention: "7 days"})
+	if err != nil {
+		t.Fatalf("NewBackupTool() error = %v", err)
+	}
+	if !tool.retentionCutoff.Before(tool.cutoffTime) {
+		t.Errorf("retention cutoff %v should be before upload cutoff %v", tool.retentionCutoff, tool.cutoffTime)
+	}
+	if !tool.retentionCutoff.AddDate(0, 0, 6).Equal(tool.cutoffTime) {
+		t.Errorf("retention cutoff %v should be 6 days before upload cutoff %v", tool.retentionCutoff, tool.cutoffTime)
+	}
+
+	if _, err := NewBackupTool(Config{Period: "7 days", Retention: "1 day"}); err == nil {
+		t.Error("NewBackupTool() should reject retention shorter than period")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Store is an ObjectStore backed by an S3 bucket
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Store(client *s3.Client, bucket string) *s3Store {
+	return &s3Store{client: client, bucket: bucket}
+}
+
+func (s *s3Store) Check(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot access S3 bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+func (s *s3Store) Put(ctx context.Context, req *PutRequest) (string, error) {
+	// Ask the SDK to send a SHA-256 checksum so S3 validates the payload and
+	// restore/verify can compare against it later
+	input := &s3.PutObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(req.Key),
+		Body:              req.Body,
+		StorageClass:      types.StorageClass(req.StorageClass),
+		ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		Metadata:          req.Metadata,
+	}
+	if req.Tagging != "" {
+		input.Tagging = aws.String(req.Tagging)
+	}
+	if req.LegalHold {
+		input.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+	}
+	if req.ObjectLockMode != "" {
+		input.ObjectLockMode = types.ObjectLockMode(req.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(req.RetainUntil)
+	}
+
+	out, err := s.client.PutObject(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return strings.Trim(aws.ToString(out.ETag), `"`), nil
+}
+
+func (s *s3Store) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:            key,
+		Size:           aws.ToInt64(head.ContentLength),
+		LastModified:   aws.ToTime(head.LastModified),
+		ETag:           strings.Trim(aws.ToString(head.ETag), `"`),
+		StorageClass:   string(head.StorageClass),
+		Metadata:       head.Metadata,
+		ChecksumSHA256: aws.ToString(head.ChecksumSHA256),
+		Encryption:     string(head.ServerSideEncryption),
+		ArchiveStatus:  string(head.ArchiveStatus),
+		Restore:        aws.ToString(head.Restore),
+	}, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(s.bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil, errObjectNotFound
+		}
+		return nil, nil, err
+	}
+	return out.Body, &ObjectInfo{
+		Key:            key,
+		Size:           aws.ToInt64(out.ContentLength),
+		LastModified:   aws.ToTime(out.LastModified),
+		ETag:           strings.Trim(aws.ToString(out.ETag), `"`),
+		StorageClass:   string(out.StorageClass),
+		Metadata:       out.Metadata,
+		ChecksumSHA256: aws.ToString(out.ChecksumSHA256),
+		Encryption:     string(out.ServerSideEncryption),
+	}, nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Contents {
+			err := fn(ObjectInfo{
+				Key:          aws.ToString(item.Key),
+				Size:         aws.ToInt64(item.Size),
+				LastModified: aws.ToTime(item.LastModified),
+				ETag:         strings.Trim(aws.ToString(item.ETag), `"`),
+				StorageClass: string(item.StorageClass),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Delete removes the objects in batches of up to 1000 keys
+func (s *s3Store) Delete(ctx context.Context, keys []string) (map[string]error, error) {
+	failed := make(map[string]error)
+	for start := 0; start < len(keys); start += deleteObjectsBatchSize {
+		end := start + deleteObjectsBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		identifiers := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			identifiers = append(identifiers, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: identifiers, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return failed, err
+		}
+		for _, failure := range out.Errors {
+			failed[aws.ToString(failure.Key)] = fmt.Errorf("%s: %s", aws.ToString(failure.Code), aws.ToString(failure.Message))
+		}
+	}
+	return failed, nil
+}
+
+// maxCopyObjectSize is the largest object a single CopyObject can copy;
+// larger objects are copied in parts
+const maxCopyObjectSize = 5 << 30
+
+// copyPartSize is the part size of a multipart copy, which keeps objects up
+// to the 5 TB limit under 10000 parts
+const copyPartSize = 1 << 30
+
+// setStorageClass copies an object onto itself with a new storage class,
+// keeping its metadata, tags and server-side encryption
+func (s *s3Store) setStorageClass(ctx context.Context, key, storageClass string) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	if aws.ToInt64(head.ContentLength) > maxCopyObjectSize {
+		return s.copyMultipart(ctx, key, storageClass, head)
+	}
+
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(copySource(s.bucket, key)),
+		CopySourceIfMatch:    head.ETag,
+		StorageClass:         types.StorageClass(storageClass),
+		MetadataDirective:    types.MetadataDirectiveCopy,
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+	})
+	return err
+}
+
+// copyMultipart copies an object larger than maxCopyObjectSize onto itself
+// with UploadPartCopy. Unlike CopyObject, a multipart upload does not copy
+// the metadata and tags, so they are taken from the source.
+func (s *s3Store) copyMultipart(ctx context.Context, key, storageClass string, head *s3.HeadObjectOutput) error {
+	tags, err := s.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	tagging := make(url.Values)
+	for _, tag := range tags.TagSet {
+		tagging.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+
+	upload, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(key),
+		StorageClass:         types.StorageClass(storageClass),
+		Metadata:             head.Metadata,
+		ContentType:          head.ContentType,
+		ContentEncoding:      head.ContentEncoding,
+		ContentDisposition:   head.ContentDisposition,
+		ContentLanguage:      head.ContentLanguage,
+		CacheControl:         head.CacheControl,
+		Tagging:              aws.String(tagging.Encode()),
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+	})
+	if err != nil {
+		return err
+	}
+	abort := func() {
+		// Best effort; a lifecycle rule cleans up what is left behind
+		s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+	}
+
+	size := aws.ToInt64(head.ContentLength)
+	var parts []types.CompletedPart
+	for start, number := int64(0), int32(1); start < size; start, number = start+copyPartSize, number+1 {
+		end := min(start+copyPartSize, size) - 1
+		part, err := s.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:            aws.String(s.bucket),
+			Key:               aws.String(key),
+			UploadId:          upload.UploadId,
+			PartNumber:        aws.Int32(number),
+			CopySource:        aws.String(copySource(s.bucket, key)),
+			CopySourceIfMatch: head.ETag,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			abort()
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+	}
+	return err
+}
+
+// restoreArchived requests a temporary copy of an object in an archive tier.
+// days is omitted when zero, as Intelligent-Tiering archive tiers reject it.
+func (s *s3Store) restoreArchived(ctx context.Context, key, tier string, days int) error {
+	request := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(tier)},
+	}
+	if days > 0 {
+		request.Days = aws.Int32(int32(days))
+	}
+	_, err := s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		RestoreRequest: request,
+	})
+	return err
+}
+
+// checkObjectLockEnabled fails unless the bucket has Object Lock enabled;
+// S3 rejects lock settings on buckets created without it
+func (s *s3Store) checkObjectLockEnabled(ctx context.Context) error {
+	enabled, err := s.objectLockEnabled(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("bucket %s does not have Object Lock enabled", s.bucket)
+	}
+	return nil
+}
+
+// objectLockEnabled reports whether the bucket has Object Lock enabled
+func (s *s3Store) objectLockEnabled(ctx context.Context) (bool, error) {
+	out, err := s.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		var apiErr interface{ ErrorCode() string }
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot read Object Lock configuration of bucket %s: %w", s.bucket, err)
+	}
+	return out.ObjectLockConfiguration != nil && out.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled, nil
+}
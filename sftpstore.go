@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultSFTPPort is used when -sftp-host has no port
+const DefaultSFTPPort = "22"
+
+// sftpFS is the dirFS of a directory on an SFTP server
+type sftpFS struct {
+	client *sftp.Client
+}
+
+func (f sftpFS) Create(name string) (io.WriteCloser, error) { return f.client.Create(name) }
+func (f sftpFS) Open(name string) (io.ReadCloser, error)    { return f.client.Open(name) }
+func (f sftpFS) Stat(name string) (os.FileInfo, error)      { return f.client.Stat(name) }
+func (f sftpFS) ReadDir(name string) ([]os.FileInfo, error) { return f.client.ReadDir(name) }
+func (f sftpFS) Remove(name string) error                   { return f.client.Remove(name) }
+func (f sftpFS) MkdirAll(name string) error                 { return f.client.MkdirAll(name) }
+
+// Rename replaces newname if it exists, like os.Rename. Plain SFTP rename
+// fails on an existing target, so the OpenSSH extension is used.
+func (f sftpFS) Rename(oldname, newname string) error {
+	return f.client.PosixRename(oldname, newname)
+}
+
+// sftpStore is a directory store on an SFTP server
+type sftpStore struct {
+	*dirStore
+	conn   *ssh.Client
+	client *sftp.Client
+	// agentConn is the connection to the SSH agent, nil without one
+	agentConn net.Conn
+}
+
+// newSFTPStore connects to the SFTP server. The host key is checked against
+// known_hosts; authentication uses -sftp-key, the SSH agent and the
+// SFTP_PASSWORD environment variable, in that order.
+func newSFTPStore(config Config) (*sftpStore, error) {
+	sshConfig, agentConn, err := sftpClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	closeAgent := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	addr := config.SFTPHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultSFTPPort)
+	}
+	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	if err != nil {
+		closeAgent()
+		return nil, fmt.Errorf("cannot connect to SFTP server %s: %w", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		closeAgent()
+		return nil, fmt.Errorf("cannot start SFTP session on %s: %w", addr, err)
+	}
+
+	return &sftpStore{
+		dirStore:  &dirStore{fs: sftpFS{client: client}, root: config.SFTPDir},
+		conn:      conn,
+		client:    client,
+		agentConn: agentConn,
+	}, nil
+}
+
+// Close ends the SFTP session, the SSH connection and the connection to the
+// SSH agent
+func (s *sftpStore) Close() error {
+	s.client.Close()
+	if s.agentConn != nil {
+		s.agentConn.Close()
+	}
+	return s.conn.Close()
+}
+
+// sftpClientConfig builds the SSH client configuration for the SFTP backend.
+// It also returns the connection to the SSH agent, if any, which the caller
+// must close.
+func sftpClientConfig(config Config) (*ssh.ClientConfig, net.Conn, error) {
+	userName := config.SFTPUser
+	if userName == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot determine SFTP user (use -sftp-user): %w", err)
+		}
+		userName = current.Username
+	}
+
+	knownHostsFile := config.SFTPKnownHosts
+	if knownHostsFile == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot locate known_hosts (use -sftp-known-hosts): %w", err)
+		}
+		knownHostsFile = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known_hosts %s: %w", knownHostsFile, err)
+	}
+
+	var auth []ssh.AuthMethod
+	var agentConn net.Conn
+	if config.SFTPKey != "" {
+		key, err := os.ReadFile(config.SFTPKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read SFTP key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse SFTP key %s: %w", config.SFTPKey, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			agentConn = conn
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	if password := os.Getenv("SFTP_PASSWORD"); password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, nil, fmt.Errorf("no SFTP credentials: use -sftp-key, an SSH agent or SFTP_PASSWORD")
+	}
+
+	return &ssh.ClientConfig{
+		User:            userName,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, agentConn, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// pipeConn joins the halves of two pipes into one connection
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// TestSFTPStore runs the directory store tests over an in-process SFTP server
+func TestSFTPStore(t *testing.T) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server, err := sftp.NewServer(pipeConn{serverReader, serverWriter})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		server.Close()
+		t.Fatalf("NewClientPipe() error = %v", err)
+	}
+	// Closing the server first ends the client's read loop
+	defer func() {
+		server.Close()
+		client.Close()
+	}()
+
+	testObjectStore(t, &dirStore{fs: sftpFS{client: client}, root: t.TempDir()})
+}
+
+// TestSFTPAgentConnClosed tests that the connection to the SSH agent is
+// closed when the SFTP server cannot be reached
+func TestSFTPAgentConnClosed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SSH agents listen on Unix sockets")
+	}
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing listens on the port of the closed listener
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	config := Config{SFTPHost: closed.Addr().String(), SFTPUser: "backup", SFTPKnownHosts: knownHosts, SFTPDir: "/logs"}
+	if _, err := newSFTPStore(config); err == nil {
+		t.Fatal("Expected newSFTPStore() to fail")
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() from the agent connection = %v, want EOF", err)
+	}
+}
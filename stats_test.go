@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"log"
+	"log/slog"
 	"strings"
 	"testing"
 	"time"
@@ -40,7 +40,7 @@ func TestStats(t *testing.T) {
 func TestLogSummary(t *testing.T) {
 	// Create a buffer to capture log output
 	var buf bytes.Buffer
-	logger := log.New(&buf, "", 0)
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
 
 	// Create BackupTool with test data
 	bt := &BackupTool{
@@ -61,14 +61,14 @@ func TestLogSummary(t *testing.T) {
 	// Check output
 	output := buf.String()
 	expectedLines := []string{
-		"=== Backup Summary ===",
-		"Glob pattern: *YYYYMMDD.log.gz",
-		"Cutoff time: 2024-11-01 00:00:00",
-		"Total files: 5",
-		"Uploaded: 4",
-		"Deleted: 4",
-		"Skipped: 1",
-		"Errors: 0",
+		`msg="Backup summary"`,
+		"pattern=*YYYYMMDD.log.gz",
+		`cutoff="2024-11-01 00:00:00"`,
+		"total_files=5",
+		"uploaded=4",
+		"deleted=4",
+		"skipped=1",
+		"errors=0",
 	}
 
 	for _, expected := range expectedLines {
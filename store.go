@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Storage backends
+const (
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+	BackendAzure = "azblob"
+	BackendLocal = "local"
+	BackendSFTP  = "sftp"
+)
+
+// errObjectNotFound is returned by ObjectStore.Head and Get for missing keys
+var errObjectNotFound = errors.New("object not found")
+
+// ObjectStore is a storage backend archived logs are written to and read
+// back from. Keys are always "/"-separated, whatever the backend.
+type ObjectStore interface {
+	// Check verifies that the destination exists and is reachable
+	Check(ctx context.Context) error
+	// Put uploads an object, replacing any existing object with the same
+	// key, and returns its ETag, or "" if the backend does not report one
+	Put(ctx context.Context, req *PutRequest) (string, error)
+	// Head returns an object's attributes and metadata without its content
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// Get opens an object for reading; the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// List calls fn for each object whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+	// Delete removes the objects, returning the keys that could not be deleted
+	Delete(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// PutRequest describes an object to upload
+type PutRequest struct {
+	Key          string
+	Body         io.Reader
+	StorageClass string
+	Metadata     map[string]string
+	// Tagging is the URL-encoded tag set, e.g. "app=nginx&env=production"
+	Tagging string
+	// Object Lock settings, only supported by S3
+	ObjectLockMode string
+	RetainUntil    time.Time
+	LegalHold      bool
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	// ETag is the S3 ETag; GCS and Azure report the hex MD5 of the content
+	ETag         string
+	StorageClass string
+	Metadata     map[string]string
+	// ChecksumSHA256 is the base64 SHA-256 of the content, empty if unknown
+	ChecksumSHA256 string
+	// Encryption is the server-side encryption, e.g. "aws:kms"
+	Encryption string
+	// ArchiveStatus and Restore describe S3 archive tiers and restores
+	ArchiveStatus string
+	Restore       string
+}
+
+// addStoreFlags adds the storage backend flags shared by all subcommands
+func addStoreFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.Destination, "dest", "", "Destination URL replacing -backend, -bucket and -prefix (e.g. s3://my-logs/logs/{year}/{month}, file:///mnt/archive/logs)")
+	fs.StringVar(&config.Backend, "backend", BackendS3, "Storage backend (s3, gcs, azblob, local, sftp)")
+	fs.StringVar(&config.GCSCredentials, "gcs-credentials", "", "Service account key file for -backend gcs (default: Application Default Credentials)")
+	fs.StringVar(&config.AzureAccount, "azure-account", "", "Storage account name for -backend azblob")
+	fs.StringVar(&config.LocalDir, "local-dir", "", "Archive directory for -backend local (e.g. an NFS mount)")
+	fs.StringVar(&config.SFTPHost, "sftp-host", "", "SFTP server as host[:port] for -backend sftp")
+	fs.StringVar(&config.SFTPUser, "sftp-user", "", "SFTP user name (default: current user)")
+	fs.StringVar(&config.SFTPKey, "sftp-key", "", "SSH private key file for SFTP authentication")
+	fs.StringVar(&config.SFTPKnownHosts, "sftp-known-hosts", "", "known_hosts file used to verify the SFTP server (default: ~/.ssh/known_hosts)")
+	fs.StringVar(&config.SFTPDir, "sftp-dir", "", "Archive directory on the SFTP server")
+}
+
+// validateStore checks that the options for the selected backend are set
+func validateStore(config Config) error {
+	switch config.Backend {
+	case "", BackendS3:
+		if config.S3Bucket == "" {
+			return fmt.Errorf("S3 bucket name is required (use -bucket flag)")
+		}
+		return nil
+	case BackendGCS, BackendAzure:
+		if config.S3Bucket == "" {
+			return fmt.Errorf("-backend %s requires -bucket", config.Backend)
+		}
+		if config.Backend == BackendGCS && len(config.ObjectTags) > 0 {
+			return fmt.Errorf("-tag is not supported with -backend gcs (GCS has no object tags)")
+		}
+		if config.Backend == BackendAzure && config.AzureAccount == "" && config.EndpointURL == "" && os.Getenv("AZURE_STORAGE_CONNECTION_STRING") == "" {
+			return fmt.Errorf("-backend azblob requires -azure-account, -endpoint-url or AZURE_STORAGE_CONNECTION_STRING")
+		}
+	case BackendLocal:
+		if config.LocalDir == "" {
+			return fmt.Errorf("-backend local requires -local-dir")
+		}
+	case BackendSFTP:
+		if config.SFTPHost == "" || config.SFTPDir == "" {
+			return fmt.Errorf("-backend sftp requires -sftp-host and -sftp-dir")
+		}
+	default:
+		return fmt.Errorf("invalid backend: %s (supported: %s, %s, %s, %s, %s)", config.Backend, BackendS3, BackendGCS, BackendAzure, BackendLocal, BackendSFTP)
+	}
+
+	// Features that only exist in S3
+	if config.ObjectLockMode != "" || config.LegalHold {
+		return fmt.Errorf("Object Lock is only supported with -backend s3")
+	}
+	if config.PartitionsOutput != "" {
+		return fmt.Errorf("-partitions-output is only supported with -backend s3")
+	}
+	return nil
+}
+
+// isS3Backend reports whether the configuration targets S3
+func isS3Backend(config Config) bool {
+	return config.Backend == "" || config.Backend == BackendS3
+}
+
+// initStore connects to the configured storage backend
+func (bt *BackupTool) initStore(ctx context.Context) error {
+	switch bt.config.Backend {
+	case BackendGCS:
+		store, err := newGCSStore(ctx, bt.config)
+		if err != nil {
+			return err
+		}
+		bt.store = store
+	case BackendAzure:
+		store, err := newAzureStore(bt.config)
+		if err != nil {
+			return err
+		}
+		bt.store = store
+	case BackendLocal:
+		bt.store = newLocalStore(bt.config.LocalDir)
+	case BackendSFTP:
+		store, err := newSFTPStore(bt.config)
+		if err != nil {
+			return err
+		}
+		bt.store = store
+	default:
+		return bt.initAWS(ctx)
+	}
+
+	if err := bt.store.Check(ctx); err != nil {
+		return err
+	}
+	bt.logger.Info("Storage backend initialized", "destination", bt.objectURL(""))
+	return nil
+}
+
+// objectURL returns a URL for a key on the configured backend, for log
+// messages and reports
+func (bt *BackupTool) objectURL(key string) string {
+	switch bt.config.Backend {
+	case BackendGCS:
+		return fmt.Sprintf("gs://%s/%s", bt.config.S3Bucket, key)
+	case BackendAzure:
+		return fmt.Sprintf("azblob://%s/%s", bt.config.S3Bucket, key)
+	case BackendLocal:
+		return "file://" + path.Join(strings.TrimSuffix(bt.config.LocalDir, "/"), key)
+	case BackendSFTP:
+		host := bt.config.SFTPHost
+		if bt.config.SFTPUser != "" {
+			host = bt.config.SFTPUser + "@" + host
+		}
+		dir := bt.config.SFTPDir
+		if !strings.HasPrefix(dir, "/") {
+			dir = "/" + dir
+		}
+		return "sftp://" + host + path.Join(dir, key)
+	default:
+		return fmt.Sprintf("s3://%s/%s", bt.config.S3Bucket, key)
+	}
+}
+
+// storeCloser is implemented by stores holding a connection
+type storeCloser interface {
+	Close() error
+}
+
+// closeStore releases the connection of the store, if any
+func (bt *BackupTool) closeStore() {
+	if closer, ok := bt.store.(storeCloser); ok {
+		closer.Close()
+	}
+}
+
+// contentMD5 returns the MD5 of a seekable body and rewinds it, so backends
+// that take the checksum up front can have the upload verified. It returns
+// nil for other readers.
+func contentMD5(body io.Reader) ([]byte, error) {
+	// Hashing reads the local file, which must not count against -bwlimit
+	if throttled, ok := body.(*throttledReader); ok {
+		body = throttled.r
+	}
+	seeker, ok := body.(io.ReadSeeker)
+	if !ok {
+		return nil, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil
+	}
+	h := md5.New() // #nosec G401 -- only used as an upload integrity check
+	if _, err := io.Copy(h, seeker); err != nil {
+		return nil, fmt.Errorf("failed to calculate MD5: %w", err)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind body: %w", err)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestValidateStore tests validation of the storage backend options
+func TestValidateStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"S3 with bucket", Config{S3Bucket: "logs"}, false},
+		{"S3 without bucket", Config{Backend: BackendS3}, true},
+		{"Local with directory", Config{Backend: BackendLocal, LocalDir: "/mnt/archive"}, false},
+		{"Local without directory", Config{Backend: BackendLocal}, true},
+		{"SFTP with host and directory", Config{Backend: BackendSFTP, SFTPHost: "nas:2222", SFTPDir: "/archive"}, false},
+		{"SFTP without directory", Config{Backend: BackendSFTP, SFTPHost: "nas"}, true},
+		{"Object Lock on local", Config{Backend: BackendLocal, LocalDir: "/mnt/archive", LegalHold: true}, true},
+		{"GCS with bucket", Config{Backend: BackendGCS, S3Bucket: "logs"}, false},
+		{"GCS without bucket", Config{Backend: BackendGCS}, true},
+		{"GCS with tags", Config{Backend: BackendGCS, S3Bucket: "logs", ObjectTags: []string{"app=nginx"}}, true},
+		{"Azure with account", Config{Backend: BackendAzure, S3Bucket: "logs", AzureAccount: "archive"}, false},
+		{"Azure with endpoint", Config{Backend: BackendAzure, S3Bucket: "logs", EndpointURL: "http://127.0.0.1:10000/devstoreaccount1"}, false},
+		{"Azure without account", Config{Backend: BackendAzure, S3Bucket: "logs"}, true},
+		{"Object Lock on Azure", Config{Backend: BackendAzure, S3Bucket: "logs", AzureAccount: "archive", ObjectLockMode: "GOVERNANCE"}, true},
+		{"Unknown backend", Config{Backend: "ftp"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStore(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestObjectURL tests the URLs used to report object locations
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{S3Bucket: "my-logs"}, "s3://my-logs/logs/app.log"},
+		{Config{Backend: BackendGCS, S3Bucket: "my-logs"}, "gs://my-logs/logs/app.log"},
+		{Config{Backend: BackendAzure, S3Bucket: "my-logs"}, "azblob://my-logs/logs/app.log"},
+		{Config{Backend: BackendLocal, LocalDir: "/mnt/archive/"}, "file:///mnt/archive/logs/app.log"},
+		{Config{Backend: BackendSFTP, SFTPHost: "nas", SFTPUser: "backup", SFTPDir: "archive"}, "sftp://backup@nas/archive/logs/app.log"},
+	}
+
+	for _, tt := range tests {
+		bt := &BackupTool{config: tt.config}
+		if got := bt.objectURL("logs/app.log"); got != tt.want {
+			t.Errorf("objectURL() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+// TestDirStore tests writing, reading, listing and deleting objects in a
+// local directory store
+func TestDirStore(t *testing.T) {
+	testObjectStore(t, newLocalStore(t.TempDir()))
+}
+
+// testDirStore exercises an ObjectStore the way the backup, verify and
+// restore commands use it
+func testObjectStore(t *testing.T, store ObjectStore) {
+	ctx := context.Background()
+	if err := store.Check(ctx); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	for _, key := range []string{"logs/2024/12/app-20241215.log", "logs/2024/12/db-20241215.log", "logs/2024/11/app-20241130.log", "other/app.log"} {
+		_, err := store.Put(ctx, &PutRequest{
+			Key:      key,
+			Body:     strings.NewReader("content of " + key),
+			Metadata: map[string]string{"original-path": "/var/log/" + filepath.Base(key)},
+			Tagging:  "app=test",
+		})
+		if err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+
+	info, err := store.Head(ctx, "logs/2024/12/app-20241215.log")
+	if err != nil {
+		t.Fatalf("Head() error = %v", err)
+	}
+	if info.Size != int64(len("content of logs/2024/12/app-20241215.log")) {
+		t.Errorf("Head().Size = %d", info.Size)
+	}
+	if info.Metadata["original-path"] != "/var/log/app-20241215.log" {
+		t.Errorf("Head().Metadata = %v", info.Metadata)
+	}
+	if checksumKind(info.ChecksumSHA256, info.ETag, info.Encryption) == "size-only" {
+		t.Error("Head() should return a checksum recorded on upload")
+	}
+	if _, err := store.Head(ctx, "logs/missing.log"); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("Head(missing) error = %v, want errObjectNotFound", err)
+	}
+
+	body, _, err := store.Get(ctx, "other/app.log")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "content of other/app.log" {
+		t.Errorf("Get() content = %q", data)
+	}
+
+	var keys []string
+	err = store.List(ctx, "logs/2024/1", func(obj ObjectInfo) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []string{"logs/2024/11/app-20241130.log", "logs/2024/12/app-20241215.log", "logs/2024/12/db-20241215.log"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	failed, err := store.Delete(ctx, []string{"logs/2024/12/db-20241215.log", "logs/missing.log"})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Delete() = %v, %v", failed, err)
+	}
+	if _, err := store.Head(ctx, "logs/2024/12/db-20241215.log"); !errors.Is(err, errObjectNotFound) {
+		t.Errorf("Object should be deleted, Head() error = %v", err)
+	}
+}
+
+// TestContentMD5 tests that the MD5 is taken from the current position and
+// the body is rewound for the upload
+func TestContentMD5(t *testing.T) {
+	body := strings.NewReader("skip:log line\n")
+	body.Seek(5, io.SeekStart)
+
+	sum, err := contentMD5(body)
+	if err != nil {
+		t.Fatalf("contentMD5() error = %v", err)
+	}
+	want := md5.Sum([]byte("log line\n"))
+	if !bytes.Equal(sum, want[:]) {
+		t.Errorf("contentMD5() = %x, want %x", sum, want)
+	}
+	if rest, _ := io.ReadAll(body); string(rest) != "log line\n" {
+		t.Errorf("Body not rewound, remaining %q", rest)
+	}
+
+	if sum, err := contentMD5(io.MultiReader(strings.NewReader("x"))); sum != nil || err != nil {
+		t.Errorf("contentMD5(non-seeker) = %x, %v, want nil", sum, err)
+	}
+}
+
+// TestDirStoreRejectsInvalidKeys tests that keys cannot escape the root or
+// collide with sidecar files
+func TestDirStoreRejectsInvalidKeys(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStore(filepath.Join(root, "archive"))
+	os.Mkdir(filepath.Join(root, "archive"), 0755)
+
+	if _, err := store.Put(context.Background(), &PutRequest{Key: "../../etc/app.log", Body: strings.NewReader("x")}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "archive", "etc", "app.log")); err != nil {
+		t.Errorf("Key with .. should stay below the root: %v", err)
+	}
+
+	for _, key := range []string{"", "logs/.app.log.meta.json"} {
+		if _, err := store.Put(context.Background(), &PutRequest{Key: key, Body: strings.NewReader("x")}); err == nil {
+			t.Errorf("Put(%q) should fail", key)
+		}
+	}
+}
+
+// TestUploadAndVerifyWithLocalStore tests the upload and verify paths
+// against a local directory instead of S3
+func TestUploadAndVerifyWithLocalStore(t *testing.T) {
+	sourceDir := t.TempDir()
+	filePath := filepath.Join(sourceDir, "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archiveDir := t.TempDir()
+	bt := &BackupTool{
+		config: Config{Backend: BackendLocal, LocalDir: archiveDir, S3Prefix: "logs/{year}/{month}"},
+		logger: slog.New(slog.DiscardHandler),
+		store:  newLocalStore(archiveDir),
+	}
+	if _, err := bt.uploadToS3(context.Background(), filePath); err != nil {
+		t.Fatalf("uploadToS3() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(archiveDir, "logs", "2024", "12", "app-20241215.log")); err != nil {
+		t.Fatalf("Uploaded file not found: %v", err)
+	}
+
+	entry := bt.verifyFile(context.Background(), filePath)
+	if entry.Status != VerifyArchived || entry.Checksum != "sha256" {
+		t.Errorf("verifyFile() = %s (%s), want %s with sha256", entry.Status, entry.Error, VerifyArchived)
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored.log")
+	if _, err := bt.downloadObject(context.Background(), "logs/2024/12/app-20241215.log", restored); err != nil {
+		t.Fatalf("downloadObject() error = %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// parseBandwidth parses a rate such as "512K", "10M" or "1.5MB/s" into bytes
+// per second
+func parseBandwidth(s string) (float64, error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "/s"), "/S")
+	n, err := parseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth '%s' (e.g. 512K, 10M or 1G per second)", s)
+	}
+	return n, nil
+}
+
+// newBandwidthLimiter creates the limiter for a -bwlimit rate, or nil if
+// the rate is empty. The burst is one second of traffic.
+func newBandwidthLimiter(s string) (*rate.Limiter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	bps, err := parseBandwidth(s)
+	if err != nil {
+		return nil, err
+	}
+	burst := int(math.Min(math.Max(bps, 1), math.MaxInt32))
+	return rate.NewLimiter(rate.Limit(bps), burst), nil
+}
+
+// throttledReader limits the rate at which an upload body is read. Every
+// limiter must allow the bytes, so the global and the per-destination
+// limits both apply. Only bytes past the furthest offset read so far are
+// charged: the SDKs rewind the body to hash it for a checksum or payload
+// signature and to retry, and those passes must not count twice.
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+	// offset is the position in the body and charged the furthest offset
+	// already paid for
+	offset, charged int64
+}
+
+// throttle wraps an upload body in the global and per-destination limiters
+func (bt *BackupTool) throttle(ctx context.Context, body io.Reader) io.Reader {
+	var limiters []*rate.Limiter
+	for _, l := range []*rate.Limiter{bt.bandwidth, bt.destBandwidth} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	if len(limiters) == 0 {
+		return body
+	}
+	t := &throttledReader{ctx: ctx, r: body, limiters: limiters}
+	if seeker, ok := body.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			t.offset, t.charged = offset, offset
+		}
+	}
+	return t
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// WaitN fails for more than the burst, so read at most that much
+	for _, l := range t.limiters {
+		if burst := l.Burst(); len(p) > burst {
+			p = p[:burst]
+		}
+	}
+	n, err := t.r.Read(p)
+	t.offset += int64(n)
+	if charge := int(t.offset - t.charged); charge > 0 {
+		t.charged = t.offset
+		for _, l := range t.limiters {
+			if waitErr := l.WaitN(t.ctx, charge); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// Seek lets the SDKs rewind the body to retry or to compute its length
+func (t *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := t.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("upload body is not seekable")
+	}
+	position, err := seeker.Seek(offset, whence)
+	if err == nil {
+		t.offset = position
+	}
+	return position, err
+}
+
+// Stat exposes the size of a file body, e.g. for tracing
+func (t *throttledReader) Stat() (os.FileInfo, error) {
+	file, ok := t.r.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return nil, errors.New("upload body is not a file")
+	}
+	return file.Stat()
+}
+
+// uploadWindow is a daily time range in local time during which uploads may
+// run. A window whose end is before its start spans midnight.
+type uploadWindow struct {
+	start, end time.Duration
+}
+
+// parseUploadWindows parses -upload-window values such as "22:00-06:00".
+// Each value may list several windows separated by commas.
+func parseUploadWindows(values []string) ([]uploadWindow, error) {
+	var windows []uploadWindow
+	for _, value := range values {
+		for _, raw := range strings.Split(value, ",") {
+			raw = strings.TrimSpace(raw)
+			from, to, ok := strings.Cut(raw, "-")
+			start, err1 := parseClock(from)
+			end, err2 := parseClock(to)
+			if !ok || err1 != nil || err2 != nil || start == end {
+				return nil, fmt.Errorf("invalid upload window '%s' (expected HH:MM-HH:MM, e.g. 22:00-06:00)", raw)
+			}
+			windows = append(windows, uploadWindow{start: start, end: end})
+		}
+	}
+	return windows, nil
+}
+
+// parseClock parses HH:MM into the time since midnight; 24:00 is the end
+// of the day
+func parseClock(s string) (time.Duration, error) {
+	hour, minute, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, err1 := strconv.Atoi(hour)
+	m, err2 := strconv.Atoi(minute)
+	if !ok || err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time '%s'", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// contains reports whether the time of day falls in the window
+func (w uploadWindow) contains(clock time.Duration) bool {
+	if w.start < w.end {
+		return clock >= w.start && clock < w.end
+	}
+	return clock >= w.start || clock < w.end
+}
+
+// nextWindowStart returns when uploads may run, which is now if a window is
+// open
+func nextWindowStart(windows []uploadWindow, now time.Time) time.Time {
+	if len(windows) == 0 {
+		return now
+	}
+	// Wall clock times, so windows keep their hours across DST changes
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	var next time.Time
+	for _, w := range windows {
+		if w.contains(clock) {
+			return now
+		}
+		start := clockTime(now, 0, w.start)
+		if !start.After(now) {
+			start = clockTime(now, 1, w.start)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// clockTime returns the given time of day, days after the day of t
+func clockTime(t time.Time, days int, clock time.Duration) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, int(clock/time.Minute), 0, 0, t.Location())
+}
+
+// waitForUploadWindow blocks until an upload window is open. Uploads in
+// progress are not interrupted; the next file waits.
+func (bt *BackupTool) waitForUploadWindow(ctx context.Context) error {
+	now := time.Now()
+	next := nextWindowStart(bt.uploadWindows, now)
+	if !next.After(now) {
+		return nil
+	}
+	if bt.config.DryRun {
+		bt.logger.Info("DRY RUN: Would wait for the upload window", "until", next.Format(time.RFC3339))
+		return nil
+	}
+
+	bt.logger.Info("Outside upload window, pausing", "until", next.Format(time.RFC3339))
+	timer := time.NewTimer(next.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		bt.logger.Info("Upload window open, resuming")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validateThrottle checks the bandwidth and upload window options
+func validateThrottle(config Config) error {
+	if config.BandwidthLimit != "" {
+		if _, err := parseBandwidth(config.BandwidthLimit); err != nil {
+			return fmt.Errorf("invalid -bwlimit: %w", err)
+		}
+	}
+	if config.DestinationBandwidth != "" {
+		if _, err := parseBandwidth(config.DestinationBandwidth); err != nil {
+			return fmt.Errorf("invalid bwlimit in destination: %w", err)
+		}
+	}
+	_, err := parseUploadWindows(config.UploadWindows)
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestParseBandwidth tests parsing -bwlimit rates
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"512K", 512 << 10, false},
+		{"10M", 10 << 20, false},
+		{"1.5MB/s", 1.5 * (1 << 20), false},
+		{"2gib", 2 << 30, false},
+		{"", 0, true},
+		{"0", 0, true},
+		{"10X", 0, true},
+		{"-5M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBandwidth(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBandwidth(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestThrottledReader tests that the body is read at the limited rate, and
+// that hashing the body for GCS and Azure or reading it again is not throttled
+func TestThrottledReader(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "app-20241215.log")
+	data := bytes.Repeat([]byte("log line\n"), 150<<10/9)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	limiter, err := newBandwidthLimiter("100K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &BackupTool{bandwidth: limiter}
+	body := bt.throttle(context.Background(), file)
+
+	started := time.Now()
+	if _, err := contentMD5(body); err != nil {
+		t.Fatalf("contentMD5() error = %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Errorf("contentMD5() took %v, expected the hash not to be throttled", elapsed)
+	}
+	if size, ok := bodySize(body); !ok || size != int64(len(data)) {
+		t.Errorf("bodySize() = %d, %v; want %d", size, ok, len(data))
+	}
+
+	// The first second of traffic is the burst, the rest waits
+	started = time.Now()
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Throttled body differs from the file")
+	}
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Errorf("Reading %d bytes at 100K/s took %v, expected about 0.5s", len(data), elapsed)
+	}
+
+	// Rewinding to sign or retry must not charge the same bytes again
+	started = time.Now()
+	if _, err := body.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	if got, err := io.ReadAll(body); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll() after rewinding = %d bytes, %v", len(got), err)
+	}
+	if elapsed := time.Since(started); elapsed > 200*time.Millisecond {
+		t.Errorf("Reading the body again took %v, expected it not to be throttled", elapsed)
+	}
+
+	if got := (&BackupTool{}).throttle(context.Background(), file); got != io.Reader(file) {
+		t.Error("Expected the body to be unchanged without limits")
+	}
+}
+
+// TestDestinationBandwidth tests the bwlimit destination parameter, which
+// replicas do not inherit from the primary destination
+func TestDestinationBandwidth(t *testing.T) {
+	config := Config{Destination: "s3://my-logs/logs?bwlimit=5M", BandwidthLimit: "20M"}
+	if err := applyDestination(&config); err != nil {
+		t.Fatalf("applyDestination() error = %v", err)
+	}
+	if config.DestinationBandwidth != "5M" {
+		t.Errorf("DestinationBandwidth = %q, want 5M", config.DestinationBandwidth)
+	}
+
+	config.Replicas = []string{"file:///mnt/nas/logs?bwlimit=1M", "file:///mnt/backup/logs"}
+	bt, err := NewBackupTool(Config{Period: "1 day", LogLevel: "error", S3Bucket: config.S3Bucket, S3Prefix: config.S3Prefix,
+		BandwidthLimit: config.BandwidthLimit, DestinationBandwidth: config.DestinationBandwidth, Replicas: config.Replicas})
+	if err != nil {
+		t.Fatalf("NewBackupTool() error = %v", err)
+	}
+	if bt.bandwidth == nil || bt.destBandwidth == nil || bt.destBandwidth.Limit() != 5<<20 {
+		t.Fatalf("Expected global and destination limiters, got %v and %v", bt.bandwidth, bt.destBandwidth)
+	}
+	nas, backup := bt.replicas[0].tool, bt.replicas[1].tool
+	if nas.bandwidth != bt.bandwidth || backup.bandwidth != bt.bandwidth {
+		t.Error("Expected replicas to share the global limiter")
+	}
+	if nas.destBandwidth == nil || nas.destBandwidth.Limit() != 1<<20 || backup.destBandwidth != nil {
+		t.Errorf("Unexpected replica limiters %v and %v", nas.destBandwidth, backup.destBandwidth)
+	}
+
+	if _, _, err := replicaConfig(Config{S3Bucket: "my-logs", S3Prefix: "logs"}, "file:///mnt/nas/logs?bwlimit=fast"); err == nil {
+		t.Error("Expected an invalid bwlimit to fail")
+	}
+}
+
+// TestNextWindowStart tests upload windows, including ones spanning midnight
+func TestNextWindowStart(t *testing.T) {
+	windows, err := parseUploadWindows([]string{"22:00-06:00", "12:00-13:30"})
+	if err != nil {
+		t.Fatalf("parseUploadWindows() error = %v", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 12, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{at(15, 23, 0), at(15, 23, 0)},
+		{at(15, 5, 59), at(15, 5, 59)},
+		{at(15, 12, 30), at(15, 12, 30)},
+		{at(15, 6, 0), at(15, 12, 0)},
+		{at(15, 13, 30), at(15, 22, 0)},
+		{at(15, 21, 59), at(15, 22, 0)},
+	}
+	for _, tt := range tests {
+		if got := nextWindowStart(windows, tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextWindowStart(%s) = %s, want %s", tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+
+	windows, _ = parseUploadWindows([]string{"01:00-02:00"})
+	if got, want := nextWindowStart(windows, at(15, 3, 0)), at(16, 1, 0); !got.Equal(want) {
+		t.Errorf("nextWindowStart() = %s, want the next day at %s", got, want)
+	}
+	if got := nextWindowStart(nil, at(15, 3, 0)); !got.Equal(at(15, 3, 0)) {
+		t.Error("Expected uploads to run at any time without windows")
+	}
+
+	for _, invalid := range []string{"22:00", "25:00-06:00", "22:00-22:00", "22:60-23:00", "ab-cd"} {
+		if _, err := parseUploadWindows([]string{invalid}); err == nil {
+			t.Errorf("parseUploadWindows(%q) should fail", invalid)
+		}
+	}
+}
+
+// TestWaitForUploadWindow tests that a closed window pauses until the
+// context is cancelled
+func TestWaitForUploadWindow(t *testing.T) {
+	now := time.Now()
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	closed := uploadWindow{start: (clock + 2*time.Hour) % (24 * time.Hour), end: (clock + 3*time.Hour) % (24 * time.Hour)}
+	bt := &BackupTool{logger: slog.New(slog.DiscardHandler), uploadWindows: []uploadWindow{closed}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := bt.waitForUploadWindow(ctx); err == nil {
+		t.Error("Expected the wait to end with the context")
+	}
+
+	bt.uploadWindows = append(bt.uploadWindows, uploadWindow{start: (clock + 23*time.Hour) % (24 * time.Hour), end: (clock + time.Hour) % (24 * time.Hour)})
+	if err := bt.waitForUploadWindow(context.Background()); err != nil {
+		t.Errorf("waitForUploadWindow() error = %v inside an open window", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace exporters
+const (
+	TraceExporterOTLP   = "otlp"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// Span attribute names
+const (
+	TraceAttrFile         = "backup.file"
+	TraceAttrKey          = "backup.key"
+	TraceAttrBytes        = "backup.bytes"
+	TraceAttrStorageClass = "backup.storage_class"
+	TraceAttrOutcome      = "backup.outcome"
+	TraceAttrCount        = "backup.count"
+)
+
+// tracer returns the tracer of the tool from the global tracer provider, so
+// spans are dropped unless tracing is enabled
+func tracer() trace.Tracer {
+	return otel.Tracer("backup-log-to-s3")
+}
+
+// validateTracing checks the tracing options
+func validateTracing(config Config) error {
+	switch config.TraceExporter {
+	case "", TraceExporterOTLP, TraceExporterStdout:
+	case TraceExporterFile:
+		if config.TraceFile == "" {
+			return fmt.Errorf("-trace file requires -trace-file")
+		}
+	default:
+		return fmt.Errorf("invalid -trace '%s' (supported: %s, %s, %s)", config.TraceExporter, TraceExporterOTLP, TraceExporterStdout, TraceExporterFile)
+	}
+	if config.TraceEndpoint != "" && config.TraceExporter != TraceExporterOTLP {
+		return fmt.Errorf("-trace-endpoint requires -trace otlp")
+	}
+	return nil
+}
+
+// initTracing installs the tracer provider for the configured exporter and
+// returns the function that flushes and stops it. Without an exporter it
+// does nothing.
+func initTracing(ctx context.Context, config Config) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch config.TraceExporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case TraceExporterOTLP:
+		// The endpoint, headers and TLS settings also follow the standard
+		// OTEL_EXPORTER_OTLP_* environment variables
+		var opts []otlptracehttp.Option
+		if config.TraceEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(config.TraceEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(consoleOutput(config)))
+	case TraceExporterFile:
+		file, err = os.OpenFile(config.TraceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName("backup-log-to-s3"), semconv.ServiceVersion(Version)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startFileSpan starts the span of a single file
+func startFileSpan(ctx context.Context, filePath, storageClass string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "backup.file", trace.WithAttributes(
+		attribute.String(TraceAttrFile, filePath),
+		attribute.String(TraceAttrStorageClass, storageClass),
+	))
+}
+
+// endFileSpan records the result of a file and ends its span
+func endFileSpan(span trace.Span, result *fileResult) {
+	span.SetAttributes(
+		attribute.String(TraceAttrKey, result.Key),
+		attribute.Int64(TraceAttrBytes, result.Size),
+		attribute.String(TraceAttrOutcome, result.Outcome),
+	)
+	if result.Error != "" {
+		span.SetStatus(codes.Error, result.Error)
+	}
+	span.End()
+}
+
+// addS3Tracing adds a span for every S3 API call to the SDK middleware stack
+func addS3Tracing(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TraceS3Call", traceS3Call), middleware.After)
+}
+
+// traceS3Call runs one S3 API call, including its retries, in a client span
+func traceS3Call(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	operation := awsmiddleware.GetOperationName(ctx)
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("aws-api"),
+		semconv.RPCService("S3"),
+		semconv.RPCMethod(operation),
+	}
+	switch input := in.Parameters.(type) {
+	case *s3.PutObjectInput:
+		attrs = append(attrs, semconv.AWSS3Bucket(derefString(input.Bucket)), semconv.AWSS3Key(derefString(input.Key)),
+			attribute.String(TraceAttrStorageClass, string(input.StorageClass)))
+		if size, ok := bodySize(input.Body); ok {
+			attrs = append(attrs, attribute.Int64(TraceAttrBytes, size))
+		}
+	case *s3.HeadObjectInput:
+		attrs = append(attrs, semconv.AWSS3Bucket(derefString(input.Bucket)), semconv.AWSS3Key(derefString(input.Key)))
+	case *s3.GetObjectInput:
+		attrs = append(attrs, semconv.AWSS3Bucket(derefString(input.Bucket)), semconv.AWSS3Key(derefString(input.Key)))
+	case *s3.ListObjectsV2Input:
+		attrs = append(attrs, semconv.AWSS3Bucket(derefString(input.Bucket)))
+	case *s3.DeleteObjectsInput:
+		attrs = append(attrs, semconv.AWSS3Bucket(derefString(input.Bucket)))
+		if input.Delete != nil {
+			attrs = append(attrs, attribute.Int(TraceAttrCount, len(input.Delete.Objects)))
+		}
+	}
+
+	ctx, span := tracer().Start(ctx, "S3."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	out, metadata, err := next.HandleInitialize(ctx, in)
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	endSpan(span, err)
+	return out, metadata, err
+}
+
+// bodySize returns the size of a seekable request body
+func bodySize(body io.Reader) (int64, bool) {
+	if file, ok := body.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := file.Stat(); err == nil {
+			return info.Size(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that records ended spans for the
+// duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanAttr returns the value of a span attribute
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// TestRunSpans tests the run, file and hash spans of a run
+func TestRunSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	sourceDir := t.TempDir()
+	filePath := filepath.Join(sourceDir, "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bt, err := NewBackupTool(Config{
+		Backend:      BackendLocal,
+		LocalDir:     t.TempDir(),
+		S3Prefix:     "logs",
+		StorageClass: "GLACIER_IR",
+		Period:       "1 day",
+		LockFile:     filepath.Join(t.TempDir(), "backup.lock"),
+		Manifest:     true,
+		LogLevel:     "error",
+	})
+	if err != nil {
+		t.Fatalf("NewBackupTool() error = %v", err)
+	}
+	if err := bt.Run(context.Background(), filepath.Join(sourceDir, "app-YYYYMMDD.log")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	run, file, hash := spans["backup.run"], spans["backup.file"], spans["backup.hash"]
+	if run == nil || file == nil || hash == nil || spans["backup.find_files"] == nil {
+		t.Fatalf("Missing spans, got %v", spans)
+	}
+	if file.Parent().SpanID() != run.SpanContext().SpanID() || hash.Parent().SpanID() != file.SpanContext().SpanID() {
+		t.Error("Expected run > file > hash span hierarchy")
+	}
+	if got := spanAttr(file, TraceAttrBytes).AsInt64(); got != 9 {
+		t.Errorf("%s = %d, want 9", TraceAttrBytes, got)
+	}
+	if got := spanAttr(file, TraceAttrStorageClass).AsString(); got != "GLACIER_IR" {
+		t.Errorf("%s = %s, want GLACIER_IR", TraceAttrStorageClass, got)
+	}
+	if got := spanAttr(file, TraceAttrOutcome).AsString(); got != OutcomeUploaded {
+		t.Errorf("%s = %s, want %s", TraceAttrOutcome, got, OutcomeUploaded)
+	}
+}
+
+// TestS3TracingMiddleware tests the span of an S3 API call
+func TestS3TracingMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+
+	filePath := filepath.Join(t.TempDir(), "app-20241215.log")
+	if err := os.WriteFile(filePath, []byte("log line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	client := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient: smithyhttp.ClientDoFunc(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}),
+		APIOptions: []func(*middleware.Stack) error{addS3Tracing},
+	})
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:       aws.String("my-logs"),
+		Key:          aws.String("logs/app-20241215.log"),
+		Body:         file,
+		StorageClass: "STANDARD_IA",
+	})
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "S3.PutObject" {
+		t.Fatalf("Expected one S3.PutObject span, got %d", len(spans))
+	}
+	span := spans[0]
+	for key, want := range map[string]string{
+		"aws.s3.bucket":       "my-logs",
+		"aws.s3.key":          "logs/app-20241215.log",
+		TraceAttrStorageClass: "STANDARD_IA",
+	} {
+		if got := spanAttr(span, key).AsString(); got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+	if got := spanAttr(span, TraceAttrBytes).AsInt64(); got != 9 {
+		t.Errorf("%s = %d, want 9", TraceAttrBytes, got)
+	}
+	if got := spanAttr(span, "http.response.status_code").AsInt64(); got != http.StatusOK {
+		t.Errorf("http.response.status_code = %d, want %d", got, http.StatusOK)
+	}
+}
+
+// TestInitTracingFile tests the file exporter used for offline runs
+func TestInitTracingFile(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	path := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := initTracing(context.Background(), Config{TraceExporter: TraceExporterFile, TraceFile: path})
+	if err != nil {
+		t.Fatalf("initTracing() error = %v", err)
+	}
+	_, span := tracer().Start(context.Background(), "backup.run")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"backup.run"`) || !strings.Contains(string(data), "backup-log-to-s3") {
+		t.Errorf("Unexpected trace file:\n%s", data)
+	}
+}
+
+// TestValidateTracing tests the tracing options
+func TestValidateTracing(t *testing.T) {
+	tests := []struct {
+		config  Config
+		wantErr bool
+	}{
+		{Config{}, false},
+		{Config{TraceExporter: TraceExporterOTLP, TraceEndpoint: "http://collector:4318"}, false},
+		{Config{TraceExporter: TraceExporterStdout}, false},
+		{Config{TraceExporter: TraceExporterFile}, true},
+		{Config{TraceExporter: "jaeger"}, true},
+		{Config{TraceEndpoint: "http://collector:4318"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateTracing(tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("validateTracing(%+v) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+		}
+	}
+}
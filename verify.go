@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- only used to compare against S3 ETags
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Verification statuses
+const (
+	VerifyArchived         = "archived"
+	VerifyMissing          = "missing"
+	VerifySizeMismatch     = "size-mismatch"
+	VerifyChecksumMismatch = "checksum-mismatch"
+	VerifyRemoteOnly       = "s3-only"
+	VerifyError            = "error"
+)
+
+// verifyEntry is the verification result for one local file or S3 object
+type verifyEntry struct {
+	Path       string `json:"path,omitempty"`
+	Key        string `json:"key"`
+	Status     string `json:"status"`
+	LocalSize  int64  `json:"local_size,omitempty"`
+	RemoteSize int64  `json:"remote_size,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// verifyReport is the full result of a verify run
+type verifyReport struct {
+	Bucket        string         `json:"bucket"`
+	Prefix        string         `json:"prefix"`
+	GlobPattern   string         `json:"glob_pattern"`
+	Cutoff        time.Time      `json:"cutoff"`
+	Counts        map[string]int `json:"counts"`
+	Discrepancies int            `json:"discrepancies"`
+	Entries       []verifyEntry  `json:"entries"`
+}
+
+// add records an entry and updates the counts
+func (r *verifyReport) add(entry verifyEntry) {
+	r.Entries = append(r.Entries, entry)
+	r.Counts[entry.Status]++
+	switch entry.Status {
+	case VerifyMissing, VerifySizeMismatch, VerifyChecksumMismatch, VerifyError:
+		r.Discrepancies++
+	}
+}
+
+// parseVerifyFlags parses the arguments of the verify subcommand
+func parseVerifyFlags(args []string) (Config, string, string, error) {
+	var config Config
+	var format string
+
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.StringVar(&config.S3Bucket, "bucket", "", "S3 bucket name (required with -backend s3)")
+	fs.StringVar(&config.S3Prefix, "prefix", "", "S3 prefix template the logs were uploaded with (e.g. logs/{year}/{month}) (required)")
+	fs.StringVar(&config.OutputFile, "output", "", "Output log file path (logs go to stderr if not specified)")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Verbose logging")
+	fs.StringVar(&config.JobName, "job", "", "Job name the logs were uploaded with, for prefixes using {job}")
+	fs.StringVar(&config.Layout, "layout", "", "Key layout preset the logs were uploaded with (hive)")
+	fs.StringVar(&format, "format", "text", "Report format (text, json)")
+	addLogFlags(fs, &config)
+	addStoreFlags(fs, &config)
+	addAWSFlags(fs, &config)
+	addResourceFlags(fs, &config)
+	fs.Usage = func() { showVerifyUsage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return config, "", "", err
+	}
+
+	var errs []string
+	var globPattern string
+	if fs.NArg() != 2 {
+		errs = append(errs, "Both period and glob pattern are required")
+	} else {
+		config.Period = fs.Arg(0)
+		globPattern = fs.Arg(1)
+		if err := validateGlobPattern(globPattern); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := applyDestination(&config); err != nil {
+		errs = append(errs, err.Error())
+	} else if err := validateStore(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if config.S3Prefix == "" && config.Destination == "" {
+		errs = append(errs, "S3 prefix is required (use -prefix flag or -dest)")
+	} else if err := validateLayout(config); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		config.S3Prefix = layoutPrefix(config.Layout, config.S3Prefix)
+		if err := validatePrefixTemplate(config, globPattern); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if format != "text" && format != "json" {
+		errs = append(errs, fmt.Sprintf("invalid format: %s (supported: text, json)", format))
+	}
+	if err := validateResources(config); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return config, "", "", errors.New(strings.Join(errs, "\n"))
+	}
+	return config, globPattern, format, nil
+}
+
+func showVerifyUsage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `Usage: %s verify [OPTIONS] <period> <glob_pattern>
+
+Read-only audit comparing local files against S3. The same glob pattern and
+period as the backup run select the local files. Each file is reported as
+archived, missing, size-mismatch or checksum-mismatch, and S3 objects with no
+local counterpart are listed as s3-only. Exits non-zero on discrepancies.
+
+OPTIONS:
+`, os.Args[0])
+	fs.PrintDefaults()
+	fmt.Fprintf(fs.Output(), `
+EXAMPLES:
+  %s verify -bucket my-logs -prefix "logs/YYYY/MM" "1 day" "/var/log/app-YYYYMMDD.log.gz"
+  %s verify -bucket my-logs -prefix logs -format json "7 days" "*YYYYMMDD.log.gz"
+`, os.Args[0], os.Args[0])
+}
+
+// runVerify is the entry point of the verify subcommand
+func runVerify(args []string) error {
+	config, globPattern, format, err := parseVerifyFlags(args)
+	if err != nil {
+		return &configError{err}
+	}
+
+	logger, err := newReportLogger(config)
+	if err != nil {
+		return err
+	}
+	applyResourceLimits(config, logger)
+
+	cutoffTime, err := calculateCutoffTime(config.Period)
+	if err != nil {
+		return fmt.Errorf("invalid period '%s': %w", config.Period, err)
+	}
+	bt := &BackupTool{config: config, logger: logger, cutoffTime: cutoffTime}
+
+	ctx := context.Background()
+	if err := bt.initStore(ctx); err != nil {
+		return err
+	}
+	defer bt.closeStore()
+
+	report, err := bt.Verify(ctx, globPattern)
+	if err != nil {
+		return err
+	}
+	if err := writeVerifyReport(os.Stdout, report, format); err != nil {
+		return err
+	}
+	if report.Discrepancies > 0 {
+		return fmt.Errorf("verification found %d discrepancies", report.Discrepancies)
+	}
+	return nil
+}
+
+// Verify compares the local files selected by the glob pattern and cutoff
+// against the objects stored in S3
+func (bt *BackupTool) Verify(ctx context.Context, globPattern string) (*verifyReport, error) {
+	report := &verifyReport{
+		Bucket:      bt.config.S3Bucket,
+		Prefix:      bt.config.S3Prefix,
+		GlobPattern: globPattern,
+		Cutoff:      bt.cutoffTime,
+		Counts:      make(map[string]int),
+		Entries:     []verifyEntry{},
+	}
+
+	files, err := bt.findTargetFiles(globPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]bool, len(files))
+	for _, file := range files {
+		entry := bt.verifyFile(ctx, file)
+		expected[entry.Key] = true
+		report.add(entry)
+	}
+
+	// Objects under the prefix that match the pattern and cutoff but have
+	// no local counterpart, e.g. because they were deleted after upload
+	objects, err := bt.listArchivedObjects(ctx, time.Time{}, bt.cutoffTime.AddDate(0, 0, -1), path.Base(convertGlobPattern(globPattern)))
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		if expected[obj.Key] {
+			continue
+		}
+		report.add(verifyEntry{Key: obj.Key, Status: VerifyRemoteOnly, RemoteSize: obj.Size})
+	}
+
+	return report, nil
+}
+
+// verifyFile checks a single local file against its S3 object
+func (bt *BackupTool) verifyFile(ctx context.Context, filePath string) verifyEntry {
+	entry := verifyEntry{Path: filePath}
+
+	key, err := bt.objectKey(filePath)
+	if err != nil {
+		entry.Status = VerifyError
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Key = key
+
+	_, span := tracer().Start(ctx, "backup.hash", trace.WithAttributes(attribute.String(TraceAttrFile, filePath)))
+	shaSum, md5Sum, size, err := fileDigests(filePath)
+	endSpan(span, err, attribute.Int64(TraceAttrBytes, size))
+	if err != nil {
+		entry.Status = VerifyError
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.LocalSize = size
+
+	head, err := bt.store.Head(ctx, key)
+	if err != nil {
+		if errors.Is(err, errObjectNotFound) {
+			entry.Status = VerifyMissing
+			return entry
+		}
+		entry.Status = VerifyError
+		entry.Error = err.Error()
+		return entry
+	}
+
+	entry.RemoteSize = head.Size
+	if entry.RemoteSize != size {
+		entry.Status = VerifySizeMismatch
+		return entry
+	}
+
+	entry.Checksum = checksumKind(head.ChecksumSHA256, head.ETag, head.Encryption)
+	if err := verifyDownloadChecksum(head.ChecksumSHA256, head.ETag, head.Encryption, shaSum, md5Sum); err != nil {
+		entry.Status = VerifyChecksumMismatch
+		entry.Error = err.Error()
+		return entry
+	}
+
+	entry.Status = VerifyArchived
+	bt.logger.Debug("Verified", LogFieldFile, filePath, LogFieldKey, bt.objectURL(key), "checksum", entry.Checksum)
+	return entry
+}
+
+// newReportLogger creates the logger for subcommands that print a report to
+// stdout. Log lines go to the output file, or to stderr with -verbose, so they
+// never mix with the report.
+func newReportLogger(config Config) (*slog.Logger, error) {
+	if config.OutputFile != "" {
+		// -verbose only raises the level; log lines never go to stdout
+		if config.Verbose && config.LogLevel == "" {
+			config.LogLevel = "debug"
+		}
+		config.Verbose = false
+		return newLogger(config)
+	}
+	if !config.Verbose {
+		return slog.New(slog.DiscardHandler), nil
+	}
+	handler, err := newLogHandler(os.Stderr, config)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(handler), nil
+}
+
+// checksumKind reports which checksum verifyDownloadChecksum will compare
+func checksumKind(checksumSHA256, etag, sse string) string {
+	if checksumSHA256 != "" && !strings.Contains(checksumSHA256, "-") {
+		return "sha256"
+	}
+	etag = strings.Trim(etag, `"`)
+	if etag != "" && !strings.Contains(etag, "-") && !strings.HasPrefix(sse, "aws:kms") {
+		return "md5"
+	}
+	return "size-only"
+}
+
+// fileDigests returns the SHA-256 and MD5 digests and size of a file in one pass
+func fileDigests(filePath string) ([]byte, []byte, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer file.Close()
+
+	sha := sha256.New()
+	sum := md5.New() // #nosec G401 -- only used to compare against S3 ETags
+	size, err := io.Copy(io.MultiWriter(sha, sum), file)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return sha.Sum(nil), sum.Sum(nil), size, nil
+}
+
+// writeVerifyReport writes the report as a table or JSON
+func writeVerifyReport(w io.Writer, report *verifyReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tLOCAL PATH\tS3 KEY\tLOCAL SIZE\tS3 SIZE\tCHECKSUM")
+	for _, entry := range report.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", entry.Status, entry.Path, entry.Key, entry.LocalSize, entry.RemoteSize, entry.Checksum)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	statuses := make([]string, 0, len(report.Counts))
+	for status := range report.Counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	fmt.Fprintln(w)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s: %d\n", status, report.Counts[status])
+	}
+	fmt.Fprintf(w, "Discrepancies: %d\n", report.Discrepancies)
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec G501 -- test fixture for ETag comparison
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseVerifyFlags tests parsing and validation of verify options
+func TestParseVerifyFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{
+			name: "Valid options",
+			args: []string{"-bucket", "my-logs", "-prefix", "logs", "-format", "json", "1 day", "*YYYYMMDD.log.gz"},
+		},
+		{
+			name:    "Missing glob pattern",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "1 day"},
+			wantErr: true,
+		},
+		{
+			name:    "Glob pattern without date",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "1 day", "*.log.gz"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid format",
+			args:    []string{"-bucket", "my-logs", "-prefix", "logs", "-format", "xml", "1 day", "*YYYYMMDD.log.gz"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, glob, _, err := parseVerifyFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseVerifyFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (config.Period != "1 day" || glob != "*YYYYMMDD.log.gz") {
+				t.Errorf("parseVerifyFlags() period = %q, glob = %q", config.Period, glob)
+			}
+		})
+	}
+}
+
+// TestVerifyReportDiscrepancies tests which statuses count as discrepancies
+func TestVerifyReportDiscrepancies(t *testing.T) {
+	report := &verifyReport{Counts: make(map[string]int)}
+	for _, status := range []string{VerifyArchived, VerifyArchived, VerifyMissing, VerifySizeMismatch, VerifyChecksumMismatch, VerifyRemoteOnly, VerifyError} {
+		report.add(verifyEntry{Key: "logs/" + status, Status: status})
+	}
+
+	if report.Discrepancies != 4 {
+		t.Errorf("Discrepancies = %d, want 4", report.Discrepancies)
+	}
+	if report.Counts[VerifyArchived] != 2 {
+		t.Errorf("Counts[archived] = %d, want 2", report.Counts[VerifyArchived])
+	}
+}
+
+// TestChecksumKind tests choosing the checksum used for comparison
+func TestChecksumKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		etag     string
+		sse      string
+		want     string
+	}{
+		{"SHA-256 available", "abc=", `"d41d8cd98f00b204e9800998ecf8427e"`, "", "sha256"},
+		{"Single part ETag", "", `"d41d8cd98f00b204e9800998ecf8427e"`, "AES256", "md5"},
+		{"Multipart ETag", "", `"d41d8cd98f00b204e9800998ecf8427e-2"`, "", "size-only"},
+		{"KMS ETag", "", `"d41d8cd98f00b204e9800998ecf8427e"`, "aws:kms", "size-only"},
+		{"Composite checksum", "abc=-2", "", "", "size-only"},
+	}
+
+	for _, tt := range tests {
+		if got := checksumKind(tt.checksum, tt.etag, tt.sse); got != tt.want {
+			t.Errorf("%s: checksumKind() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestFileDigests tests computing digests of a local file
+func TestFileDigests(t *testing.T) {
+	content := []byte("log content")
+	path := filepath.Join(t.TempDir(), "app20241215.log")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	shaSum, md5Sum, size, err := fileDigests(path)
+	if err != nil {
+		t.Fatalf("fileDigests() error = %v", err)
+	}
+	wantSHA := sha256.Sum256(content)
+	wantMD5 := md5.Sum(content) // #nosec G401 -- test fixture
+	if hex.EncodeToString(shaSum) != hex.EncodeToString(wantSHA[:]) || hex.EncodeToString(md5Sum) != hex.EncodeToString(wantMD5[:]) {
+		t.Error("fileDigests() returned unexpected digests")
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+}
+
+// TestWriteVerifyReport tests text and JSON report output
+func TestWriteVerifyReport(t *testing.T) {
+	report := &verifyReport{Bucket: "my-logs", Prefix: "logs", Counts: make(map[string]int)}
+	report.add(verifyEntry{Path: "/var/log/app20241215.log", Key: "logs/app20241215.log", Status: VerifyArchived, LocalSize: 10, RemoteSize: 10, Checksum: "sha256"})
+	report.add(verifyEntry{Path: "/var/log/app20241216.log", Key: "logs/app20241216.log", Status: VerifyMissing, LocalSize: 12})
+
+	var text bytes.Buffer
+	if err := writeVerifyReport(&text, report, "text"); err != nil {
+		t.Fatalf("writeVerifyReport(text) error = %v", err)
+	}
+	for _, want := range []string{"STATUS", "logs/app20241216.log", "missing: 1", "Discrepancies: 1"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("Expected text report to contain %q, got: %s", want, text.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeVerifyReport(&out, report, "json"); err != nil {
+		t.Fatalf("writeVerifyReport(json) error = %v", err)
+	}
+	var decoded verifyReport
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if decoded.Discrepancies != 1 || len(decoded.Entries) != 2 {
+		t.Errorf("Decoded report = %+v", decoded)
+	}
+}